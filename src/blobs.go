@@ -0,0 +1,124 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"google.golang.org/genai"
+)
+
+// Sessions in the external-blob format keep image data out of the JSON. Each
+// InlineData part is replaced by a FileData part whose URI names a
+// content-addressed file (sha256 of the bytes plus an extension) in the
+// session's blob directory. Identical images across turns and sessions that
+// share a blob directory are stored once.
+const blobURIPrefix = "agentpix-blob:"
+
+var blobExtensions = map[string]string{
+	"image/png":  ".png",
+	"image/jpeg": ".jpg",
+	"image/webp": ".webp",
+	"image/heic": ".heic",
+	"image/heif": ".heif",
+}
+
+// blobName returns the content-addressed file name for a blob.
+func blobName(blob *genai.Blob) string {
+	sum := sha256.Sum256(blob.Data)
+	ext, ok := blobExtensions[blob.MIMEType]
+	if !ok {
+		ext = ".bin"
+	}
+	return hex.EncodeToString(sum[:]) + ext
+}
+
+// resolveSessionRelative resolves a path stored in a session file, such as its
+// blob directory or parent session. Relative paths are stored relative to the
+// session file's directory.
+func resolveSessionRelative(sessionFile, p string) string {
+	if filepath.IsAbs(p) {
+		return p
+	}
+	return filepath.Join(filepath.Dir(sessionFile), p)
+}
+
+// sessionRelative expresses p relative to the session file's directory when
+// possible, so a session and the files it refers to can be moved together.
+func sessionRelative(sessionFile, p string) string {
+	absPath, err1 := filepath.Abs(p)
+	absSess, err2 := filepath.Abs(filepath.Dir(sessionFile))
+	if err1 != nil || err2 != nil {
+		return p
+	}
+	if rel, err := filepath.Rel(absSess, absPath); err == nil {
+		return rel
+	}
+	return absPath
+}
+
+// externalizeHistory writes every inline image in history to dir and returns
+// a copy of the history with those parts replaced by blob references. The
+// input history is not modified.
+func externalizeHistory(history []*genai.Content, dir string) ([]*genai.Content, error) {
+	out := make([]*genai.Content, len(history))
+	for i, c := range history {
+		if c == nil {
+			continue
+		}
+		cc := *c
+		cc.Parts = make([]*genai.Part, len(c.Parts))
+		for j, p := range c.Parts {
+			if p == nil || p.InlineData == nil || len(p.InlineData.Data) == 0 {
+				cc.Parts[j] = p
+				continue
+			}
+			name := blobName(p.InlineData)
+			path := filepath.Join(dir, name)
+			if _, err := os.Stat(path); err != nil {
+				if err := os.WriteFile(path, p.InlineData.Data, outputPerm); err != nil {
+					return nil, fmt.Errorf("failed to write blob: %v", err)
+				}
+			}
+			pc := *p
+			pc.InlineData = nil
+			pc.FileData = &genai.FileData{FileURI: blobURIPrefix + name, MIMEType: p.InlineData.MIMEType}
+			cc.Parts[j] = &pc
+		}
+		out[i] = &cc
+	}
+	return out, nil
+}
+
+// rehydrateHistory replaces blob references in history with inline data read
+// from dir. History is modified in place.
+func rehydrateHistory(history []*genai.Content, dir string) error {
+	for _, c := range history {
+		if c == nil {
+			continue
+		}
+		for _, p := range c.Parts {
+			if p == nil || p.FileData == nil || !strings.HasPrefix(p.FileData.FileURI, blobURIPrefix) {
+				continue
+			}
+			name := strings.TrimPrefix(p.FileData.FileURI, blobURIPrefix)
+			if name != filepath.Base(name) {
+				return fmt.Errorf("invalid blob reference %q", p.FileData.FileURI)
+			}
+			data, err := os.ReadFile(filepath.Join(dir, name))
+			if err != nil {
+				return fmt.Errorf("missing session image: %v", err)
+			}
+			blob := &genai.Blob{MIMEType: p.FileData.MIMEType, Data: data}
+			if blobName(blob) != name {
+				return fmt.Errorf("session image %s does not match its checksum", name)
+			}
+			p.InlineData = blob
+			p.FileData = nil
+		}
+	}
+	return nil
+}
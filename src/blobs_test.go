@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"google.golang.org/genai"
+)
+
+func blobTestHistory() []*genai.Content {
+	input := []byte("input-image")
+	return testHistory(
+		testTurn{prompt: "edit this", input: testBlob("image/jpeg", input), reply: "done", output: testBlob("image/png", []byte("output-image")), signature: "sig"},
+		testTurn{prompt: "again", input: testBlob("image/jpeg", input)},
+	)
+}
+
+func TestWriteReadSessionExternalBlobs(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "cat.session.json")
+	history := blobTestHistory()
+
+	if err := writeSession(path, sessionData{Model: testFlashName, History: history, BlobDir: "blobs"}); err != nil {
+		t.Fatalf("writeSession: %v", err)
+	}
+
+	// Caller's history is untouched.
+	if history[1].Parts[1].InlineData == nil {
+		t.Fatal("writeSession modified the caller's history")
+	}
+
+	raw, _ := os.ReadFile(path)
+	if bytes.Contains(raw, []byte(`"inlineData"`)) {
+		t.Error("session JSON still contains inline data")
+	}
+	if !bytes.Contains(raw, []byte(blobURIPrefix)) {
+		t.Error("session JSON has no blob references")
+	}
+
+	entries, err := os.ReadDir(filepath.Join(dir, "blobs"))
+	if err != nil {
+		t.Fatalf("blob dir: %v", err)
+	}
+	if len(entries) != 2 {
+		t.Errorf("blob files = %d, want 2 (duplicate input stored once)", len(entries))
+	}
+
+	sess, _, err := readSessionImages(path)
+	if err != nil {
+		t.Fatalf("readSessionImages: %v", err)
+	}
+	out := sess.History[1].Parts[1]
+	if out.InlineData == nil || string(out.InlineData.Data) != "output-image" || out.FileData != nil {
+		t.Fatalf("output part not rehydrated: %+v", out)
+	}
+	if string(out.ThoughtSignature) != "sig" {
+		t.Error("thought signature lost")
+	}
+	if sess.History[0].Parts[1].InlineData.MIMEType != "image/jpeg" {
+		t.Error("input MIME type lost")
+	}
+}
+
+func TestRehydrateErrors(t *testing.T) {
+	t.Run("missing blob file", func(t *testing.T) {
+		dir := t.TempDir()
+		path := filepath.Join(dir, "a.session.json")
+		writeSession(path, sessionData{Model: testFlashName, History: blobTestHistory(), BlobDir: "b"})
+		os.RemoveAll(filepath.Join(dir, "b"))
+		_, _, err := readSessionImages(path)
+		if err == nil || !strings.Contains(err.Error(), "missing session image") {
+			t.Fatalf("err = %v", err)
+		}
+
+		// Commands that need no image bytes still read the session.
+		sess, _, err := readSession(path)
+		if err != nil {
+			t.Fatalf("readSession: %v", err)
+		}
+		if n := countImageParts(sess.History[0].Parts) + countImageParts(sess.History[1].Parts); n != 2 {
+			t.Errorf("image parts by reference = %d, want 2", n)
+		}
+		if _, err := analyzeSession(path); err != nil {
+			t.Errorf("analyzeSession: %v", err)
+		}
+	})
+
+	t.Run("corrupted blob file", func(t *testing.T) {
+		dir := t.TempDir()
+		path := filepath.Join(dir, "a.session.json")
+		writeSession(path, sessionData{Model: testFlashName, History: blobTestHistory(), BlobDir: "b"})
+		entries, _ := os.ReadDir(filepath.Join(dir, "b"))
+		os.WriteFile(filepath.Join(dir, "b", entries[0].Name()), []byte("tampered"), 0644)
+		_, _, err := readSessionImages(path)
+		if err == nil || !strings.Contains(err.Error(), "checksum") {
+			t.Fatalf("err = %v", err)
+		}
+	})
+
+	t.Run("path traversal rejected", func(t *testing.T) {
+		history := []*genai.Content{{Role: "model", Parts: []*genai.Part{
+			{FileData: &genai.FileData{FileURI: blobURIPrefix + "../secret.png", MIMEType: "image/png"}},
+		}}}
+		err := rehydrateHistory(history, t.TempDir())
+		if err == nil || !strings.Contains(err.Error(), "invalid blob reference") {
+			t.Fatalf("err = %v", err)
+		}
+	})
+}
+
+func TestSessionRelative(t *testing.T) {
+	dir := t.TempDir()
+	sess := filepath.Join(dir, "work", "cat.session.json")
+	got := sessionRelative(sess, filepath.Join(dir, "shared"))
+	if got != filepath.Join("..", "shared") {
+		t.Errorf("sessionRelative = %q", got)
+	}
+	if resolved := resolveSessionRelative(sess, got); resolved != filepath.Join(dir, "shared") {
+		t.Errorf("resolveSessionRelative = %q", resolved)
+	}
+}
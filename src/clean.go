@@ -5,12 +5,15 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"path/filepath"
+	"strings"
 )
 
 type sessionInfo struct {
-	Model string
-	Turns int
-	Size  int64
+	Model   string
+	Turns   int
+	Size    int64
+	BlobDir string // blob directory of an external-blob session, resolved
 }
 
 func validateSessionFile(path string) (*sessionInfo, error) {
@@ -25,11 +28,15 @@ func validateSessionFile(path string) (*sessionInfo, error) {
 		}
 	}
 
-	return &sessionInfo{
+	si := &sessionInfo{
 		Model: sess.Model,
 		Turns: (len(sess.History) + 1) / 2,
 		Size:  size,
-	}, nil
+	}
+	if sess.BlobDir != "" {
+		si.BlobDir = resolveSessionRelative(path, sess.BlobDir)
+	}
+	return si, nil
 }
 
 func runClean(args []string) error {
@@ -94,7 +101,11 @@ func runClean(args []string) error {
 		if model == "" {
 			model = "legacy"
 		}
-		fmt.Printf("  %s  model=%s turns=%d size=%s\n", f.path, model, f.info.Turns, formatSize(f.info.Size))
+		fmt.Printf("  %s  model=%s turns=%d size=%s", f.path, model, f.info.Turns, formatSize(f.info.Size))
+		if f.info.BlobDir != "" {
+			fmt.Printf(" blobs=%s", f.info.BlobDir)
+		}
+		fmt.Println()
 		totalSize += f.info.Size
 	}
 
@@ -109,6 +120,7 @@ func runClean(args []string) error {
 
 	var deleted int
 	var freed int64
+	var blobDirs []string
 	for _, f := range files {
 		if err := os.Remove(f.path); err != nil {
 			fmt.Fprintf(os.Stderr, "failed to delete %s: %v\n", f.path, err)
@@ -116,6 +128,9 @@ func runClean(args []string) error {
 		}
 		deleted++
 		freed += f.info.Size
+		if f.info.BlobDir != "" {
+			blobDirs = append(blobDirs, f.info.BlobDir)
+		}
 	}
 
 	fmt.Printf("deleted %d files, freed %s", deleted, formatSize(freed))
@@ -123,10 +138,73 @@ func runClean(args []string) error {
 		fmt.Printf(" (%d skipped)", skipped)
 	}
 	fmt.Println()
+	for _, u := range findUnusedBlobs(dir, blobDirs) {
+		fmt.Printf("%s: %d images (%s) no longer used by sessions in %s; delete them once no other session needs them\n", u.Dir, u.Files, formatSize(u.Size), dir)
+	}
 
 	return nil
 }
 
+type unusedBlobs struct {
+	Dir   string
+	Files int
+	Size  int64
+}
+
+// findUnusedBlobs counts, for each blob directory of a deleted session, the
+// image files that no session left in dir refers to. They are not removed:
+// sessions elsewhere may share the directory.
+func findUnusedBlobs(dir string, blobDirs []string) []unusedBlobs {
+	if len(blobDirs) == 0 {
+		return nil
+	}
+	used := make(map[string]bool)
+	paths, _ := listSessionFiles(dir)
+	for _, p := range paths {
+		sess, _, err := readSession(p)
+		if err != nil || sess.BlobDir == "" {
+			continue
+		}
+		blobDir := resolveSessionRelative(p, sess.BlobDir)
+		for _, c := range sess.History {
+			if c == nil {
+				continue
+			}
+			for _, part := range c.Parts {
+				if isImagePart(part) && part.FileData != nil {
+					used[filepath.Join(blobDir, strings.TrimPrefix(part.FileData.FileURI, blobURIPrefix))] = true
+				}
+			}
+		}
+	}
+	var result []unusedBlobs
+	seen := make(map[string]bool)
+	for _, blobDir := range blobDirs {
+		if seen[blobDir] {
+			continue
+		}
+		seen[blobDir] = true
+		entries, err := os.ReadDir(blobDir)
+		if err != nil {
+			continue
+		}
+		u := unusedBlobs{Dir: blobDir}
+		for _, e := range entries {
+			if e.IsDir() || used[filepath.Join(blobDir, e.Name())] {
+				continue
+			}
+			if info, err := e.Info(); err == nil {
+				u.Files++
+				u.Size += info.Size()
+			}
+		}
+		if u.Files > 0 {
+			result = append(result, u)
+		}
+	}
+	return result
+}
+
 func formatSize(b int64) string {
 	switch {
 	case b >= 1024*1024:
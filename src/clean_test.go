@@ -25,6 +25,57 @@ func writeSessionFile(t *testing.T, dir, name string, sess sessionData) string {
 	return p
 }
 
+// testTurn is one exchange of a test session history. Empty fields leave
+// their part out; a turn without thought, reply, or output has no model
+// content, as when the last request failed.
+type testTurn struct {
+	prompt    string
+	input     *genai.Blob
+	thought   string
+	reply     string
+	output    *genai.Blob
+	signature string // thought signature on the output image
+}
+
+// testHistory builds a user/model history from turns.
+func testHistory(turns ...testTurn) []*genai.Content {
+	var history []*genai.Content
+	for _, turn := range turns {
+		user := &genai.Content{Role: "user"}
+		if turn.prompt != "" {
+			user.Parts = append(user.Parts, &genai.Part{Text: turn.prompt})
+		}
+		if turn.input != nil {
+			user.Parts = append(user.Parts, &genai.Part{InlineData: turn.input})
+		}
+		history = append(history, user)
+
+		model := &genai.Content{Role: "model"}
+		if turn.thought != "" {
+			model.Parts = append(model.Parts, &genai.Part{Text: turn.thought, Thought: true})
+		}
+		if turn.reply != "" {
+			model.Parts = append(model.Parts, &genai.Part{Text: turn.reply})
+		}
+		if turn.output != nil {
+			part := &genai.Part{InlineData: turn.output}
+			if turn.signature != "" {
+				part.ThoughtSignature = []byte(turn.signature)
+			}
+			model.Parts = append(model.Parts, part)
+		}
+		if len(model.Parts) > 0 {
+			history = append(history, model)
+		}
+	}
+	return history
+}
+
+// testBlob returns an inline image blob.
+func testBlob(mimeType string, data []byte) *genai.Blob {
+	return &genai.Blob{MIMEType: mimeType, Data: data}
+}
+
 func TestValidateSessionFile(t *testing.T) {
 	tests := []struct {
 		name      string
@@ -292,6 +343,21 @@ func TestRunClean(t *testing.T) {
 				}
 			},
 		},
+		{
+			name: "deletes compressed session files",
+			args: func(t *testing.T) []string {
+				dir := makeDir(t)
+				if err := writeSession(filepath.Join(dir, "c.session.json.gz"), sessionData{Model: "flash", History: []*genai.Content{}}); err != nil {
+					t.Fatal(err)
+				}
+				return []string{"-f", dir}
+			},
+			checkAfter: func(t *testing.T, dir string) {
+				if _, err := os.Stat(filepath.Join(dir, "c.session.json.gz")); err == nil {
+					t.Error("compressed session file was not deleted")
+				}
+			},
+		},
 		{
 			name: "missing directory argument",
 			args: func(t *testing.T) []string {
@@ -340,3 +406,41 @@ func TestRunClean(t *testing.T) {
 		})
 	}
 }
+
+func TestRunCleanExternalBlobs(t *testing.T) {
+	dir := t.TempDir()
+	// a and b share one input image; a's output is its own.
+	a := filepath.Join(dir, "a.session.json")
+	b := filepath.Join(dir, "b.session.json")
+	if err := writeSession(a, sessionData{Model: testFlashName, History: blobTestHistory(), BlobDir: "blobs"}); err != nil {
+		t.Fatal(err)
+	}
+	shared := testHistory(testTurn{prompt: "again", input: testBlob("image/jpeg", []byte("input-image"))})
+	if err := writeSession(b, sessionData{Model: testFlashName, History: shared, BlobDir: "blobs"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// A session is validated without reading its images.
+	os.Rename(filepath.Join(dir, "blobs"), filepath.Join(dir, "moved"))
+	si, err := validateSessionFile(a)
+	if err != nil {
+		t.Fatalf("session with missing blob directory: %v", err)
+	}
+	if si.BlobDir != filepath.Join(dir, "blobs") {
+		t.Errorf("BlobDir = %q", si.BlobDir)
+	}
+	os.Rename(filepath.Join(dir, "moved"), filepath.Join(dir, "blobs"))
+
+	os.Remove(a)
+	unused := findUnusedBlobs(dir, []string{si.BlobDir, si.BlobDir})
+	if len(unused) != 1 || unused[0].Files != 1 || unused[0].Size != int64(len("output-image")) {
+		t.Errorf("unused blobs = %+v, want a's output only", unused)
+	}
+
+	if err := runClean([]string{"-f", dir}); err != nil {
+		t.Fatal(err)
+	}
+	if entries, _ := os.ReadDir(filepath.Join(dir, "blobs")); len(entries) != 2 {
+		t.Errorf("clean removed blob files: %d left, want 2", len(entries))
+	}
+}
@@ -1,36 +1,89 @@
 package main
 
 import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 )
 
 type costBreakdown struct {
-	File         string
-	Model        string
-	Size         string // resolved size for pricing: "1K", "2K", "4K"
-	SizeFromData bool   // true if session contained explicit size data
-	Turns        int
-	OutputImages int
-	Usage        *usageData
-	InputCost    float64
-	OutputCost   float64
-	ImageCost    float64
-	Total        float64
+	File           string
+	Dir            string    // directory containing the session, relative to the scan root
+	Time           time.Time // last recorded turn, or file mtime for sessions without timestamps
+	Model          string
+	Size           string // resolved size for pricing: "1K", "2K", "4K"
+	SizeFromData   bool   // true if session contained explicit size data
+	Turns          int
+	OutputImages   int
+	Usage          *usageData
+	InputCost      float64 // uncached prompt tokens
+	CachedCost     float64
+	OutputCost     float64
+	ThoughtsCost   float64
+	ImageCost      float64
+	Total          float64
+	FromImage      bool     // estimated from PNG metadata rather than a session file
+	PriceTables    []string // effective dates of the price tables applied
+	PredatesPrices bool     // some turn is older than the model's oldest price table
+}
+
+// priceTableAt returns the model's price table in effect at t. When t predates
+// every table, the oldest table is returned with ok=false.
+func priceTableAt(def modelDef, t time.Time) (table priceTable, ok bool) {
+	if len(def.Prices) == 0 {
+		return priceTable{}, false
+	}
+	table = def.Prices[0]
+	for _, p := range def.Prices {
+		start, err := time.Parse(time.DateOnly, p.Effective)
+		if err != nil || t.Before(start) {
+			break
+		}
+		table, ok = p, true
+	}
+	return table, ok
+}
+
+// currentPrices returns the newest price table for a model.
+func currentPrices(def modelDef) priceTable {
+	if len(def.Prices) == 0 {
+		return priceTable{}
+	}
+	return def.Prices[len(def.Prices)-1]
+}
+
+func imagePrice(table priceTable, size string) float64 {
+	if price, ok := table.ImagePrices[size]; ok {
+		return price
+	}
+	return table.ImagePrices["1K"]
 }
 
 func analyzeSession(path string) (*costBreakdown, error) {
+	return analyzeSessionTurns(path, nil)
+}
+
+// analyzeSessionTurns is analyzeSession for directory scans. The session's
+// turns are added to priced, so images continued from it whose own session is
+// gone do not count those turns again.
+func analyzeSessionTurns(path string, priced map[string]bool) (*costBreakdown, error) {
 	sess, _, err := readSession(path)
 	if err != nil {
 		return nil, err
 	}
-
-	// Resolve model name (aliases to pinned names)
-	model := sess.Model
-	if pinned, ok := modelAliases[model]; ok {
-		model = pinned
+	if priced != nil {
+		for _, key := range sessionTurnKeys(sess) {
+			priced[key] = true
+		}
 	}
 
 	// Count output images from model-role parts
@@ -39,86 +92,450 @@ func analyzeSession(path string) (*costBreakdown, error) {
 		if c == nil || c.Role != "model" {
 			continue
 		}
-		for _, p := range c.Parts {
-			if p != nil && p.InlineData != nil && len(p.InlineData.Data) > 0 {
-				outputImages++
+		outputImages += countImageParts(c.Parts)
+	}
+
+	cb := newCostBreakdown(path, sess.Model, sess.Size)
+	cb.Turns = (len(sess.History) + 1) / 2
+	cb.OutputImages = outputImages
+	priceBreakdown(cb, sessionUsage(sess), fileModTime(path))
+	return cb, nil
+}
+
+// errImported marks images whose metadata was converted by meta import; they
+// were not generated through the API and cost nothing.
+var errImported = errors.New("no API cost: metadata imported")
+
+// errTurnsPriced marks an image whose turns were all counted already from
+// another image of the same session during a directory scan.
+var errTurnsPriced = errors.New("all turns already priced")
+
+// analyzeImage estimates cost from the metadata embedded in a generated PNG,
+// for images whose session file no longer exists. Each user prompt in the
+// recorded history is counted as one generated image. Images written with
+// usage data are priced exactly; older ones are priced on images alone.
+func analyzeImage(path string) (*costBreakdown, error) {
+	return analyzeImageTurns(path, nil)
+}
+
+// analyzeImageTurns is analyzeImage for directory scans. Every image of a
+// continued session carries the whole history, so turns whose keys are in
+// priced are left out and the rest are added to it; each turn is then
+// counted once however many images of the chain are present.
+func analyzeImageTurns(path string, priced map[string]bool) (*costBreakdown, error) {
+	meta, err := readImageMetadata(path)
+	if err != nil {
+		return nil, err
+	}
+	if meta.ImportedFrom != "" {
+		return nil, fmt.Errorf("%w from %s", errImported, foreignToolNames[meta.ImportedFrom])
+	}
+
+	var turns int
+	for _, p := range meta.Prompts {
+		if p.Role == "user" {
+			turns++
+		}
+	}
+	if turns == 0 {
+		turns = 1
+	}
+
+	usage := meta.Usage
+	if priced != nil {
+		keys := imageTurnKeys(meta)
+		var fresh []usageData
+		var dropped int
+		for i, key := range keys {
+			if priced[key] {
+				dropped++
+				continue
+			}
+			priced[key] = true
+			if len(meta.Usage) > 0 {
+				fresh = append(fresh, meta.Usage[i])
+			}
+		}
+		if dropped > 0 {
+			if dropped == len(keys) {
+				return nil, errTurnsPriced
 			}
+			turns = max(1, turns-dropped)
+			usage = fresh
 		}
 	}
 
-	turns := (len(sess.History) + 1) / 2
+	cb := newCostBreakdown(path, meta.Model, meta.Size)
+	cb.FromImage = true
+	cb.Turns = turns
+	cb.OutputImages = max(turns, sumUsageImages(usage))
+	created := fileModTime(path)
+	if t, err := time.Parse(time.RFC3339, meta.Timestamp); err == nil {
+		created = t
+	}
+	priceBreakdown(cb, usage, created)
+	return cb, nil
+}
+
+// imageTurnKeys identifies each turn recorded in an image's metadata: by its
+// usage entry when usage was recorded, otherwise by the prompt history up to
+// that turn's user prompt. Images of one session share the keys of their
+// common turns; transformed copies share all of them.
+func imageTurnKeys(meta *imageMetadata) []string {
+	keys := turnKeys(meta.ModelID, meta.Usage, meta.Prompts)
+	if len(keys) == 0 {
+		keys = append(keys, meta.Timestamp+"|"+meta.ModelID+"|"+meta.Session)
+	}
+	return keys
+}
+
+// sessionTurnKeys identifies each turn of a session as imageTurnKeys does for
+// the images generated from it.
+func sessionTurnKeys(sess *sessionData) []string {
+	model := sess.Model
+	if pinned, ok := modelAliases[model]; ok {
+		model = pinned
+	}
+	return turnKeys(modelDefs[model].ID, sessionUsage(sess), historyPrompts(sess.History))
+}
 
-	size := sess.Size
+func turnKeys(modelID string, usage []usageData, prompts []promptEntry) []string {
+	var keys []string
+	if len(usage) > 0 {
+		for _, u := range usage {
+			id := modelID
+			if def, ok := modelDefs[u.Model]; ok {
+				id = def.ID
+			}
+			keys = append(keys, fmt.Sprintf("%s|%s|%d|%d|%d", id, u.Timestamp, u.PromptTokens, u.CandidateTokens, u.ThoughtsTokens))
+		}
+		return keys
+	}
+	var history strings.Builder
+	history.WriteString(modelID)
+	for _, p := range prompts {
+		history.WriteString("\x00" + p.Role + ":" + p.Text)
+		if p.Role == "user" {
+			keys = append(keys, history.String())
+		}
+	}
+	return keys
+}
+
+// analyzeFile prices a session file, or a PNG's embedded metadata for .png paths.
+func analyzeFile(path string) (*costBreakdown, error) {
+	if isPNGPath(path) {
+		return analyzeImage(path)
+	}
+	return analyzeSession(path)
+}
+
+func isPNGPath(path string) bool {
+	return strings.ToLower(filepath.Ext(path)) == ".png"
+}
+
+func newCostBreakdown(path, model, size string) *costBreakdown {
+	// Resolve model name (aliases to pinned names)
+	if pinned, ok := modelAliases[model]; ok {
+		model = pinned
+	}
 	sizeFromData := size != ""
 	if size == "" {
 		size = "1K"
 	}
-
-	cb := &costBreakdown{
+	return &costBreakdown{
 		File:         filepath.Base(path),
+		Dir:          filepath.Dir(path),
 		Model:        model,
 		Size:         size,
 		SizeFromData: sizeFromData,
-		Turns:        turns,
-		OutputImages: outputImages,
-		Usage:        sess.Usage,
+	}
+}
+
+func sumUsageImages(entries []usageData) int {
+	var n int
+	for _, u := range entries {
+		n += u.Images
+	}
+	return n
+}
+
+func fileModTime(path string) time.Time {
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
+
+// priceBreakdown fills in usage, date and costs for a breakdown whose model,
+// size and image count are already set. The date is the last recorded turn,
+// or fallback when no turn carries a timestamp.
+func priceBreakdown(cb *costBreakdown, usage []usageData, fallback time.Time) {
+	cb.Usage = sumUsage(usage)
+	size := cb.Size
+	outputImages := cb.OutputImages
+
+	for _, u := range usage {
+		if t, err := time.Parse(time.RFC3339, u.Timestamp); err == nil && t.After(cb.Time) {
+			cb.Time = t
+		}
+	}
+	if cb.Time.IsZero() {
+		cb.Time = fallback
 	}
 
-	def, known := modelDefs[model]
+	def, known := modelDefs[cb.Model]
 	if !known {
-		return cb, nil
+		return
 	}
 
-	if sess.Usage != nil {
-		cb.InputCost = float64(sess.Usage.PromptTokens) * def.InputPerMTok / 1_000_000
-		cb.OutputCost = float64(sess.Usage.CandidateTokens) * def.OutputPerMTok / 1_000_000
+	// Each turn is priced with the table in effect at its timestamp. Legacy
+	// usage without timestamps falls back to the session's date.
+	usedTables := make(map[string]bool)
+	tableAt := func(def modelDef, t time.Time) priceTable {
+		table, ok := priceTableAt(def, t)
+		if !ok {
+			cb.PredatesPrices = true
+		}
+		usedTables[table.Effective] = true
+		return table
 	}
-	if price, ok := def.ImagePrices[size]; ok {
-		cb.ImageCost = float64(outputImages) * price
-	} else {
-		cb.ImageCost = float64(outputImages) * def.ImagePrices["1K"]
+	var pricedImages int
+	for _, u := range usage {
+		turnTime := cb.Time
+		if t, err := time.Parse(time.RFC3339, u.Timestamp); err == nil {
+			turnTime = t
+		}
+		// Turns carried over by session migrate are priced at the model
+		// that ran them.
+		turnDef := def
+		if d, ok := modelDefs[u.Model]; ok {
+			turnDef = d
+		}
+		table := tableAt(turnDef, turnTime)
+		// The prompt count includes cached tokens, which bill at the cache rate.
+		// Thinking tokens are reported separately from candidate tokens.
+		uncached := u.PromptTokens - u.CachedTokens
+		cb.InputCost += float64(uncached) * table.InputPerMTok / 1_000_000
+		cb.CachedCost += float64(u.CachedTokens) * table.CachedPerMTok / 1_000_000
+		cb.OutputCost += float64(u.CandidateTokens) * table.OutputPerMTok / 1_000_000
+		cb.ThoughtsCost += float64(u.ThoughtsTokens) * table.ThoughtsPerMTok / 1_000_000
+		if u.Images > 0 {
+			turnSize := u.Size
+			if turnSize == "" {
+				turnSize = size
+			}
+			cb.ImageCost += float64(u.Images) * imagePrice(table, turnSize)
+			pricedImages += u.Images
+		}
 	}
-	cb.Total = cb.InputCost + cb.OutputCost + cb.ImageCost
+	// Images from turns without usage records are priced at the session's date and size.
+	if rest := outputImages - pricedImages; rest > 0 || len(usedTables) == 0 {
+		cb.ImageCost += float64(max(rest, 0)) * imagePrice(tableAt(def, cb.Time), size)
+	}
+	for date := range usedTables {
+		cb.PriceTables = append(cb.PriceTables, date)
+	}
+	sort.Strings(cb.PriceTables)
+	cb.Total = cb.InputCost + cb.CachedCost + cb.OutputCost + cb.ThoughtsCost + cb.ImageCost
+}
 
-	return cb, nil
+const costUsage = `usage: agentpix cost [-r] [--group-by model|day|dir|size] [--since YYYY-MM-DD] [--until YYYY-MM-DD] [--format text|csv|json] <session-file|image.png|directory>`
+
+var costGroupings = map[string]func(cb *costBreakdown) string{
+	"model": func(cb *costBreakdown) string {
+		if cb.Model == "" {
+			return "legacy"
+		}
+		return cb.Model
+	},
+	"day":  func(cb *costBreakdown) string { return cb.Time.UTC().Format(time.DateOnly) },
+	"dir":  func(cb *costBreakdown) string { return cb.Dir },
+	"size": func(cb *costBreakdown) string { return cb.Size },
 }
 
 func runCost(args []string) error {
-	if len(args) != 1 {
-		return fmt.Errorf("usage: agentpix cost <session-file-or-directory>")
+	fs := flag.NewFlagSet("agentpix cost", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+
+	recursive := fs.Bool("r", false, "scan directories recursively")
+	groupBy := fs.String("group-by", "", "aggregate by model, day, dir, or size")
+	since := fs.String("since", "", "only sessions on or after this date (YYYY-MM-DD, UTC)")
+	until := fs.String("until", "", "only sessions on or before this date (YYYY-MM-DD, UTC)")
+	format := fs.String("format", "text", "output format: text, csv, or json")
+
+	if err := fs.Parse(args); err != nil {
+		return fmt.Errorf("%s", costUsage)
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("%s", costUsage)
+	}
+	target := fs.Arg(0)
+
+	if *groupBy != "" && costGroupings[*groupBy] == nil {
+		return fmt.Errorf("invalid --group-by %q: use model, day, dir, or size", *groupBy)
+	}
+	if *format != "text" && *format != "csv" && *format != "json" {
+		return fmt.Errorf("invalid --format %q: use text, csv, or json", *format)
+	}
+	var from, to time.Time
+	if *since != "" {
+		t, err := time.Parse(time.DateOnly, *since)
+		if err != nil {
+			return fmt.Errorf("invalid --since %q: use YYYY-MM-DD", *since)
+		}
+		from = t
+	}
+	if *until != "" {
+		t, err := time.Parse(time.DateOnly, *until)
+		if err != nil {
+			return fmt.Errorf("invalid --until %q: use YYYY-MM-DD", *until)
+		}
+		to = t.AddDate(0, 0, 1) // inclusive: up to the end of that day
 	}
-	target := args[0]
 
 	info, err := os.Stat(target)
 	if err != nil {
 		return fmt.Errorf("cannot access %q: %v", target, err)
 	}
 
-	if !info.IsDir() {
+	filtered := !from.IsZero() || !to.IsZero()
+	if !info.IsDir() && *format == "text" && *groupBy == "" && !filtered {
 		return runCostFile(target)
 	}
-	return runCostDir(target)
+
+	var paths []string
+	root := target
+	if info.IsDir() {
+		paths, err = listCostFiles(target, *recursive)
+		if err != nil {
+			return err
+		}
+	} else {
+		paths = []string{target}
+		root = filepath.Dir(target)
+	}
+
+	var results []*costBreakdown
+	priced := make(map[string]bool)
+	for _, path := range paths {
+		var cb *costBreakdown
+		if isPNGPath(path) {
+			cb, err = analyzeImageTurns(path, priced)
+		} else {
+			cb, err = analyzeSessionTurns(path, priced)
+		}
+		if (errors.Is(err, errNoMetadata) || errors.Is(err, errImported)) && info.IsDir() {
+			continue // ordinary PNG, or one not generated through the API
+		}
+		if errors.Is(err, errTurnsPriced) {
+			continue // an earlier image of the same session or a transformed copy
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "skip %s: %v\n", path, err)
+			continue
+		}
+		if (!from.IsZero() && cb.Time.Before(from)) || (!to.IsZero() && !cb.Time.Before(to)) {
+			continue
+		}
+		if rel, err := filepath.Rel(root, path); err == nil {
+			cb.File = rel
+			cb.Dir = filepath.Dir(rel)
+		}
+		results = append(results, cb)
+	}
+	warnPredatesPrices(results)
+	tables := priceTablesUsed(results)
+
+	if *groupBy != "" {
+		groups := groupCosts(results, costGroupings[*groupBy])
+		switch *format {
+		case "csv":
+			return writeCostGroupsCSV(os.Stdout, *groupBy, groups)
+		case "json":
+			return writeCostJSON(os.Stdout, costReport{PriceTables: tables, GroupBy: *groupBy, Groups: groups})
+		}
+		return printCostGroups(*groupBy, groups, tables)
+	}
+
+	switch *format {
+	case "csv":
+		return writeCostCSV(os.Stdout, results)
+	case "json":
+		rows := make([]costRow, 0, len(results))
+		for _, cb := range results {
+			rows = append(rows, newCostRow(cb))
+		}
+		return writeCostJSON(os.Stdout, costReport{PriceTables: tables, Sessions: rows})
+	}
+	return printCostTable(results, tables)
+}
+
+// listCostFiles returns the session files in dir plus generated PNGs whose
+// session file is gone, so each generation is counted once. Sessions come
+// first, so their turns are known before images continued from them are
+// priced.
+func listCostFiles(dir string, recursive bool) ([]string, error) {
+	all, err := listFiles(dir, recursive, func(name string) bool {
+		return isSessionFile(name) || isPNGPath(name)
+	})
+	if err != nil {
+		return nil, err
+	}
+	sessions := make(map[string]bool)
+	for _, p := range all {
+		if isSessionFile(p) {
+			sessions[p] = true
+		}
+	}
+	var paths []string
+	for _, p := range all {
+		if isPNGPath(p) && (sessions[sessionPath(p, false)] || sessions[sessionPath(p, true)]) {
+			continue
+		}
+		paths = append(paths, p)
+	}
+	sort.SliceStable(paths, func(i, j int) bool {
+		return !isPNGPath(paths[i]) && isPNGPath(paths[j])
+	})
+	return paths, nil
 }
 
 func runCostFile(path string) error {
-	cb, err := analyzeSession(path)
+	cb, err := analyzeFile(path)
 	if err != nil {
 		return err
 	}
 
 	_, known := modelDefs[cb.Model]
 
-	fmt.Printf("model:   %s\n", cb.Model)
-	fmt.Printf("turns:   %d\n", cb.Turns)
+	if cb.FromImage {
+		fmt.Printf("source:   image metadata\n")
+	}
+	fmt.Printf("model:    %s\n", cb.Model)
+	fmt.Printf("turns:    %d\n", cb.Turns)
 
 	if cb.Usage != nil && known {
-		fmt.Printf("input:   %s tokens ($%s)\n", formatTokenCount(cb.Usage.PromptTokens), formatCost(cb.InputCost))
-		fmt.Printf("output:  %s tokens ($%s)\n", formatTokenCount(cb.Usage.CandidateTokens), formatCost(cb.OutputCost))
+		fmt.Printf("input:    %s tokens ($%s)\n", formatTokenCount(cb.Usage.PromptTokens-cb.Usage.CachedTokens), formatCost(cb.InputCost))
+		if cb.Usage.CachedTokens > 0 {
+			fmt.Printf("cached:   %s tokens ($%s)\n", formatTokenCount(cb.Usage.CachedTokens), formatCost(cb.CachedCost))
+		}
+		fmt.Printf("output:   %s tokens ($%s)\n", formatTokenCount(cb.Usage.CandidateTokens), formatCost(cb.OutputCost))
+		if cb.Usage.ThoughtsTokens > 0 {
+			fmt.Printf("thoughts: %s tokens ($%s)\n", formatTokenCount(cb.Usage.ThoughtsTokens), formatCost(cb.ThoughtsCost))
+		}
 	} else if cb.Usage != nil {
-		fmt.Printf("input:   %s tokens\n", formatTokenCount(cb.Usage.PromptTokens))
-		fmt.Printf("output:  %s tokens\n", formatTokenCount(cb.Usage.CandidateTokens))
+		fmt.Printf("input:    %s tokens\n", formatTokenCount(cb.Usage.PromptTokens-cb.Usage.CachedTokens))
+		if cb.Usage.CachedTokens > 0 {
+			fmt.Printf("cached:   %s tokens\n", formatTokenCount(cb.Usage.CachedTokens))
+		}
+		fmt.Printf("output:   %s tokens\n", formatTokenCount(cb.Usage.CandidateTokens))
+		if cb.Usage.ThoughtsTokens > 0 {
+			fmt.Printf("thoughts: %s tokens\n", formatTokenCount(cb.Usage.ThoughtsTokens))
+		}
 	} else {
-		fmt.Printf("tokens:  no data\n")
+		fmt.Printf("tokens:   no data\n")
 	}
 
 	if known {
@@ -126,33 +543,55 @@ func runCostFile(path string) error {
 		if !cb.SizeFromData {
 			sizeNote += " (assumed)"
 		}
-		fmt.Printf("images:  %d @ %s ($%s)\n", cb.OutputImages, sizeNote, formatCost(cb.ImageCost))
-		fmt.Printf("total:   ~$%s\n", formatCost(cb.Total))
+		fmt.Printf("images:   %d @ %s ($%s)\n", cb.OutputImages, sizeNote, formatCost(cb.ImageCost))
+		fmt.Printf("total:    ~$%s\n", formatCost(cb.Total))
 	} else {
-		fmt.Printf("images:  %d\n", cb.OutputImages)
-		fmt.Printf("total:   unknown (unrecognized model)\n")
+		fmt.Printf("images:   %d\n", cb.OutputImages)
+		fmt.Printf("total:    unknown (unrecognized model)\n")
 	}
 
-	fmt.Printf("\nprices collected %s\n", pricesCollected)
+	if len(cb.PriceTables) > 0 {
+		warnPredatesPrices([]*costBreakdown{cb})
+		fmt.Printf("\nprice table: %s %s\n", cb.Model, strings.Join(cb.PriceTables, ", "))
+	}
 	return nil
 }
 
-func runCostDir(dir string) error {
-	paths, err := listSessionFiles(dir)
-	if err != nil {
-		return err
+// priceTablesUsed returns the distinct price tables applied across results,
+// labelled "model date" and sorted.
+func priceTablesUsed(results []*costBreakdown) []string {
+	seen := make(map[string]bool)
+	var tables []string
+	for _, cb := range results {
+		for _, date := range cb.PriceTables {
+			label := cb.Model + " " + date
+			if !seen[label] {
+				seen[label] = true
+				tables = append(tables, label)
+			}
+		}
 	}
+	sort.Strings(tables)
+	return tables
+}
 
-	var results []*costBreakdown
-	for _, path := range paths {
-		cb, err := analyzeSession(path)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "skip %s: %v\n", filepath.Base(path), err)
+func warnPredatesPrices(results []*costBreakdown) {
+	for _, cb := range results {
+		if !cb.PredatesPrices {
 			continue
 		}
-		results = append(results, cb)
+		oldest := modelDefs[cb.Model].Prices[0].Effective
+		fmt.Fprintf(os.Stderr, "warning: %s predates the oldest %s price table (%s); estimate uses those rates\n", cb.File, cb.Model, oldest)
 	}
+}
 
+func printPriceTables(tables []string) {
+	if len(tables) > 0 {
+		fmt.Printf("\nprice tables: %s\n", strings.Join(tables, ", "))
+	}
+}
+
+func printCostTable(results []*costBreakdown, tables []string) error {
 	if len(results) == 0 {
 		fmt.Fprintln(os.Stderr, "no session files found")
 		return nil
@@ -161,6 +600,8 @@ func runCostDir(dir string) error {
 	var totalCost float64
 	var totalImages int
 	var unpriced int
+	var tokens usageData
+	var withUsage int
 	for _, cb := range results {
 		_, known := modelDefs[cb.Model]
 		costStr := "?"
@@ -176,6 +617,13 @@ func runCostDir(dir string) error {
 		fmt.Printf("  %-30s %-10s %-3s turns=%-3d images=%-3d %s\n", cb.File, cb.Model, sizeStr, cb.Turns, cb.OutputImages, costStr)
 		totalCost += cb.Total
 		totalImages += cb.OutputImages
+		if cb.Usage != nil {
+			tokens.PromptTokens += cb.Usage.PromptTokens
+			tokens.CachedTokens += cb.Usage.CachedTokens
+			tokens.CandidateTokens += cb.Usage.CandidateTokens
+			tokens.ThoughtsTokens += cb.Usage.ThoughtsTokens
+			withUsage++
+		}
 	}
 
 	totalLine := fmt.Sprintf("\n  total: %d sessions, %d images, ~$%s", len(results), totalImages, formatCost(totalCost))
@@ -183,7 +631,213 @@ func runCostDir(dir string) error {
 		totalLine += fmt.Sprintf(" (%d unpriced)", unpriced)
 	}
 	fmt.Println(totalLine)
-	fmt.Printf("\nprices collected %s\n", pricesCollected)
+	if withUsage > 0 {
+		fmt.Printf("  tokens: input %s, cached %s, output %s, thoughts %s (%d of %d sessions with usage)\n",
+			formatTokenCount(tokens.PromptTokens-tokens.CachedTokens), formatTokenCount(tokens.CachedTokens),
+			formatTokenCount(tokens.CandidateTokens), formatTokenCount(tokens.ThoughtsTokens), withUsage, len(results))
+	}
+	printPriceTables(tables)
+	return nil
+}
+
+// costGroup aggregates sessions sharing a --group-by key.
+type costGroup struct {
+	Key      string  `json:"key"`
+	Sessions int     `json:"sessions"`
+	Turns    int     `json:"turns"`
+	Images   int     `json:"images"`
+	Unpriced int     `json:"unpriced"`
+	Total    float64 `json:"total_usd"`
+}
+
+func groupCosts(results []*costBreakdown, keyFn func(*costBreakdown) string) []costGroup {
+	byKey := make(map[string]*costGroup)
+	for _, cb := range results {
+		key := keyFn(cb)
+		g, ok := byKey[key]
+		if !ok {
+			g = &costGroup{Key: key}
+			byKey[key] = g
+		}
+		g.Sessions++
+		g.Turns += cb.Turns
+		g.Images += cb.OutputImages
+		g.Total += cb.Total
+		if _, known := modelDefs[cb.Model]; !known {
+			g.Unpriced++
+		}
+	}
+	groups := make([]costGroup, 0, len(byKey))
+	for _, g := range byKey {
+		groups = append(groups, *g)
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i].Key < groups[j].Key })
+	return groups
+}
+
+func printCostGroups(groupBy string, groups []costGroup, tables []string) error {
+	if len(groups) == 0 {
+		fmt.Fprintln(os.Stderr, "no session files found")
+		return nil
+	}
+
+	var totalCost float64
+	var totalSessions, totalImages, unpriced int
+	fmt.Printf("  by %s:\n", groupBy)
+	for _, g := range groups {
+		costStr := fmt.Sprintf("~$%s", formatCost(g.Total))
+		if g.Unpriced > 0 {
+			costStr += fmt.Sprintf(" (%d unpriced)", g.Unpriced)
+		}
+		fmt.Printf("  %-30s sessions=%-3d images=%-3d %s\n", g.Key, g.Sessions, g.Images, costStr)
+		totalCost += g.Total
+		totalSessions += g.Sessions
+		totalImages += g.Images
+		unpriced += g.Unpriced
+	}
+
+	totalLine := fmt.Sprintf("\n  total: %d sessions, %d images, ~$%s", totalSessions, totalImages, formatCost(totalCost))
+	if unpriced > 0 {
+		totalLine += fmt.Sprintf(" (%d unpriced)", unpriced)
+	}
+	fmt.Println(totalLine)
+	printPriceTables(tables)
+	return nil
+}
+
+// costRow is the flat per-session record used for CSV and JSON export.
+type costRow struct {
+	File           string  `json:"file"`
+	Dir            string  `json:"dir"`
+	Date           string  `json:"date"`
+	Model          string  `json:"model"`
+	Size           string  `json:"size"`
+	SizeAssumed    bool    `json:"size_assumed"`
+	Turns          int     `json:"turns"`
+	Images         int     `json:"images"`
+	Priced         bool    `json:"priced"`
+	InputTokens    int32   `json:"input_tokens"`
+	CachedTokens   int32   `json:"cached_tokens"`
+	OutputTokens   int32   `json:"output_tokens"`
+	ThoughtsTokens int32   `json:"thoughts_tokens"`
+	InputCost      float64 `json:"input_usd"`
+	CachedCost     float64 `json:"cached_usd"`
+	OutputCost     float64 `json:"output_usd"`
+	ThoughtsCost   float64 `json:"thoughts_usd"`
+	ImageCost      float64 `json:"image_usd"`
+	Total          float64 `json:"total_usd"`
+	PriceTable     string  `json:"price_table"`
+}
+
+func newCostRow(cb *costBreakdown) costRow {
+	_, known := modelDefs[cb.Model]
+	row := costRow{
+		File:         cb.File,
+		Dir:          cb.Dir,
+		Date:         cb.Time.UTC().Format(time.DateOnly),
+		Model:        cb.Model,
+		Size:         cb.Size,
+		SizeAssumed:  !cb.SizeFromData,
+		Turns:        cb.Turns,
+		Images:       cb.OutputImages,
+		Priced:       known,
+		InputCost:    cb.InputCost,
+		CachedCost:   cb.CachedCost,
+		OutputCost:   cb.OutputCost,
+		ThoughtsCost: cb.ThoughtsCost,
+		ImageCost:    cb.ImageCost,
+		Total:        cb.Total,
+		PriceTable:   strings.Join(cb.PriceTables, " "),
+	}
+	if cb.Usage != nil {
+		row.InputTokens = cb.Usage.PromptTokens - cb.Usage.CachedTokens
+		row.CachedTokens = cb.Usage.CachedTokens
+		row.OutputTokens = cb.Usage.CandidateTokens
+		row.ThoughtsTokens = cb.Usage.ThoughtsTokens
+	}
+	return row
+}
+
+// costReport is the top-level JSON document for --format json. Exactly one of
+// Sessions or Groups is populated.
+type costReport struct {
+	PriceTables []string    `json:"price_tables"`
+	GroupBy     string      `json:"group_by,omitempty"`
+	Sessions    []costRow   `json:"sessions,omitempty"`
+	Groups      []costGroup `json:"groups,omitempty"`
+}
+
+// MarshalJSON writes the list that applies, sessions or groups with
+// --group-by, and writes empty lists as [] rather than null or nothing, so
+// consumers see the same shape when nothing matched.
+func (r costReport) MarshalJSON() ([]byte, error) {
+	tables := r.PriceTables
+	if tables == nil {
+		tables = []string{}
+	}
+	if r.GroupBy != "" {
+		groups := r.Groups
+		if groups == nil {
+			groups = []costGroup{}
+		}
+		return json.Marshal(struct {
+			PriceTables []string    `json:"price_tables"`
+			GroupBy     string      `json:"group_by"`
+			Groups      []costGroup `json:"groups"`
+		}{tables, r.GroupBy, groups})
+	}
+	sessions := r.Sessions
+	if sessions == nil {
+		sessions = []costRow{}
+	}
+	return json.Marshal(struct {
+		PriceTables []string  `json:"price_tables"`
+		Sessions    []costRow `json:"sessions"`
+	}{tables, sessions})
+}
+
+func writeCostJSON(w io.Writer, report costReport) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(report); err != nil {
+		return fmt.Errorf("failed to write JSON: %v", err)
+	}
+	return nil
+}
+
+func formatUSD(v float64) string {
+	return strconv.FormatFloat(v, 'f', 6, 64)
+}
+
+func writeCostCSV(w io.Writer, results []*costBreakdown) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"file", "dir", "date", "model", "size", "size_assumed", "turns", "images", "priced",
+		"input_tokens", "cached_tokens", "output_tokens", "thoughts_tokens",
+		"input_usd", "cached_usd", "output_usd", "thoughts_usd", "image_usd", "total_usd", "price_table"})
+	for _, cb := range results {
+		r := newCostRow(cb)
+		cw.Write([]string{r.File, r.Dir, r.Date, r.Model, r.Size, strconv.FormatBool(r.SizeAssumed),
+			strconv.Itoa(r.Turns), strconv.Itoa(r.Images), strconv.FormatBool(r.Priced),
+			strconv.Itoa(int(r.InputTokens)), strconv.Itoa(int(r.CachedTokens)), strconv.Itoa(int(r.OutputTokens)), strconv.Itoa(int(r.ThoughtsTokens)),
+			formatUSD(r.InputCost), formatUSD(r.CachedCost), formatUSD(r.OutputCost), formatUSD(r.ThoughtsCost), formatUSD(r.ImageCost), formatUSD(r.Total), r.PriceTable})
+	}
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		return fmt.Errorf("failed to write CSV: %v", err)
+	}
+	return nil
+}
+
+func writeCostGroupsCSV(w io.Writer, groupBy string, groups []costGroup) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{groupBy, "sessions", "turns", "images", "unpriced", "total_usd"})
+	for _, g := range groups {
+		cw.Write([]string{g.Key, strconv.Itoa(g.Sessions), strconv.Itoa(g.Turns), strconv.Itoa(g.Images), strconv.Itoa(g.Unpriced), formatUSD(g.Total)})
+	}
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		return fmt.Errorf("failed to write CSV: %v", err)
+	}
 	return nil
 }
 
@@ -1,19 +1,23 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
 	"google.golang.org/genai"
 )
 
 func TestAnalyzeSession(t *testing.T) {
-	flashDef := modelDefs[testFlashName]
-	flash31Def := modelDefs["flash-3.1"]
-	proDef := modelDefs[testProName]
+	flashDef := currentPrices(modelDefs[testFlashName])
+	flash31Def := currentPrices(modelDefs["flash-3.1"])
+	proDef := currentPrices(modelDefs[testProName])
 
 	tests := []struct {
 		name        string
@@ -23,7 +27,9 @@ func TestAnalyzeSession(t *testing.T) {
 		wantTurns   int
 		wantImages  int
 		wantInput   float64
+		wantCached  float64
 		wantOutput  float64
+		wantThought float64
 		wantImage   float64
 		wantTotal   float64
 		noUsage     bool
@@ -46,6 +52,59 @@ func TestAnalyzeSession(t *testing.T) {
 			wantImage:  flashDef.ImagePrices["1K"],
 			wantTotal:  float64(1000)*flashDef.InputPerMTok/1_000_000 + float64(200)*flashDef.OutputPerMTok/1_000_000 + flashDef.ImagePrices["1K"],
 		},
+		{
+			name: "per-turn usage history summed",
+			setup: func(t *testing.T) string {
+				dir := t.TempDir()
+				return writeSessionFile(t, dir, "test.session.json", sessionData{
+					Model: testFlashName,
+					History: []*genai.Content{
+						{Role: "user", Parts: []*genai.Part{{Text: "a cat"}}},
+						{Role: "model", Parts: []*genai.Part{{InlineData: &genai.Blob{MIMEType: "image/png", Data: []byte("img1")}}}},
+						{Role: "user", Parts: []*genai.Part{{Text: "make it blue"}}},
+						{Role: "model", Parts: []*genai.Part{{InlineData: &genai.Blob{MIMEType: "image/png", Data: []byte("img2")}}}},
+					},
+					UsageHistory: []usageData{
+						{PromptTokens: 300, CandidateTokens: 50, TotalTokens: 350, Images: 1},
+						{PromptTokens: 1500, CandidateTokens: 70, TotalTokens: 1570, Images: 1},
+					},
+				})
+			},
+			wantModel:  testFlashName,
+			wantTurns:  2,
+			wantImages: 2,
+			wantInput:  float64(1800) * flashDef.InputPerMTok / 1_000_000,
+			wantOutput: float64(120) * flashDef.OutputPerMTok / 1_000_000,
+			wantImage:  2 * flashDef.ImagePrices["1K"],
+			wantTotal:  float64(1800)*flashDef.InputPerMTok/1_000_000 + float64(120)*flashDef.OutputPerMTok/1_000_000 + 2*flashDef.ImagePrices["1K"],
+		},
+		{
+			name: "thought and cached tokens priced separately",
+			setup: func(t *testing.T) string {
+				dir := t.TempDir()
+				return writeSessionFile(t, dir, "test.session.json", sessionData{
+					Model:    "flash-3.1",
+					Thinking: "high",
+					History: []*genai.Content{
+						{Role: "user", Parts: []*genai.Part{{Text: "a cat"}}},
+						{Role: "model", Parts: []*genai.Part{{InlineData: &genai.Blob{MIMEType: "image/png", Data: []byte("img")}}}},
+					},
+					UsageHistory: []usageData{
+						{PromptTokens: 2000, CachedTokens: 1500, CandidateTokens: 100, ThoughtsTokens: 800, TotalTokens: 2900, Images: 1},
+					},
+				})
+			},
+			wantModel:   "flash-3.1",
+			wantTurns:   1,
+			wantImages:  1,
+			wantInput:   float64(500) * flash31Def.InputPerMTok / 1_000_000,
+			wantCached:  float64(1500) * flash31Def.CachedPerMTok / 1_000_000,
+			wantOutput:  float64(100) * flash31Def.OutputPerMTok / 1_000_000,
+			wantThought: float64(800) * flash31Def.ThoughtsPerMTok / 1_000_000,
+			wantImage:   flash31Def.ImagePrices["1K"],
+			wantTotal: float64(500)*flash31Def.InputPerMTok/1_000_000 + float64(1500)*flash31Def.CachedPerMTok/1_000_000 +
+				float64(100)*flash31Def.OutputPerMTok/1_000_000 + float64(800)*flash31Def.ThoughtsPerMTok/1_000_000 + flash31Def.ImagePrices["1K"],
+		},
 		{
 			name: "session without usage data (legacy)",
 			setup: func(t *testing.T) string {
@@ -274,7 +333,9 @@ func TestAnalyzeSession(t *testing.T) {
 				}
 			}
 			assertClose("InputCost", cb.InputCost, tt.wantInput)
+			assertClose("CachedCost", cb.CachedCost, tt.wantCached)
 			assertClose("OutputCost", cb.OutputCost, tt.wantOutput)
+			assertClose("ThoughtsCost", cb.ThoughtsCost, tt.wantThought)
 			assertClose("ImageCost", cb.ImageCost, tt.wantImage)
 			assertClose("Total", cb.Total, tt.wantTotal)
 		})
@@ -350,6 +411,79 @@ func TestRunCost(t *testing.T) {
 				return []string{t.TempDir()}
 			},
 		},
+		{
+			name: "recursive grouped by dir",
+			setup: func(t *testing.T) []string {
+				dir := t.TempDir()
+				os.Mkdir(filepath.Join(dir, "sub"), 0755)
+				writeSessionFile(t, dir, "a.session.json", sessionData{Model: testFlashName, History: []*genai.Content{}})
+				writeSessionFile(t, filepath.Join(dir, "sub"), "b.session.json", sessionData{Model: testProName, History: []*genai.Content{}})
+				return []string{"-r", "--group-by", "dir", dir}
+			},
+		},
+		{
+			name: "json export with date filter",
+			setup: func(t *testing.T) []string {
+				dir := t.TempDir()
+				writeSessionFile(t, dir, "a.session.json", sessionData{Model: testFlashName, History: []*genai.Content{}})
+				return []string{"--since", "2026-01-01", "--format", "json", dir}
+			},
+		},
+		{
+			name: "csv export for single file",
+			setup: func(t *testing.T) []string {
+				p := writeSessionFile(t, t.TempDir(), "a.session.json", sessionData{Model: testFlashName, History: []*genai.Content{}})
+				return []string{"--format", "csv", p}
+			},
+		},
+		{
+			name: "single image",
+			setup: func(t *testing.T) []string {
+				p := writeMetaPNG(t, t.TempDir(), "cat.png", imageMetadata{Version: 1, Model: testFlashName, Timestamp: "2026-03-01T10:00:00Z", Prompts: []promptEntry{{Role: "user", Text: "x"}}})
+				return []string{p}
+			},
+		},
+		{
+			name: "directory of images with transformed copy and plain PNG",
+			setup: func(t *testing.T) []string {
+				dir := t.TempDir()
+				meta := imageMetadata{Version: 1, Model: testFlashName, Timestamp: "2026-03-01T10:00:00Z", Prompts: []promptEntry{{Role: "user", Text: "x"}}}
+				writeMetaPNG(t, dir, "cat.png", meta)
+				writeMetaPNG(t, dir, "cat-flipped.png", meta)
+				os.WriteFile(filepath.Join(dir, "plain.png"), minimalPNG(), 0644)
+				return []string{"--format", "json", dir}
+			},
+		},
+		{
+			name: "image without metadata",
+			setup: func(t *testing.T) []string {
+				p := filepath.Join(t.TempDir(), "plain.png")
+				os.WriteFile(p, minimalPNG(), 0644)
+				return []string{p}
+			},
+			wantErr: "no agentpix metadata",
+		},
+		{
+			name: "invalid group-by",
+			setup: func(t *testing.T) []string {
+				return []string{"--group-by", "color", t.TempDir()}
+			},
+			wantErr: "invalid --group-by",
+		},
+		{
+			name: "invalid format",
+			setup: func(t *testing.T) []string {
+				return []string{"--format", "xml", t.TempDir()}
+			},
+			wantErr: "invalid --format",
+		},
+		{
+			name: "invalid since date",
+			setup: func(t *testing.T) []string {
+				return []string{"--since", "last week", t.TempDir()}
+			},
+			wantErr: "invalid --since",
+		},
 		{
 			name: "invalid path",
 			setup: func(t *testing.T) []string {
@@ -379,6 +513,378 @@ func TestRunCost(t *testing.T) {
 	}
 }
 
+func TestCostScanAndGroup(t *testing.T) {
+	root := t.TempDir()
+	sub := filepath.Join(root, "project")
+	os.Mkdir(sub, 0755)
+	history := []*genai.Content{
+		{Role: "user", Parts: []*genai.Part{{Text: "hi"}}},
+		{Role: "model", Parts: []*genai.Part{{InlineData: &genai.Blob{MIMEType: "image/png", Data: []byte("img")}}}},
+	}
+	writeSessionFile(t, root, "a.session.json", sessionData{Model: testFlashName, History: history,
+		UsageHistory: []usageData{{PromptTokens: 10, Timestamp: "2026-03-01T10:00:00Z"}}})
+	writeSessionFile(t, sub, "b.session.json", sessionData{Model: testFlashName, Size: "2K", History: history,
+		UsageHistory: []usageData{{PromptTokens: 10, Timestamp: "2026-03-02T10:00:00Z"}}})
+	writeSessionFile(t, sub, "c.session.json", sessionData{Model: testProName, History: history,
+		UsageHistory: []usageData{{PromptTokens: 10, Timestamp: "2026-03-02T23:00:00Z"}}})
+
+	flat, err := listSessionFiles(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(flat) != 1 {
+		t.Fatalf("non-recursive found %d files, want 1", len(flat))
+	}
+	paths, err := listFiles(root, true, isSessionFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) != 3 {
+		t.Fatalf("recursive found %d files, want 3", len(paths))
+	}
+
+	var results []*costBreakdown
+	for _, p := range paths {
+		cb, err := analyzeSession(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		results = append(results, cb)
+	}
+
+	if got := results[0].Time.Format(time.RFC3339); got != "2026-03-01T10:00:00Z" {
+		t.Errorf("time from usage history = %s", got)
+	}
+
+	t.Run("by model", func(t *testing.T) {
+		groups := groupCosts(results, costGroupings["model"])
+		if len(groups) != 2 {
+			t.Fatalf("groups = %+v, want 2", groups)
+		}
+		for _, g := range groups {
+			if g.Key == testFlashName && g.Sessions != 2 {
+				t.Errorf("%s sessions = %d, want 2", g.Key, g.Sessions)
+			}
+		}
+	})
+
+	t.Run("by day", func(t *testing.T) {
+		groups := groupCosts(results, costGroupings["day"])
+		if len(groups) != 2 || groups[0].Key != "2026-03-01" || groups[1].Key != "2026-03-02" || groups[1].Sessions != 2 {
+			t.Fatalf("groups = %+v", groups)
+		}
+	})
+
+	t.Run("by size", func(t *testing.T) {
+		groups := groupCosts(results, costGroupings["size"])
+		if len(groups) != 2 || groups[0].Key != "1K" || groups[1].Key != "2K" {
+			t.Fatalf("groups = %+v", groups)
+		}
+	})
+
+	t.Run("csv has header and one row per session", func(t *testing.T) {
+		var buf bytes.Buffer
+		if err := writeCostCSV(&buf, results); err != nil {
+			t.Fatal(err)
+		}
+		lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+		if len(lines) != 4 {
+			t.Fatalf("csv lines = %d, want 4:\n%s", len(lines), buf.String())
+		}
+		if !strings.HasPrefix(lines[0], "file,dir,date,model") {
+			t.Errorf("header = %q", lines[0])
+		}
+	})
+
+	t.Run("json round-trips", func(t *testing.T) {
+		var buf bytes.Buffer
+		rows := []costRow{newCostRow(results[0])}
+		if err := writeCostJSON(&buf, costReport{PriceTables: priceTablesUsed(results), Sessions: rows}); err != nil {
+			t.Fatal(err)
+		}
+		var report costReport
+		if err := json.Unmarshal(buf.Bytes(), &report); err != nil {
+			t.Fatalf("invalid JSON: %v", err)
+		}
+		if len(report.Sessions) != 1 || report.Sessions[0].Date != "2026-03-01" || report.Sessions[0].InputTokens != 10 {
+			t.Errorf("report = %+v", report)
+		}
+	})
+
+	t.Run("json keeps its shape when nothing matched", func(t *testing.T) {
+		for _, tt := range []struct {
+			report costReport
+			want   string
+		}{
+			{costReport{}, `{"price_tables":[],"sessions":[]}`},
+			{costReport{GroupBy: "model"}, `{"price_tables":[],"group_by":"model","groups":[]}`},
+		} {
+			var buf bytes.Buffer
+			if err := writeCostJSON(&buf, tt.report); err != nil {
+				t.Fatal(err)
+			}
+			var compact bytes.Buffer
+			json.Compact(&compact, buf.Bytes())
+			if compact.String() != tt.want {
+				t.Errorf("JSON = %s, want %s", compact.String(), tt.want)
+			}
+		}
+	})
+}
+
+func TestPriceTableAt(t *testing.T) {
+	def := modelDef{Prices: []priceTable{
+		{Effective: "2026-01-01", InputPerMTok: 1},
+		{Effective: "2026-06-01", InputPerMTok: 2},
+	}}
+
+	tests := []struct {
+		name      string
+		at        string
+		wantRate  float64
+		wantFound bool
+	}{
+		{"before oldest table", "2025-12-31T23:59:59Z", 1, false},
+		{"first day of oldest table", "2026-01-01T00:00:00Z", 1, true},
+		{"between tables", "2026-05-31T12:00:00Z", 1, true},
+		{"first day of newer table", "2026-06-01T00:00:00Z", 2, true},
+		{"after newest table", "2027-01-01T00:00:00Z", 2, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			at, _ := time.Parse(time.RFC3339, tt.at)
+			table, ok := priceTableAt(def, at)
+			if table.InputPerMTok != tt.wantRate || ok != tt.wantFound {
+				t.Errorf("priceTableAt(%s) = rate %v ok %v, want rate %v ok %v", tt.at, table.InputPerMTok, ok, tt.wantRate, tt.wantFound)
+			}
+		})
+	}
+}
+
+func TestAnalyzeSessionDatedPrices(t *testing.T) {
+	modelDefs["test-dated"] = modelDef{ID: "test-dated", Family: "test", Prices: []priceTable{
+		{Effective: "2026-01-01", InputPerMTok: 1_000_000, ImagePrices: map[string]float64{"1K": 1}},
+		{Effective: "2026-06-01", InputPerMTok: 2_000_000, ImagePrices: map[string]float64{"1K": 10}},
+	}}
+	t.Cleanup(func() { delete(modelDefs, "test-dated") })
+
+	history := []*genai.Content{
+		{Role: "user", Parts: []*genai.Part{{Text: "a"}}},
+		{Role: "model", Parts: []*genai.Part{{InlineData: &genai.Blob{MIMEType: "image/png", Data: []byte("img1")}}}},
+		{Role: "user", Parts: []*genai.Part{{Text: "b"}}},
+		{Role: "model", Parts: []*genai.Part{{InlineData: &genai.Blob{MIMEType: "image/png", Data: []byte("img2")}}}},
+	}
+
+	t.Run("each turn priced at its own table", func(t *testing.T) {
+		p := writeSessionFile(t, t.TempDir(), "a.session.json", sessionData{Model: "test-dated", History: history,
+			UsageHistory: []usageData{
+				{PromptTokens: 1, Images: 1, Timestamp: "2026-05-31T10:00:00Z"},
+				{PromptTokens: 1, Images: 1, Timestamp: "2026-06-02T10:00:00Z"},
+			}})
+		cb, err := analyzeSession(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cb.InputCost != 3 || cb.ImageCost != 11 {
+			t.Errorf("InputCost = %v, ImageCost = %v, want 3 and 11", cb.InputCost, cb.ImageCost)
+		}
+		if strings.Join(cb.PriceTables, ",") != "2026-01-01,2026-06-01" {
+			t.Errorf("PriceTables = %v", cb.PriceTables)
+		}
+		if cb.PredatesPrices {
+			t.Error("PredatesPrices should be false")
+		}
+	})
+
+	t.Run("session older than oldest table flagged", func(t *testing.T) {
+		p := writeSessionFile(t, t.TempDir(), "a.session.json", sessionData{Model: "test-dated", History: history[:2],
+			UsageHistory: []usageData{{PromptTokens: 1, Images: 1, Timestamp: "2025-11-01T10:00:00Z"}}})
+		cb, err := analyzeSession(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !cb.PredatesPrices {
+			t.Error("PredatesPrices should be true")
+		}
+		if cb.InputCost != 1 || cb.ImageCost != 1 {
+			t.Errorf("InputCost = %v, ImageCost = %v, want oldest rates", cb.InputCost, cb.ImageCost)
+		}
+	})
+
+	t.Run("images without usage priced at session date", func(t *testing.T) {
+		p := writeSessionFile(t, t.TempDir(), "a.session.json", sessionData{Model: "test-dated", History: history})
+		old := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+		os.Chtimes(p, old, old)
+		cb, err := analyzeSession(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cb.ImageCost != 2 {
+			t.Errorf("ImageCost = %v, want 2 (two images at the 2026-01-01 rate)", cb.ImageCost)
+		}
+	})
+}
+
+// writeMetaPNG writes a minimal PNG carrying the given agentpix metadata.
+func writeMetaPNG(t *testing.T, dir, name string, meta imageMetadata) string {
+	t.Helper()
+	raw, err := json.Marshal(meta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := withText(minimalPNG(), metadataKey, string(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := filepath.Join(dir, name)
+	if err := os.WriteFile(p, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestAnalyzeImage(t *testing.T) {
+	flash31 := currentPrices(modelDefs["flash-3.1"])
+	prompts := []promptEntry{
+		{Role: "user", Text: "a cat"},
+		{Role: "model", Text: "here"},
+		{Role: "user", Text: "make it blue"},
+	}
+
+	t.Run("estimate without usage", func(t *testing.T) {
+		p := writeMetaPNG(t, t.TempDir(), "cat.png", imageMetadata{Version: 1, Model: "flash-3.1", Size: "2K", Timestamp: "2026-03-01T10:00:00Z", Prompts: prompts})
+		cb, err := analyzeImage(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !cb.FromImage || cb.Turns != 2 || cb.OutputImages != 2 || cb.Usage != nil {
+			t.Errorf("breakdown = %+v", cb)
+		}
+		if cb.Total != 2*flash31.ImagePrices["2K"] {
+			t.Errorf("Total = %v, want %v", cb.Total, 2*flash31.ImagePrices["2K"])
+		}
+		if cb.Time.Format(time.DateOnly) != "2026-03-01" {
+			t.Errorf("Time = %v, want metadata timestamp", cb.Time)
+		}
+	})
+
+	t.Run("exact with recorded usage", func(t *testing.T) {
+		p := writeMetaPNG(t, t.TempDir(), "cat.png", imageMetadata{Version: 1, Model: "flash-3.1", Timestamp: "2026-03-01T10:00:00Z", Prompts: prompts,
+			Usage: []usageData{
+				{PromptTokens: 1000, CandidateTokens: 100, Images: 1, Timestamp: "2026-03-01T09:00:00Z"},
+				{PromptTokens: 2000, CandidateTokens: 100, Images: 1, Timestamp: "2026-03-01T10:00:00Z"},
+			}})
+		cb, err := analyzeImage(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := 3000*flash31.InputPerMTok/1_000_000 + 200*flash31.OutputPerMTok/1_000_000 + 2*flash31.ImagePrices["1K"]
+		if d := cb.Total - want; d > 1e-9 || d < -1e-9 {
+			t.Errorf("Total = %v, want %v", cb.Total, want)
+		}
+	})
+
+	t.Run("continuation chain counts each turn once", func(t *testing.T) {
+		dir := t.TempDir()
+		first := usageData{PromptTokens: 1000, CandidateTokens: 100, Images: 1, Timestamp: "2026-03-01T09:00:00Z"}
+		second := usageData{PromptTokens: 2000, CandidateTokens: 100, Images: 1, Timestamp: "2026-03-01T10:00:00Z"}
+		a := writeMetaPNG(t, dir, "a.png", imageMetadata{Version: 1, Model: "flash-3.1", Timestamp: "2026-03-01T09:00:00Z", Prompts: prompts[:1], Usage: []usageData{first}})
+		b := writeMetaPNG(t, dir, "b.png", imageMetadata{Version: 1, Model: "flash-3.1", Timestamp: "2026-03-01T10:00:00Z", Prompts: prompts, Usage: []usageData{first, second}})
+		b2 := writeMetaPNG(t, dir, "b-flipped.png", imageMetadata{Version: 1, Model: "flash-3.1", Timestamp: "2026-03-01T10:00:00Z", Prompts: prompts, Usage: []usageData{first, second}})
+
+		priced := make(map[string]bool)
+		cbA, err := analyzeImageTurns(a, priced)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cbB, err := analyzeImageTurns(b, priced)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cbB.Turns != 1 || cbB.Usage.PromptTokens != 2000 {
+			t.Errorf("second image priced turns = %d, prompt tokens = %d; want only its own turn", cbB.Turns, cbB.Usage.PromptTokens)
+		}
+		want := 3000*flash31.InputPerMTok/1_000_000 + 200*flash31.OutputPerMTok/1_000_000 + 2*flash31.ImagePrices["1K"]
+		if d := cbA.Total + cbB.Total - want; d > 1e-9 || d < -1e-9 {
+			t.Errorf("chain total = %v, want %v", cbA.Total+cbB.Total, want)
+		}
+		if _, err := analyzeImageTurns(b2, priced); !errors.Is(err, errTurnsPriced) {
+			t.Errorf("transformed copy err = %v, want errTurnsPriced", err)
+		}
+	})
+
+	t.Run("image continued from a listed session", func(t *testing.T) {
+		dir := t.TempDir()
+		first := usageData{PromptTokens: 1000, CandidateTokens: 100, Images: 1, Timestamp: "2026-03-01T09:00:00Z"}
+		second := usageData{PromptTokens: 2000, CandidateTokens: 100, Images: 1, Timestamp: "2026-03-01T10:00:00Z"}
+		writeSessionFile(t, dir, "a.session.json", sessionData{Model: "flash-3.1", History: testHistory(
+			testTurn{prompt: "a cat", output: testBlob("image/png", []byte("img"))},
+		), UsageHistory: []usageData{first}})
+		// b's own session was cleaned; its metadata repeats a's turn.
+		writeMetaPNG(t, dir, "b.png", imageMetadata{Version: 1, Model: "flash-3.1", ModelID: modelDefs["flash-3.1"].ID,
+			Timestamp: "2026-03-01T10:00:00Z", Prompts: prompts, Usage: []usageData{first, second}})
+
+		paths, err := listCostFiles(dir, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		priced := make(map[string]bool)
+		var total float64
+		var images int
+		for _, p := range paths {
+			var cb *costBreakdown
+			if isPNGPath(p) {
+				cb, err = analyzeImageTurns(p, priced)
+			} else {
+				cb, err = analyzeSessionTurns(p, priced)
+			}
+			if err != nil {
+				t.Fatalf("%s: %v", filepath.Base(p), err)
+			}
+			total += cb.Total
+			images += cb.OutputImages
+		}
+		want := 3000*flash31.InputPerMTok/1_000_000 + 200*flash31.OutputPerMTok/1_000_000 + 2*flash31.ImagePrices["1K"]
+		if d := total - want; images != 2 || d > 1e-9 || d < -1e-9 {
+			t.Errorf("directory = %d images, $%v; want 2 images, $%v", images, total, want)
+		}
+	})
+
+	t.Run("plain PNG has no metadata", func(t *testing.T) {
+		p := filepath.Join(t.TempDir(), "plain.png")
+		os.WriteFile(p, minimalPNG(), 0644)
+		_, err := analyzeImage(p)
+		if !errors.Is(err, errNoMetadata) {
+			t.Fatalf("err = %v, want errNoMetadata", err)
+		}
+	})
+}
+
+func TestListCostFiles(t *testing.T) {
+	dir := t.TempDir()
+	meta := imageMetadata{Version: 1, Model: testFlashName, Timestamp: "2026-03-01T10:00:00Z", Prompts: []promptEntry{{Role: "user", Text: "x"}}}
+	writeMetaPNG(t, dir, "kept.png", meta)
+	writeMetaPNG(t, dir, "apple.png", meta)
+	writeMetaPNG(t, dir, "cat.png", meta)
+	writeSessionFile(t, dir, "cat.session.json", sessionData{Model: testFlashName, History: []*genai.Content{}})
+	writeMetaPNG(t, dir, "dog.png", meta)
+	if err := writeSession(filepath.Join(dir, "dog.session.json.gz"), sessionData{Model: testFlashName, History: []*genai.Content{}}); err != nil {
+		t.Fatal(err)
+	}
+
+	paths, err := listCostFiles(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, p := range paths {
+		names = append(names, filepath.Base(p))
+	}
+	if strings.Join(names, ",") != "cat.session.json,dog.session.json.gz,apple.png,kept.png" {
+		t.Errorf("files = %v, want sessions, then orphaned PNGs", names)
+	}
+}
+
 func TestFormatTokenCount(t *testing.T) {
 	tests := []struct {
 		input int32
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+const diffMetaUsage = "usage: agentpix diff-meta <a.png|a.session.json> <b.png|b.session.json>"
+
+// generationRecord is what diff-meta compares: the settings and prompt
+// history of an image's metadata or of a session file.
+type generationRecord struct {
+	Model    string
+	Ratio    string
+	Size     string
+	Thinking string
+	Inputs   []string
+	Prompts  []promptEntry
+}
+
+func runDiffMeta(args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("%s", diffMetaUsage)
+	}
+	a, err := loadGenerationRecord(args[0])
+	if err != nil {
+		return err
+	}
+	b, err := loadGenerationRecord(args[1])
+	if err != nil {
+		return err
+	}
+	if !writeMetaDiff(os.Stdout, args[0], args[1], a, b) {
+		fmt.Fprintln(os.Stderr, "no differences")
+	}
+	return nil
+}
+
+// loadGenerationRecord reads a PNG's agentpix metadata, falling back to an
+// embedded session, or a session file.
+func loadGenerationRecord(path string) (*generationRecord, error) {
+	if isPNGPath(path) {
+		meta, err := readImageMetadata(path)
+		if err == nil {
+			rec := &generationRecord{Model: meta.Model, Ratio: meta.Ratio, Size: meta.Size, Thinking: meta.Thinking, Prompts: meta.Prompts}
+			for i, in := range meta.Inputs {
+				if i < len(meta.InputSHA256) {
+					in += shortHash(meta.InputSHA256[i])
+				}
+				rec.Inputs = append(rec.Inputs, in)
+			}
+			return rec, nil
+		}
+		if !errors.Is(err, errNoMetadata) {
+			return nil, err
+		}
+	}
+	sess, _, err := readSessionImages(path)
+	if err != nil {
+		return nil, err
+	}
+	rec := &generationRecord{Model: sess.Model, Ratio: sess.Ratio, Size: sess.Size, Thinking: sess.Thinking, Prompts: historyPrompts(sess.History)}
+	// Sessions do not record input file names; images in user turns are
+	// identified by turn, dimensions, and content hash instead.
+	var turn int
+	for _, c := range sess.History {
+		if c == nil || c.Role != "user" {
+			continue
+		}
+		turn++
+		for _, p := range c.Parts {
+			if p == nil || p.InlineData == nil || len(p.InlineData.Data) == 0 {
+				continue
+			}
+			sum := sha256.Sum256(p.InlineData.Data)
+			rec.Inputs = append(rec.Inputs, fmt.Sprintf("turn %d: %s%s", turn, describeBlob(p.InlineData), shortHash(hex.EncodeToString(sum[:]))))
+		}
+	}
+	return rec, nil
+}
+
+// promptTurns groups prompt entries into turns, each starting at a user
+// prompt. Entries before the first user prompt belong to the first turn.
+func promptTurns(prompts []promptEntry) [][]promptEntry {
+	var turns [][]promptEntry
+	for _, p := range prompts {
+		if p.Role == "user" || len(turns) == 0 {
+			turns = append(turns, nil)
+		}
+		turns[len(turns)-1] = append(turns[len(turns)-1], p)
+	}
+	return turns
+}
+
+// writeMetaDiff writes a unified-diff style comparison: settings first, then
+// the prompt history turn by turn, with the first diverging turn marked.
+// Reports whether the records differ.
+func writeMetaDiff(w io.Writer, nameA, nameB string, a, b *generationRecord) bool {
+	fmt.Fprintf(w, "--- %s\n+++ %s\n", nameA, nameB)
+	var differ bool
+
+	line := func(prefix, label, value string) {
+		if value == "" {
+			value = "-"
+		}
+		fmt.Fprintf(w, "%s%-9s %s\n", prefix, label+":", value)
+	}
+	field := func(label, va, vb string) {
+		if va == vb {
+			line(" ", label, va)
+			return
+		}
+		differ = true
+		line("-", label, va)
+		line("+", label, vb)
+	}
+
+	fmt.Fprintln(w, "@@ settings @@")
+	field("model", a.Model, b.Model)
+	field("ratio", a.Ratio, b.Ratio)
+	field("size", a.Size, b.Size)
+	field("thinking", a.Thinking, b.Thinking)
+	ia, ib := strings.Join(a.Inputs, "\n"), strings.Join(b.Inputs, "\n")
+	if ia == ib {
+		for _, in := range a.Inputs {
+			line(" ", "input", in)
+		}
+	} else {
+		differ = true
+		for _, in := range a.Inputs {
+			line("-", "input", in)
+		}
+		for _, in := range b.Inputs {
+			line("+", "input", in)
+		}
+	}
+
+	ta, tb := promptTurns(a.Prompts), promptTurns(b.Prompts)
+	diverged := false
+	for i := range max(len(ta), len(tb)) {
+		var pa, pb []promptEntry
+		if i < len(ta) {
+			pa = ta[i]
+		}
+		if i < len(tb) {
+			pb = tb[i]
+		}
+		same := len(pa) == len(pb)
+		for j := 0; same && j < len(pa); j++ {
+			same = pa[j] == pb[j]
+		}
+		header := fmt.Sprintf("@@ turn %d @@", i+1)
+		if !same && !diverged {
+			header += " histories diverge here"
+			diverged = true
+		}
+		fmt.Fprintln(w, header)
+		if same {
+			writePromptLines(w, " ", pa)
+			continue
+		}
+		differ = true
+		// Entries are compared in place, so a turn with the same prompt but a
+		// different reply shows the prompt as context.
+		for j := range max(len(pa), len(pb)) {
+			if j < len(pa) && j < len(pb) && pa[j] == pb[j] {
+				writePromptLines(w, " ", pa[j:j+1])
+				continue
+			}
+			if j < len(pa) {
+				writePromptLines(w, "-", pa[j:j+1])
+			}
+			if j < len(pb) {
+				writePromptLines(w, "+", pb[j:j+1])
+			}
+		}
+	}
+	return differ
+}
+
+func writePromptLines(w io.Writer, prefix string, entries []promptEntry) {
+	for _, p := range entries {
+		for i, l := range strings.Split(p.Text, "\n") {
+			if i == 0 {
+				fmt.Fprintf(w, "%s%s: %s\n", prefix, p.Role, l)
+			} else {
+				fmt.Fprintf(w, "%s%s  %s\n", prefix, strings.Repeat(" ", len(p.Role)), l)
+			}
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"google.golang.org/genai"
+)
+
+func TestPromptTurns(t *testing.T) {
+	turns := promptTurns([]promptEntry{
+		{Role: "model", Text: "migrated"},
+		{Role: "user", Text: "a"},
+		{Role: "model", Text: "b"},
+		{Role: "user", Text: "c"},
+	})
+	if len(turns) != 3 || len(turns[0]) != 1 || len(turns[1]) != 2 || turns[2][0].Text != "c" {
+		t.Errorf("turns = %+v", turns)
+	}
+}
+
+func TestWriteMetaDiff(t *testing.T) {
+	base := &generationRecord{
+		Model:   "flash-3.1",
+		Ratio:   "1:1",
+		Inputs:  []string{"hero.png"},
+		Prompts: []promptEntry{{Role: "user", Text: "a cat"}, {Role: "model", Text: "here"}, {Role: "user", Text: "make it blue"}},
+	}
+	tests := []struct {
+		name       string
+		b          generationRecord
+		wantDiffer bool
+		want       []string // lines expected in the output
+		notWant    []string
+	}{
+		{
+			name:    "identical",
+			b:       *base,
+			want:    []string{" model:    flash-3.1", "@@ turn 2 @@", " user: make it blue"},
+			notWant: []string{"diverge"},
+		},
+		{
+			name:       "settings",
+			b:          generationRecord{Model: "flash-3.1", Ratio: "16:9", Size: "2K", Inputs: []string{"hero.png"}, Prompts: base.Prompts},
+			wantDiffer: true,
+			want:       []string{"-ratio:    1:1", "+ratio:    16:9", "-size:     -", "+size:     2K", " input:    hero.png"},
+			notWant:    []string{"diverge"},
+		},
+		{
+			name: "second turn diverges",
+			b: generationRecord{Model: "flash-3.1", Ratio: "1:1", Inputs: []string{"hero.png"},
+				Prompts: []promptEntry{{Role: "user", Text: "a cat"}, {Role: "model", Text: "here"}, {Role: "user", Text: "make it red\nand bigger"}, {Role: "model", Text: "done"}}},
+			wantDiffer: true,
+			want:       []string{"@@ turn 1 @@\n user: a cat\n model: here", "@@ turn 2 @@ histories diverge here", "-user: make it blue", "+user: make it red\n+      and bigger", "+model: done"},
+		},
+		{
+			name:       "inputs and extra turn",
+			b:          generationRecord{Model: "flash-3.1", Ratio: "1:1", Inputs: []string{"hero-v2.png"}, Prompts: append(append([]promptEntry{}, base.Prompts...), promptEntry{Role: "user", Text: "add a hat"})},
+			wantDiffer: true,
+			want:       []string{"-input:    hero.png", "+input:    hero-v2.png", "@@ turn 3 @@ histories diverge here", "+user: add a hat"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			differ := writeMetaDiff(&buf, "a.png", "b.png", base, &tt.b)
+			out := buf.String()
+			if differ != tt.wantDiffer {
+				t.Errorf("differ = %v, want %v", differ, tt.wantDiffer)
+			}
+			if !strings.HasPrefix(out, "--- a.png\n+++ b.png\n") {
+				t.Errorf("missing file header:\n%s", out)
+			}
+			for _, w := range tt.want {
+				if !strings.Contains(out, w+"\n") {
+					t.Errorf("output missing %q:\n%s", w, out)
+				}
+			}
+			for _, w := range tt.notWant {
+				if strings.Contains(out, w) {
+					t.Errorf("output contains %q:\n%s", w, out)
+				}
+			}
+		})
+	}
+}
+
+func TestRunDiffMeta(t *testing.T) {
+	dir := t.TempDir()
+	png := writeMetaPNG(t, dir, "a.png", imageMetadata{Model: testFlashName, Ratio: "1:1", Inputs: []string{"x.png"}, InputSHA256: []string{strings.Repeat("0f", 32)}, Prompts: []promptEntry{{Role: "user", Text: "a cat"}}})
+	sess := writeSessionFile(t, dir, "b.session.json", sessionData{Model: testFlashName, Ratio: "1:1", History: []*genai.Content{
+		{Role: "user", Parts: []*genai.Part{{Text: "a cat"}, {InlineData: &genai.Blob{MIMEType: "image/png", Data: minimalPNG()}}}},
+		{Role: "model", Parts: []*genai.Part{{Text: "here"}}},
+	}})
+
+	a, err := loadGenerationRecord(png)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(a.Inputs) != 1 || a.Inputs[0] != "x.png  sha256:0f0f0f0f0f0f" {
+		t.Errorf("image inputs = %q", a.Inputs)
+	}
+	b, err := loadGenerationRecord(sess)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(b.Inputs) != 1 || !strings.HasPrefix(b.Inputs[0], "turn 1: 1x1 png") {
+		t.Errorf("session inputs = %q", b.Inputs)
+	}
+
+	if err := runDiffMeta([]string{png, sess}); err != nil {
+		t.Errorf("runDiffMeta: %v", err)
+	}
+	if err := runDiffMeta([]string{png, filepath.Join(dir, "missing.png")}); err == nil {
+		t.Error("expected error for missing file")
+	}
+	if err := runDiffMeta([]string{png}); err == nil || !strings.Contains(err.Error(), "usage") {
+		t.Errorf("err = %v, want usage", err)
+	}
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const findUsage = "usage: agentpix find [--prompt regex] [--model m] [--since date] [--ratio r] [--input name] [--index file] <directory>"
+
+// findIndexVersion is bumped when the index layout changes; older indexes
+// are rebuilt rather than read.
+const findIndexVersion = 1
+
+// findIndex caches the metadata of scanned PNGs, keyed by absolute path.
+// An entry is reused while the file's size and modification time match.
+type findIndex struct {
+	Version int                    `json:"version"`
+	Entries map[string]*indexEntry `json:"entries"`
+}
+
+type indexEntry struct {
+	Size     int64          `json:"size"`
+	ModTime  int64          `json:"mod_time"`           // Unix nanoseconds
+	Metadata *imageMetadata `json:"metadata,omitempty"` // nil for PNGs without agentpix metadata
+}
+
+// findQuery holds the filters of a find run. Empty fields match everything.
+type findQuery struct {
+	prompt *regexp.Regexp
+	model  string
+	since  time.Time
+	ratio  string
+	input  string
+}
+
+func runFind(args []string) error {
+	fs := flag.NewFlagSet("agentpix find", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+
+	prompt := fs.String("prompt", "", "regular expression matched against user prompts (case-insensitive)")
+	model := fs.String("model", "", "model name or alias")
+	since := fs.String("since", "", "only images generated on or after this date (YYYY-MM-DD, RFC 3339, or an age like 7d or 12h)")
+	ratio := fs.String("ratio", "", "aspect ratio, e.g. 16:9")
+	input := fs.String("input", "", "input image file name or glob pattern")
+	indexPath := fs.String("index", "", "cache metadata in this index file for faster repeated queries")
+
+	if err := fs.Parse(args); err != nil {
+		return fmt.Errorf("%s", findUsage)
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("%s", findUsage)
+	}
+	dir := fs.Arg(0)
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		return fmt.Errorf("%q is not a directory", dir)
+	}
+
+	var q findQuery
+	if *prompt != "" {
+		re, err := regexp.Compile("(?i)" + *prompt)
+		if err != nil {
+			return fmt.Errorf("invalid --prompt pattern: %v", err)
+		}
+		q.prompt = re
+	}
+	q.model = *model
+	if pinned, ok := modelAliases[q.model]; ok {
+		q.model = pinned
+	}
+	if *since != "" {
+		t, err := parseSince(*since, time.Now())
+		if err != nil {
+			return err
+		}
+		q.since = t
+	}
+	if *ratio != "" && !validRatios[*ratio] {
+		return fmt.Errorf("invalid aspect ratio %q", *ratio)
+	}
+	q.ratio = *ratio
+	if *input != "" {
+		if _, err := filepath.Match(*input, ""); err != nil {
+			return fmt.Errorf("invalid --input pattern %q: %v", *input, err)
+		}
+	}
+	q.input = *input
+
+	metas, err := scanMetadata(dir, *indexPath)
+	if err != nil {
+		return err
+	}
+
+	var matched []string
+	for path, meta := range metas {
+		if q.matches(meta) {
+			matched = append(matched, path)
+		}
+	}
+	sort.Strings(matched)
+	for _, p := range matched {
+		fmt.Println(p)
+	}
+	if len(matched) == 0 {
+		fmt.Fprintf(os.Stderr, "no matching images in %s (%d with metadata scanned)\n", dir, len(metas))
+	}
+	return nil
+}
+
+func (q *findQuery) matches(meta *imageMetadata) bool {
+	if q.model != "" {
+		model := meta.Model
+		if pinned, ok := modelAliases[model]; ok {
+			model = pinned
+		}
+		if model != q.model && meta.ModelID != q.model {
+			return false
+		}
+	}
+	if q.ratio != "" && meta.Ratio != q.ratio {
+		return false
+	}
+	if !q.since.IsZero() {
+		ts, err := time.Parse(time.RFC3339, meta.Timestamp)
+		if err != nil || ts.Before(q.since) {
+			return false
+		}
+	}
+	if q.input != "" {
+		var found bool
+		for _, in := range meta.Inputs {
+			if ok, _ := filepath.Match(q.input, in); ok {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if q.prompt != nil {
+		var found bool
+		for _, p := range meta.Prompts {
+			if p.Role == "user" && q.prompt.MatchString(p.Text) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// parseSince accepts a date (YYYY-MM-DD, UTC), an RFC 3339 timestamp, or an
+// age relative to now such as 7d or 36h.
+func parseSince(s string, now time.Time) (time.Time, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		if n, err := strconv.Atoi(days); err == nil && n >= 0 {
+			return now.Add(-time.Duration(n) * 24 * time.Hour), nil
+		}
+	}
+	if d, err := time.ParseDuration(s); err == nil && d >= 0 {
+		return now.Add(-d), nil
+	}
+	if t, err := time.Parse(time.DateOnly, s); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("invalid --since %q: use YYYY-MM-DD, an RFC 3339 timestamp, or an age like 7d", s)
+}
+
+// scanMetadata reads the agentpix metadata of every PNG under dir, keyed by
+// path as walked. With an index file, unchanged files are served from the
+// index and the index is rewritten when anything changed.
+func scanMetadata(dir, indexPath string) (map[string]*imageMetadata, error) {
+	paths, err := listFiles(dir, true, isPNGPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var idx *findIndex
+	if indexPath != "" {
+		idx, err = loadFindIndex(indexPath)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	metas := make(map[string]*imageMetadata)
+	seen := make(map[string]bool)
+	var dirty bool
+	for _, p := range paths {
+		info, err := os.Stat(p)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "skip %s: %v\n", p, err)
+			continue
+		}
+		var key string
+		var entry *indexEntry
+		if idx != nil {
+			key, _ = filepath.Abs(p)
+			seen[key] = true
+			entry = idx.Entries[key]
+			if entry != nil && (entry.Size != info.Size() || entry.ModTime != info.ModTime().UnixNano()) {
+				entry = nil
+			}
+		}
+		if entry == nil {
+			meta, err := readImageMetadata(p)
+			if err != nil && !errors.Is(err, errNoMetadata) {
+				fmt.Fprintf(os.Stderr, "skip %s: %v\n", p, err)
+				continue
+			}
+			entry = &indexEntry{Size: info.Size(), ModTime: info.ModTime().UnixNano(), Metadata: meta}
+			if idx != nil {
+				idx.Entries[key] = entry
+				dirty = true
+			}
+		}
+		if entry.Metadata != nil {
+			metas[p] = entry.Metadata
+		}
+	}
+
+	if idx == nil {
+		return metas, nil
+	}
+	// Forget files under dir that no longer exist; entries for other
+	// directories stay so one index can serve several trees.
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, fmt.Errorf("cannot resolve %q: %v", dir, err)
+	}
+	for key := range idx.Entries {
+		if !seen[key] && strings.HasPrefix(key, absDir+string(filepath.Separator)) {
+			delete(idx.Entries, key)
+			dirty = true
+		}
+	}
+	if dirty {
+		if err := saveFindIndex(indexPath, idx); err != nil {
+			return nil, err
+		}
+	}
+	return metas, nil
+}
+
+// loadFindIndex reads an index file. A missing file, or one written by an
+// incompatible version, yields an empty index.
+func loadFindIndex(path string) (*findIndex, error) {
+	empty := &findIndex{Version: findIndexVersion, Entries: make(map[string]*indexEntry)}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return empty, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read index %q: %v", path, err)
+	}
+	var idx findIndex
+	if err := json.Unmarshal(data, &idx); err != nil {
+		return nil, fmt.Errorf("failed to parse index %q: %v (delete it to rebuild)", path, err)
+	}
+	if idx.Version != findIndexVersion || idx.Entries == nil {
+		fmt.Fprintf(os.Stderr, "note: rebuilding index %s\n", path)
+		return empty, nil
+	}
+	return &idx, nil
+}
+
+func saveFindIndex(path string, idx *findIndex) error {
+	data, err := json.Marshal(idx)
+	if err != nil {
+		return fmt.Errorf("failed to serialize index: %v", err)
+	}
+	return writeFileAtomic(path, data)
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestParseSince(t *testing.T) {
+	now := time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		in      string
+		want    time.Time
+		wantErr bool
+	}{
+		{in: "7d", want: now.Add(-7 * 24 * time.Hour)},
+		{in: "36h", want: now.Add(-36 * time.Hour)},
+		{in: "2026-03-01", want: time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)},
+		{in: "2026-03-01T08:00:00+01:00", want: time.Date(2026, 3, 1, 7, 0, 0, 0, time.UTC)},
+		{in: "last week", wantErr: true},
+		{in: "-3d", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := parseSince(tt.in, now)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("parseSince(%q) = %v, want error", tt.in, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !got.Equal(tt.want) {
+				t.Errorf("parseSince(%q) = %v, want %v", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFindQueryMatches(t *testing.T) {
+	meta := &imageMetadata{
+		Model:     "flash",
+		ModelID:   testFlashModelID,
+		Ratio:     "16:9",
+		Inputs:    []string{"brand-logo.png", "palette.jpg"},
+		Timestamp: "2026-03-05T10:00:00Z",
+		Prompts: []promptEntry{
+			{Role: "user", Text: "a Blue logo for Acme"},
+			{Role: "model", Text: "here is a red one"},
+		},
+	}
+	tests := []struct {
+		name string
+		q    findQuery
+		want bool
+	}{
+		{"empty query", findQuery{}, true},
+		{"prompt case-insensitive", findQuery{prompt: regexp.MustCompile("(?i)blue logo")}, true},
+		{"model replies are not prompts", findQuery{prompt: regexp.MustCompile("(?i)red")}, false},
+		{"legacy alias resolves", findQuery{model: testFlashName}, true},
+		{"model ID", findQuery{model: testFlashModelID}, true},
+		{"other model", findQuery{model: testProName}, false},
+		{"ratio", findQuery{ratio: "16:9"}, true},
+		{"other ratio", findQuery{ratio: "1:1"}, false},
+		{"since before", findQuery{since: time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)}, true},
+		{"since after", findQuery{since: time.Date(2026, 3, 6, 0, 0, 0, 0, time.UTC)}, false},
+		{"input exact", findQuery{input: "palette.jpg"}, true},
+		{"input glob", findQuery{input: "*logo*"}, true},
+		{"input missing", findQuery{input: "hero.png"}, false},
+		{"all filters", findQuery{prompt: regexp.MustCompile("(?i)acme"), model: testFlashName, ratio: "16:9", input: "*.jpg"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.q.matches(meta); got != tt.want {
+				t.Errorf("matches = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestScanMetadataIndex(t *testing.T) {
+	dir := t.TempDir()
+	os.Mkdir(filepath.Join(dir, "sub"), 0755)
+	a := writeMetaPNG(t, dir, "a.png", imageMetadata{Model: testFlashName, Ratio: "1:1", Prompts: []promptEntry{{Role: "user", Text: "a cat"}}})
+	b := writeMetaPNG(t, filepath.Join(dir, "sub"), "b.png", imageMetadata{Model: testProName, Ratio: "16:9"})
+	os.WriteFile(filepath.Join(dir, "plain.png"), minimalPNG(), 0644)
+	index := filepath.Join(t.TempDir(), "index.json")
+
+	metas, err := scanMetadata(dir, index)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(metas) != 2 || metas[a] == nil || metas[b] == nil {
+		t.Fatalf("scanned %v, want a.png and sub/b.png", metas)
+	}
+
+	var idx findIndex
+	data, _ := os.ReadFile(index)
+	if err := json.Unmarshal(data, &idx); err != nil {
+		t.Fatalf("index: %v", err)
+	}
+	if len(idx.Entries) != 3 {
+		t.Errorf("index has %d entries, want 3 (including the PNG without metadata)", len(idx.Entries))
+	}
+
+	// Unchanged files are served from the index without reading them.
+	absA, _ := filepath.Abs(a)
+	idx.Entries[absA].Metadata.Ratio = "from-index"
+	data, _ = json.Marshal(idx)
+	os.WriteFile(index, data, 0644)
+	metas, err = scanMetadata(dir, index)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if metas[a].Ratio != "from-index" {
+		t.Errorf("ratio = %q, want the cached value", metas[a].Ratio)
+	}
+
+	// Changed files are re-read and deleted files dropped from the index.
+	later := time.Now().Add(time.Hour)
+	os.Chtimes(a, later, later)
+	os.Remove(b)
+	metas, err = scanMetadata(dir, index)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(metas) != 1 || metas[a].Ratio != "1:1" {
+		t.Errorf("after changes: %v", metas)
+	}
+	data, _ = os.ReadFile(index)
+	idx = findIndex{}
+	json.Unmarshal(data, &idx)
+	absB, _ := filepath.Abs(b)
+	if _, ok := idx.Entries[absB]; ok {
+		t.Error("deleted file still in index")
+	}
+}
+
+func TestRunFind(t *testing.T) {
+	dir := t.TempDir()
+	writeMetaPNG(t, dir, "logo.png", imageMetadata{Model: testFlashName, Ratio: "1:1", Timestamp: "2026-03-05T10:00:00Z", Prompts: []promptEntry{{Role: "user", Text: "blue logo"}}})
+
+	tests := []struct {
+		name    string
+		args    []string
+		wantErr bool
+	}{
+		{name: "match", args: []string{"--prompt", "blue", dir}},
+		{name: "no match", args: []string{"--ratio", "16:9", dir}},
+		{name: "bad regex", args: []string{"--prompt", "(", dir}, wantErr: true},
+		{name: "bad ratio", args: []string{"--ratio", "5:1", dir}, wantErr: true},
+		{name: "bad since", args: []string{"--since", "someday", dir}, wantErr: true},
+		{name: "not a directory", args: []string{filepath.Join(dir, "logo.png")}, wantErr: true},
+		{name: "no args", args: nil, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := runFind(tt.args)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("runFind(%v) err = %v, wantErr %v", tt.args, err, tt.wantErr)
+			}
+		})
+	}
+}
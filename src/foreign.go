@@ -0,0 +1,449 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"image/png"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Tools whose PNG metadata agentpix can read, as recorded in ImportedFrom.
+const (
+	toolA1111   = "automatic1111"
+	toolComfyUI = "comfyui"
+)
+
+var foreignToolNames = map[string]string{
+	toolA1111:   "Automatic1111",
+	toolComfyUI: "ComfyUI",
+}
+
+var errNoForeignMetadata = errors.New("no Automatic1111 or ComfyUI metadata")
+
+// foreignMetadata is the generation record another Stable Diffusion tool
+// left in a PNG's text chunks.
+type foreignMetadata struct {
+	Tool           string      `json:"tool"`
+	Prompts        []string    `json:"prompts"`
+	NegativePrompt string      `json:"negative_prompt,omitempty"`
+	Model          string      `json:"model,omitempty"`
+	Width          int         `json:"width,omitempty"`
+	Height         int         `json:"height,omitempty"`
+	Params         [][2]string `json:"params,omitempty"` // sampler settings in source order
+}
+
+// readForeignMetadata looks for Automatic1111's "parameters" chunk, then
+// ComfyUI's "prompt" (API graph) and "workflow" (editor graph) chunks.
+func readForeignMetadata(data []byte) (*foreignMetadata, error) {
+	if text, err := pngGetText(data, "parameters"); err == nil {
+		return parseA1111(text)
+	}
+	if text, err := pngGetText(data, "prompt"); err == nil {
+		if fm, err := parseComfyPrompt(text); err == nil {
+			return fm, nil
+		}
+	}
+	if text, err := pngGetText(data, "workflow"); err == nil {
+		return parseComfyWorkflow(text)
+	}
+	return nil, errNoForeignMetadata
+}
+
+// parseA1111 parses Automatic1111's infotext: the prompt, an optional
+// "Negative prompt:" section, and a final "Steps: ..." line of settings.
+func parseA1111(text string) (*foreignMetadata, error) {
+	lines := strings.Split(strings.TrimSpace(text), "\n")
+	fm := &foreignMetadata{Tool: toolA1111}
+
+	if n := len(lines); n > 0 && strings.HasPrefix(lines[n-1], "Steps: ") {
+		fm.Params = splitA1111Params(lines[n-1])
+		lines = lines[:n-1]
+	}
+	var prompt, negative []string
+	inNegative := false
+	for _, l := range lines {
+		if rest, ok := strings.CutPrefix(l, "Negative prompt:"); ok {
+			inNegative = true
+			l = strings.TrimSpace(rest)
+		}
+		if inNegative {
+			negative = append(negative, l)
+		} else {
+			prompt = append(prompt, l)
+		}
+	}
+	if p := strings.TrimSpace(strings.Join(prompt, "\n")); p != "" {
+		fm.Prompts = []string{p}
+	}
+	fm.NegativePrompt = strings.TrimSpace(strings.Join(negative, "\n"))
+	if len(fm.Prompts) == 0 && len(fm.Params) == 0 {
+		return nil, errors.New("parameters chunk has no prompt or settings")
+	}
+
+	var rest [][2]string
+	for _, kv := range fm.Params {
+		switch kv[0] {
+		case "Model":
+			fm.Model = kv[1]
+		case "Size":
+			w, h, ok := strings.Cut(kv[1], "x")
+			if ok {
+				fm.Width, _ = strconv.Atoi(w)
+				fm.Height, _ = strconv.Atoi(h)
+			}
+		default:
+			rest = append(rest, kv)
+		}
+	}
+	fm.Params = rest
+	return fm, nil
+}
+
+// splitA1111Params splits "Key: value, Key: value" where values may be
+// double-quoted and contain commas.
+func splitA1111Params(line string) [][2]string {
+	var params [][2]string
+	var field strings.Builder
+	inQuote := false
+	flush := func() {
+		k, v, ok := strings.Cut(strings.TrimSpace(field.String()), ":")
+		if ok {
+			params = append(params, [2]string{strings.TrimSpace(k), strings.Trim(strings.TrimSpace(v), `"`)})
+		}
+		field.Reset()
+	}
+	for _, r := range line {
+		switch {
+		case r == '"':
+			inQuote = !inQuote
+			field.WriteRune(r)
+		case r == ',' && !inQuote:
+			flush()
+		default:
+			field.WriteRune(r)
+		}
+	}
+	flush()
+	return params
+}
+
+// comfyNode is a node of ComfyUI's API-format graph (the "prompt" chunk).
+// Inputs hold literal values or [node id, output index] links.
+type comfyNode struct {
+	ClassType string                     `json:"class_type"`
+	Inputs    map[string]json.RawMessage `json:"inputs"`
+}
+
+// parseComfyPrompt reads the prompt graph ComfyUI executed. Text encoders
+// linked to a sampler's positive and negative inputs give the prompts; the
+// checkpoint loader and empty latent give model and size.
+func parseComfyPrompt(text string) (*foreignMetadata, error) {
+	var graph map[string]comfyNode
+	if err := json.Unmarshal([]byte(text), &graph); err != nil {
+		return nil, fmt.Errorf("failed to parse ComfyUI prompt: %v", err)
+	}
+	fm := &foreignMetadata{Tool: toolComfyUI}
+
+	ids := make([]string, 0, len(graph))
+	for id := range graph {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return comfyIDLess(ids[i], ids[j]) })
+
+	used := make(map[string]bool)
+	for _, id := range ids {
+		n := graph[id]
+		switch {
+		case strings.HasPrefix(n.ClassType, "KSampler") || n.ClassType == "SamplerCustom":
+			if pos := comfyText(graph, n.Inputs["positive"]); pos != "" {
+				fm.Prompts = append(fm.Prompts, pos)
+				used[comfyLink(n.Inputs["positive"])] = true
+			}
+			if neg := comfyText(graph, n.Inputs["negative"]); neg != "" && fm.NegativePrompt == "" {
+				fm.NegativePrompt = neg
+				used[comfyLink(n.Inputs["negative"])] = true
+			}
+			for _, key := range []string{"seed", "noise_seed", "steps", "cfg", "sampler_name", "scheduler", "denoise"} {
+				if v := comfyLiteral(n.Inputs[key]); v != "" {
+					fm.Params = append(fm.Params, [2]string{key, v})
+				}
+			}
+		case strings.HasPrefix(n.ClassType, "CheckpointLoader"):
+			if fm.Model == "" {
+				fm.Model = comfyLiteral(n.Inputs["ckpt_name"])
+			}
+		case n.ClassType == "EmptyLatentImage" || n.ClassType == "EmptySD3LatentImage":
+			fm.Width, _ = strconv.Atoi(comfyLiteral(n.Inputs["width"]))
+			fm.Height, _ = strconv.Atoi(comfyLiteral(n.Inputs["height"]))
+		}
+	}
+	// Text encoders not wired to a sampler (e.g. through conditioning
+	// nodes) still carry prompt text worth showing and searching.
+	for _, id := range ids {
+		if n := graph[id]; n.ClassType == "CLIPTextEncode" && !used[id] {
+			if t := comfyLiteral(n.Inputs["text"]); t != "" {
+				fm.Prompts = append(fm.Prompts, t)
+			}
+		}
+	}
+	if len(fm.Prompts) == 0 {
+		return nil, errors.New("ComfyUI prompt has no text encoder nodes")
+	}
+	return fm, nil
+}
+
+// parseComfyWorkflow reads ComfyUI's editor graph, used when the API graph
+// is missing. It has no wiring to the sampler in a usable form, so every
+// text encoder's text is reported as a prompt.
+func parseComfyWorkflow(text string) (*foreignMetadata, error) {
+	var wf struct {
+		Nodes []struct {
+			ID     int               `json:"id"`
+			Type   string            `json:"type"`
+			Values []json.RawMessage `json:"widgets_values"`
+		} `json:"nodes"`
+	}
+	if err := json.Unmarshal([]byte(text), &wf); err != nil {
+		return nil, fmt.Errorf("failed to parse ComfyUI workflow: %v", err)
+	}
+	sort.Slice(wf.Nodes, func(i, j int) bool { return wf.Nodes[i].ID < wf.Nodes[j].ID })
+	fm := &foreignMetadata{Tool: toolComfyUI}
+	for _, n := range wf.Nodes {
+		if len(n.Values) == 0 {
+			continue
+		}
+		switch {
+		case n.Type == "CLIPTextEncode":
+			if t := comfyLiteral(n.Values[0]); t != "" {
+				fm.Prompts = append(fm.Prompts, t)
+			}
+		case strings.HasPrefix(n.Type, "CheckpointLoader") && fm.Model == "":
+			fm.Model = comfyLiteral(n.Values[0])
+		}
+	}
+	if len(fm.Prompts) == 0 {
+		return nil, errors.New("ComfyUI workflow has no text encoder nodes")
+	}
+	return fm, nil
+}
+
+// comfyLiteral renders a literal input value, or "" for links and nulls.
+func comfyLiteral(raw json.RawMessage) string {
+	var s string
+	if json.Unmarshal(raw, &s) == nil {
+		return s
+	}
+	var n json.Number
+	if json.Unmarshal(raw, &n) == nil {
+		return n.String()
+	}
+	return ""
+}
+
+// comfyLink returns the node id an input is linked to, or "".
+func comfyLink(raw json.RawMessage) string {
+	var link []json.RawMessage
+	if json.Unmarshal(raw, &link) != nil || len(link) == 0 {
+		return ""
+	}
+	return comfyLiteral(link[0])
+}
+
+// comfyText follows a conditioning link to a text encoder and returns its
+// text.
+func comfyText(graph map[string]comfyNode, raw json.RawMessage) string {
+	n, ok := graph[comfyLink(raw)]
+	if !ok {
+		return ""
+	}
+	if t := comfyLiteral(n.Inputs["text"]); t != "" {
+		return t
+	}
+	return comfyLiteral(n.Inputs["text_g"]) // SDXL encoders
+}
+
+// comfyIDLess orders node ids numerically when they are numbers.
+func comfyIDLess(a, b string) bool {
+	na, errA := strconv.Atoi(a)
+	nb, errB := strconv.Atoi(b)
+	if errA == nil && errB == nil {
+		return na < nb
+	}
+	return a < b
+}
+
+// printForeignMetadata displays a foreign record in the layout of runMeta.
+func printForeignMetadata(fm *foreignMetadata) {
+	fmt.Printf("format:    %s (not generated by agentpix; convert with agentpix meta import)\n", foreignToolNames[fm.Tool])
+	if fm.Model != "" {
+		fmt.Printf("model:     %s\n", fm.Model)
+	}
+	if fm.Width > 0 && fm.Height > 0 {
+		fmt.Printf("pixels:    %dx%d\n", fm.Width, fm.Height)
+	}
+	if len(fm.Params) > 0 {
+		var parts []string
+		for _, kv := range fm.Params {
+			parts = append(parts, kv[0]+": "+kv[1])
+		}
+		fmt.Printf("settings:  %s\n", strings.Join(parts, ", "))
+	}
+	fmt.Println()
+	fmt.Println("prompts:")
+	for i, p := range fm.Prompts {
+		fmt.Printf("  [%d] user: %s\n", i+1, p)
+	}
+	if fm.NegativePrompt != "" {
+		fmt.Printf("  negative: %s\n", fm.NegativePrompt)
+	}
+}
+
+// importedMetadata converts a foreign record into agentpix metadata. The
+// checkpoint stands in for the model, and the ratio is the supported one
+// closest to the image's pixel dimensions, which are kept exactly.
+func importedMetadata(fm *foreignMetadata, width, height int, created time.Time) imageMetadata {
+	model := fm.Model
+	if model == "" {
+		model = fm.Tool
+	}
+	meta := imageMetadata{
+		Version:      metadataVersion,
+		Model:        model,
+		ModelID:      model,
+		Ratio:        nearestRatio(width, height),
+		Width:        width,
+		Height:       height,
+		Timestamp:    created.UTC().Format(time.RFC3339),
+		ImportedFrom: fm.Tool,
+		Negative:     fm.NegativePrompt,
+	}
+	for _, p := range fm.Prompts {
+		meta.Prompts = append(meta.Prompts, promptEntry{Role: "user", Text: p})
+	}
+	return meta
+}
+
+// nearestRatio returns the supported aspect ratio closest to w:h, so that
+// imported images can be found with find --ratio, e.g. 832x1216 gives 2:3.
+func nearestRatio(w, h int) string {
+	if w <= 0 || h <= 0 {
+		return ""
+	}
+	target := math.Log(float64(w) / float64(h))
+	best, bestDist := "", math.Inf(1)
+	for r := range validRatios {
+		rw, rh, _ := strings.Cut(r, ":")
+		a, _ := strconv.Atoi(rw)
+		b, _ := strconv.Atoi(rh)
+		dist := math.Abs(math.Log(float64(a)/float64(b)) - target)
+		if dist < bestDist || dist == bestDist && r < best {
+			best, bestDist = r, dist
+		}
+	}
+	return best
+}
+
+// runMetaImport writes agentpix metadata converted from Automatic1111 or
+// ComfyUI text chunks into each PNG. The original chunks are kept.
+func runMetaImport(args []string) error {
+	fs := flag.NewFlagSet("agentpix meta import", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+
+	recursive := fs.Bool("r", false, "scan directories recursively")
+	force := fs.Bool("f", false, "replace existing agentpix metadata")
+
+	const usage = "usage: agentpix meta import [-r] [-f] <image.png|directory>"
+
+	if err := fs.Parse(args); err != nil {
+		return fmt.Errorf("%s", usage)
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("%s", usage)
+	}
+	target := fs.Arg(0)
+
+	info, err := os.Stat(target)
+	if err != nil {
+		return fmt.Errorf("cannot access %q: %v", target, err)
+	}
+	if !info.IsDir() {
+		return importForeignMetadata(target, *force)
+	}
+
+	paths, err := listFiles(target, *recursive, isPNGPath)
+	if err != nil {
+		return err
+	}
+	var imported int
+	for _, p := range paths {
+		err := importForeignMetadata(p, *force)
+		if errors.Is(err, errNoForeignMetadata) {
+			continue
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "skip %s: %v\n", p, err)
+			continue
+		}
+		imported++
+	}
+	fmt.Fprintf(os.Stderr, "imported %d of %d PNGs in %s\n", imported, len(paths), target)
+	return nil
+}
+
+// readForeignFile reads the foreign metadata of a PNG file.
+func readForeignFile(path string) (*foreignMetadata, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %q: %v", path, err)
+	}
+	if !pngHasSignature(data) {
+		return nil, errNoForeignMetadata
+	}
+	return readForeignMetadata(data)
+}
+
+func importForeignMetadata(path string, force bool) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read %q: %v", path, err)
+	}
+	if !pngHasSignature(data) {
+		return fmt.Errorf("%q is not a PNG file", path)
+	}
+	// Foreign chunks are looked for first, so images agentpix generated
+	// itself are skipped quietly in a directory import.
+	fm, err := readForeignMetadata(data)
+	if errors.Is(err, errNoForeignMetadata) {
+		return fmt.Errorf("%w found in %q", errNoForeignMetadata, path)
+	}
+	if err != nil {
+		return fmt.Errorf("%q: %v", path, err)
+	}
+	if _, err := pngGetText(data, metadataKey); err == nil && !force {
+		return fmt.Errorf("%q already has agentpix metadata (use -f to replace it)", path)
+	}
+	cfg, err := png.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("failed to decode %q: %v", path, err)
+	}
+	meta := importedMetadata(fm, cfg.Width, cfg.Height, fileModTime(path))
+	raw, err := json.Marshal(meta)
+	if err != nil {
+		return fmt.Errorf("failed to serialize metadata: %v", err)
+	}
+	if err := replaceMetadata(path, path, string(raw)); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "imported %s metadata into %s\n", foreignToolNames[fm.Tool], filepath.Base(path))
+	return nil
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testA1111Parameters = `masterpiece, a red fox in snow,
+soft light
+Negative prompt: blurry, lowres
+Steps: 28, Sampler: DPM++ 2M Karras, CFG scale: 7, Seed: 1234, Size: 832x1216, Model hash: abc123, Model: juggernautXL_v9, Lora hashes: "fox: 1a2b, snow: 3c4d", Version: v1.9.4`
+
+const testComfyPrompt = `{
+  "3": {"class_type": "KSampler", "inputs": {"seed": 42, "steps": 20, "cfg": 8, "sampler_name": "euler", "scheduler": "normal", "denoise": 1, "model": ["4", 0], "positive": ["6", 0], "negative": ["7", 0], "latent_image": ["5", 0]}},
+  "4": {"class_type": "CheckpointLoaderSimple", "inputs": {"ckpt_name": "sd_xl_base_1.0.safetensors"}},
+  "5": {"class_type": "EmptyLatentImage", "inputs": {"width": 1024, "height": 768, "batch_size": 1}},
+  "6": {"class_type": "CLIPTextEncode", "inputs": {"text": "a lighthouse at dusk", "clip": ["4", 1]}},
+  "7": {"class_type": "CLIPTextEncode", "inputs": {"text": "text, watermark", "clip": ["4", 1]}}
+}`
+
+const testComfyWorkflow = `{"nodes": [
+  {"id": 7, "type": "CLIPTextEncode", "widgets_values": ["text, watermark"]},
+  {"id": 4, "type": "CheckpointLoaderSimple", "widgets_values": ["sd_xl_base_1.0.safetensors"]},
+  {"id": 6, "type": "CLIPTextEncode", "widgets_values": ["a lighthouse at dusk"]},
+  {"id": 3, "type": "KSampler", "widgets_values": [42, "fixed", 20, 8, "euler", "normal", 1]}
+]}`
+
+// writeForeignPNG writes a PNG carrying text chunks as another tool would.
+func writeForeignPNG(t *testing.T, dir, name string, text map[string]string) string {
+	t.Helper()
+	data := minimalPNG()
+	for key, value := range text {
+		var err error
+		data, err = withText(data, key, value)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestReadForeignMetadata(t *testing.T) {
+	tests := []struct {
+		name    string
+		text    map[string]string
+		want    *foreignMetadata
+		wantErr bool
+	}{
+		{
+			name: "automatic1111",
+			text: map[string]string{"parameters": testA1111Parameters},
+			want: &foreignMetadata{
+				Tool:           toolA1111,
+				Prompts:        []string{"masterpiece, a red fox in snow,\nsoft light"},
+				NegativePrompt: "blurry, lowres",
+				Model:          "juggernautXL_v9",
+				Width:          832,
+				Height:         1216,
+				Params: [][2]string{
+					{"Steps", "28"}, {"Sampler", "DPM++ 2M Karras"}, {"CFG scale", "7"}, {"Seed", "1234"},
+					{"Model hash", "abc123"}, {"Lora hashes", "fox: 1a2b, snow: 3c4d"}, {"Version", "v1.9.4"},
+				},
+			},
+		},
+		{
+			name: "automatic1111 prompt only",
+			text: map[string]string{"parameters": "a cat"},
+			want: &foreignMetadata{Tool: toolA1111, Prompts: []string{"a cat"}},
+		},
+		{
+			name: "comfyui prompt graph",
+			text: map[string]string{"prompt": testComfyPrompt, "workflow": testComfyWorkflow},
+			want: &foreignMetadata{
+				Tool:           toolComfyUI,
+				Prompts:        []string{"a lighthouse at dusk"},
+				NegativePrompt: "text, watermark",
+				Model:          "sd_xl_base_1.0.safetensors",
+				Width:          1024,
+				Height:         768,
+				Params: [][2]string{
+					{"seed", "42"}, {"steps", "20"}, {"cfg", "8"}, {"sampler_name", "euler"}, {"scheduler", "normal"}, {"denoise", "1"},
+				},
+			},
+		},
+		{
+			name: "comfyui workflow only",
+			text: map[string]string{"workflow": testComfyWorkflow},
+			want: &foreignMetadata{
+				Tool:    toolComfyUI,
+				Prompts: []string{"a lighthouse at dusk", "text, watermark"},
+				Model:   "sd_xl_base_1.0.safetensors",
+			},
+		},
+		{
+			name:    "unrelated prompt chunk",
+			text:    map[string]string{"prompt": "just a note"},
+			wantErr: true,
+		},
+		{
+			name:    "no foreign chunks",
+			text:    map[string]string{"Comment": "hello"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := os.ReadFile(writeForeignPNG(t, t.TempDir(), "in.png", tt.text))
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := readForeignMetadata(data)
+			if tt.wantErr {
+				if !errors.Is(err, errNoForeignMetadata) {
+					t.Errorf("err = %v, want errNoForeignMetadata", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got  %+v\nwant %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNearestRatio(t *testing.T) {
+	tests := []struct {
+		w, h int
+		want string
+	}{
+		{1024, 1024, "1:1"},
+		{1024, 768, "4:3"},
+		{1920, 1080, "16:9"},
+		{832, 1216, "2:3"},
+		{2560, 1080, "21:9"},
+		{1000, 1010, "1:1"},
+		{0, 10, ""},
+	}
+	for _, tt := range tests {
+		if got := nearestRatio(tt.w, tt.h); got != tt.want {
+			t.Errorf("nearestRatio(%d, %d) = %q, want %q", tt.w, tt.h, got, tt.want)
+		}
+	}
+}
+
+func TestRunMetaImport(t *testing.T) {
+	dir := t.TempDir()
+	a1111 := writeForeignPNG(t, dir, "fox.png", map[string]string{"parameters": testA1111Parameters})
+	comfy := writeForeignPNG(t, dir, "lighthouse.png", map[string]string{"prompt": testComfyPrompt})
+	writeForeignPNG(t, dir, "plain.png", nil)
+	before := idatBytes(t, a1111)
+
+	if err := runMetaImport([]string{dir}); err != nil {
+		t.Fatal(err)
+	}
+
+	meta, err := readImageMetadata(a1111)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.ImportedFrom != toolA1111 || meta.Model != "juggernautXL_v9" || meta.Ratio != "1:1" || meta.Negative != "blurry, lowres" {
+		t.Errorf("imported metadata = %+v", meta)
+	}
+	if len(meta.Prompts) != 1 || meta.Prompts[0].Role != "user" || !strings.Contains(meta.Prompts[0].Text, "red fox") {
+		t.Errorf("prompts = %v", meta.Prompts)
+	}
+	if !bytes.Equal(idatBytes(t, a1111), before) {
+		t.Error("import changed image data")
+	}
+	data, err := os.ReadFile(a1111)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pngGetText(data, "parameters"); err != nil {
+		t.Errorf("original parameters chunk removed: %v", err)
+	}
+
+	if meta, err := readImageMetadata(comfy); err != nil || meta.ImportedFrom != toolComfyUI {
+		t.Errorf("comfy metadata = %+v, %v", meta, err)
+	}
+	if _, err := readImageMetadata(filepath.Join(dir, "plain.png")); !errors.Is(err, errNoMetadata) {
+		t.Errorf("plain.png err = %v, want errNoMetadata", err)
+	}
+
+	// Imported images are searchable like generated ones.
+	metas, err := scanMetadata(dir, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := findQuery{model: "juggernautXL_v9", ratio: "1:1"}
+	if m := metas[a1111]; m == nil || !q.matches(m) {
+		t.Errorf("find did not match imported image: %+v", m)
+	}
+
+	// Importing again needs -f.
+	if err := runMetaImport([]string{a1111}); err == nil || !strings.Contains(err.Error(), "-f") {
+		t.Errorf("second import err = %v, want refusal", err)
+	}
+	if err := runMetaImport([]string{"-f", a1111}); err != nil {
+		t.Errorf("forced import: %v", err)
+	}
+	if err := runMetaImport([]string{filepath.Join(dir, "plain.png")}); !errors.Is(err, errNoForeignMetadata) {
+		t.Errorf("plain import err = %v, want errNoForeignMetadata", err)
+	}
+	// Generated images have no foreign chunks and are left alone.
+	generated := writeMetaPNG(t, dir, "generated.png", testEditMetadata())
+	if err := runMetaImport([]string{generated}); !errors.Is(err, errNoForeignMetadata) {
+		t.Errorf("generated image import err = %v, want errNoForeignMetadata", err)
+	}
+	if err := runMetaImport(nil); err == nil {
+		t.Error("no args: expected usage error")
+	}
+
+	// Imported images were not generated through the API.
+	if _, err := analyzeImage(a1111); !errors.Is(err, errImported) {
+		t.Errorf("analyzeImage err = %v, want errImported", err)
+	}
+}
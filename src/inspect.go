@@ -0,0 +1,323 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"slices"
+	"strings"
+)
+
+const inspectUsage = "usage: agentpix inspect <file.png>"
+
+// inspectedChunk is one chunk as found on disk.
+type inspectedChunk struct {
+	Offset  int
+	Type    string
+	Length  int
+	CRCOK   bool
+	Details string // decoded summary, e.g. a text chunk's keyword
+}
+
+type pngHeader struct {
+	Width, Height     uint32
+	BitDepth          byte
+	ColorType         byte
+	Compression       byte
+	Filter, Interlace byte
+}
+
+// pngInspection is the result of walking a PNG. Problems are corruption or
+// spec violations; warnings are legal but likely to confuse readers.
+type pngInspection struct {
+	Chunks   []inspectedChunk
+	Header   *pngHeader
+	TextKeys []string
+	Problems []string
+	Warnings []string
+}
+
+// Chunks that may appear at most once, and chunks that must precede PLTE or
+// IDAT, per the PNG specification.
+var (
+	uniqueChunks      = map[string]bool{"IHDR": true, "PLTE": true, "IEND": true, "cHRM": true, "gAMA": true, "iCCP": true, "sBIT": true, "sRGB": true, "bKGD": true, "hIST": true, "tRNS": true, "pHYs": true, "tIME": true, "eXIf": true}
+	beforePLTEChunks  = map[string]bool{"cHRM": true, "gAMA": true, "iCCP": true, "sBIT": true, "sRGB": true}
+	beforeIDATChunks  = map[string]bool{"bKGD": true, "hIST": true, "tRNS": true, "pHYs": true, "sPLT": true, "eXIf": true}
+	knownCriticalType = map[string]bool{"IHDR": true, "PLTE": true, "IDAT": true, "IEND": true}
+)
+
+// validBitDepths lists the bit depths allowed for each IHDR color type.
+var validBitDepths = map[byte][]byte{
+	0: {1, 2, 4, 8, 16},
+	2: {8, 16},
+	3: {1, 2, 4, 8},
+	4: {8, 16},
+	6: {8, 16},
+}
+
+var colorTypeNames = map[byte]string{
+	0: "grayscale",
+	2: "RGB",
+	3: "indexed",
+	4: "grayscale+alpha",
+	6: "RGBA",
+}
+
+func runInspect(args []string) error {
+	if len(args) != 1 || strings.HasPrefix(args[0], "-") {
+		return fmt.Errorf("%s", inspectUsage)
+	}
+	path := args[0]
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read %q: %v", path, err)
+	}
+	if !pngHasSignature(data) {
+		return fmt.Errorf("%q is not a PNG file (bad signature)", path)
+	}
+
+	ins := inspectPNG(data)
+	writeInspection(os.Stdout, path, len(data), ins)
+	if n := len(ins.Problems); n > 0 {
+		return fmt.Errorf("%s: %d problems found", path, n)
+	}
+	return nil
+}
+
+// inspectPNG walks every chunk of a PNG, verifying CRCs and ordering rules.
+// It keeps going after errors where it can so all problems are reported; a
+// truncated chunk ends the walk.
+func inspectPNG(data []byte) *pngInspection {
+	ins := &pngInspection{}
+	problem := func(format string, args ...any) { ins.Problems = append(ins.Problems, fmt.Sprintf(format, args...)) }
+	warn := func(format string, args ...any) { ins.Warnings = append(ins.Warnings, fmt.Sprintf(format, args...)) }
+
+	seen := make(map[string]int)
+	var sawPLTE, sawIDAT, idatEnded, sawIEND bool
+	var agentpixChunks int
+
+	offset := len(pngSignature)
+	for offset < len(data) {
+		if sawIEND {
+			problem("%d bytes of trailing data after IEND", len(data)-offset)
+			break
+		}
+		if offset+8 > len(data) {
+			problem("truncated chunk header at offset %d", offset)
+			break
+		}
+		chunkLen := int(binary.BigEndian.Uint32(data[offset : offset+4]))
+		chunkType := string(data[offset+4 : offset+8])
+		chunkEnd := offset + 8 + chunkLen + 4
+		if chunkLen > 1<<31-1 || chunkEnd > len(data) {
+			problem("%s chunk at offset %d claims %d bytes but the file ends first", printableType(chunkType), offset, chunkLen)
+			break
+		}
+		payload := data[offset+8 : offset+8+chunkLen]
+		stored := binary.BigEndian.Uint32(data[chunkEnd-4 : chunkEnd])
+		crc := crc32.NewIEEE()
+		crc.Write(data[offset+4 : offset+8])
+		crc.Write(payload)
+		c := inspectedChunk{Offset: offset, Type: chunkType, Length: chunkLen, CRCOK: crc.Sum32() == stored}
+		if !c.CRCOK {
+			problem("%s chunk at offset %d has a bad CRC (stored %08x, computed %08x)", printableType(chunkType), offset, stored, crc.Sum32())
+		}
+
+		if !validChunkType(chunkType) {
+			problem("invalid chunk type %q at offset %d", chunkType, offset)
+		} else if len(ins.Chunks) == 0 && chunkType != "IHDR" {
+			problem("first chunk is %s, not IHDR", chunkType)
+		}
+		seen[chunkType]++
+		if uniqueChunks[chunkType] && seen[chunkType] == 2 {
+			problem("more than one %s chunk", chunkType)
+		}
+		if sawIDAT && chunkType != "IDAT" {
+			idatEnded = true
+		}
+		if beforePLTEChunks[chunkType] && (sawPLTE || sawIDAT) {
+			problem("%s chunk at offset %d must come before PLTE and IDAT", chunkType, offset)
+		}
+		if beforeIDATChunks[chunkType] && sawIDAT {
+			problem("%s chunk at offset %d must come before IDAT", chunkType, offset)
+		}
+		if validChunkType(chunkType) && isCritical(chunkType) && !knownCriticalType[chunkType] {
+			problem("unknown critical chunk %s at offset %d", chunkType, offset)
+		}
+
+		switch chunkType {
+		case "IHDR":
+			if len(ins.Chunks) == 0 {
+				ins.Header = decodeIHDR(payload, problem)
+			}
+		case "PLTE":
+			if sawIDAT {
+				problem("PLTE chunk at offset %d must come before IDAT", offset)
+			}
+			if chunkLen%3 != 0 || chunkLen == 0 || chunkLen > 256*3 {
+				problem("PLTE chunk has invalid length %d", chunkLen)
+			}
+			c.Details = fmt.Sprintf("%d entries", chunkLen/3)
+			sawPLTE = true
+		case "IDAT":
+			if idatEnded {
+				problem("IDAT chunk at offset %d is not contiguous with earlier IDAT chunks", offset)
+			}
+			sawIDAT = true
+		case "IEND":
+			if chunkLen != 0 {
+				problem("IEND chunk has %d bytes of data", chunkLen)
+			}
+			sawIEND = true
+		case "tEXt", "zTXt", "iTXt":
+			key := textChunkKey(pngChunk{Type: chunkType, Data: payload})
+			c.Details = key
+			if chunkType == "zTXt" || (chunkType == "iTXt" && len(payload) > len(key)+1 && payload[len(key)+1] == 1) {
+				c.Details += " (compressed)"
+			}
+			ins.TextKeys = append(ins.TextKeys, key)
+			value, err := textChunkValue(pngChunk{Type: chunkType, Data: payload})
+			if err != nil {
+				problem("%s chunk %q at offset %d: %v", chunkType, key, offset, err)
+			}
+			if key == metadataKey {
+				agentpixChunks++
+				if err == nil && !json.Valid([]byte(value)) {
+					problem("agentpix metadata at offset %d is not valid JSON", offset)
+				}
+			}
+		case sessionChunkType:
+			c.Details = "embedded session"
+		}
+		ins.Chunks = append(ins.Chunks, c)
+		offset = chunkEnd
+	}
+
+	if ins.Header != nil && ins.Header.ColorType == 3 && !sawPLTE {
+		problem("indexed-color image has no PLTE chunk")
+	}
+	if ins.Header != nil && (ins.Header.ColorType == 0 || ins.Header.ColorType == 4) && sawPLTE {
+		problem("PLTE chunk is not allowed for %s images", colorTypeNames[ins.Header.ColorType])
+	}
+	if !sawIDAT {
+		problem("no IDAT chunk")
+	}
+	if !sawIEND {
+		problem("missing IEND chunk")
+	}
+	if agentpixChunks > 1 {
+		warn("%d agentpix metadata chunks; readers use the first, edits may have left stale copies", agentpixChunks)
+	}
+	return ins
+}
+
+func decodeIHDR(payload []byte, problem func(string, ...any)) *pngHeader {
+	if len(payload) != 13 {
+		problem("IHDR chunk has length %d, want 13", len(payload))
+		return nil
+	}
+	h := &pngHeader{
+		Width:       binary.BigEndian.Uint32(payload[0:4]),
+		Height:      binary.BigEndian.Uint32(payload[4:8]),
+		BitDepth:    payload[8],
+		ColorType:   payload[9],
+		Compression: payload[10],
+		Filter:      payload[11],
+		Interlace:   payload[12],
+	}
+	if h.Width == 0 || h.Height == 0 || h.Width > 1<<31-1 || h.Height > 1<<31-1 {
+		problem("invalid image dimensions %dx%d", h.Width, h.Height)
+	}
+	depths, ok := validBitDepths[h.ColorType]
+	if !ok {
+		problem("invalid color type %d", h.ColorType)
+	} else if !slices.Contains(depths, h.BitDepth) {
+		problem("bit depth %d is not allowed for %s images", h.BitDepth, colorTypeNames[h.ColorType])
+	}
+	if h.Compression != 0 {
+		problem("unknown compression method %d", h.Compression)
+	}
+	if h.Filter != 0 {
+		problem("unknown filter method %d", h.Filter)
+	}
+	if h.Interlace > 1 {
+		problem("unknown interlace method %d", h.Interlace)
+	}
+	return h
+}
+
+func describeHeader(h *pngHeader) string {
+	color, ok := colorTypeNames[h.ColorType]
+	if !ok {
+		color = fmt.Sprintf("color type %d", h.ColorType)
+	}
+	desc := fmt.Sprintf("%dx%d, %d-bit %s", h.Width, h.Height, h.BitDepth, color)
+	if h.Interlace == 1 {
+		desc += ", interlaced"
+	}
+	return desc
+}
+
+// validChunkType reports whether t consists of four ASCII letters.
+func validChunkType(t string) bool {
+	if len(t) != 4 {
+		return false
+	}
+	for i := 0; i < 4; i++ {
+		if !(t[i] >= 'A' && t[i] <= 'Z' || t[i] >= 'a' && t[i] <= 'z') {
+			return false
+		}
+	}
+	return true
+}
+
+// isCritical reports whether a chunk type is critical (uppercase first
+// letter): decoders must understand it to display the image.
+func isCritical(t string) bool {
+	return t[0] >= 'A' && t[0] <= 'Z'
+}
+
+func printableType(t string) string {
+	if validChunkType(t) {
+		return t
+	}
+	return fmt.Sprintf("%q", t)
+}
+
+func writeInspection(w io.Writer, path string, size int, ins *pngInspection) {
+	fmt.Fprintf(w, "%s: %s, %d chunks\n", path, formatSize(int64(size)), len(ins.Chunks))
+	if ins.Header != nil {
+		fmt.Fprintf(w, "image: %s\n", describeHeader(ins.Header))
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintf(w, "  %8s  %-4s  %9s  %-3s  %s\n", "offset", "type", "length", "crc", "details")
+	for _, c := range ins.Chunks {
+		crc := "ok"
+		if !c.CRCOK {
+			crc = "BAD"
+		}
+		line := fmt.Sprintf("  %8d  %-4s  %9d  %-3s  %s", c.Offset, printableType(c.Type), c.Length, crc, c.Details)
+		fmt.Fprintln(w, strings.TrimRight(line, " "))
+	}
+	if len(ins.TextKeys) > 0 {
+		fmt.Fprintf(w, "\ntext keys: %s\n", strings.Join(ins.TextKeys, ", "))
+	}
+	if len(ins.Warnings) > 0 {
+		fmt.Fprintln(w, "\nwarnings:")
+		for _, msg := range ins.Warnings {
+			fmt.Fprintf(w, "  %s\n", msg)
+		}
+	}
+	if len(ins.Problems) > 0 {
+		fmt.Fprintln(w, "\nproblems:")
+		for _, msg := range ins.Problems {
+			fmt.Fprintf(w, "  %s\n", msg)
+		}
+	} else {
+		fmt.Fprintln(w, "\nno problems found")
+	}
+}
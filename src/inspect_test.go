@@ -0,0 +1,134 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// withChunks rebuilds minimalPNG with edit applied to its chunk list.
+func withChunks(edit func([]pngChunk) []pngChunk) []byte {
+	chunks, _ := pngChunks(minimalPNG())
+	return pngAssemble(edit(chunks))
+}
+
+func TestInspectPNG(t *testing.T) {
+	withMeta, _ := setMetadataText(minimalPNG(), `{"model":"flash-3.1"}`)
+	duplicate, _ := withText(withMeta, metadataKey, `{"model":"old"}`)
+	badCRC := append([]byte(nil), minimalPNG()...)
+	badCRC[8+8+13] ^= 0xff // first byte of IHDR's CRC
+
+	tests := []struct {
+		name        string
+		data        []byte
+		wantProblem string // substring of a reported problem; empty means none
+		wantWarning string
+		wantKeys    string
+	}{
+		{name: "valid", data: minimalPNG()},
+		{name: "metadata", data: withMeta, wantKeys: metadataKey},
+		{name: "duplicate agentpix", data: duplicate, wantWarning: "2 agentpix metadata chunks", wantKeys: "agentpix, agentpix"},
+		{name: "bad CRC", data: badCRC, wantProblem: "IHDR chunk at offset 8 has a bad CRC"},
+		{name: "truncated", data: minimalPNG()[:45], wantProblem: "IDAT chunk at offset 33 claims 13 bytes but the file ends first"},
+		{name: "trailing data", data: append(minimalPNG(), "junk"...), wantProblem: "trailing data after IEND"},
+		{
+			name: "chunk before IHDR",
+			data: withChunks(func(c []pngChunk) []pngChunk {
+				return append([]pngChunk{{Type: "tEXt", Data: []byte("a\x00b")}}, c...)
+			}),
+			wantProblem: "first chunk is tEXt",
+			wantKeys:    "a",
+		},
+		{
+			name: "split IDAT",
+			data: withChunks(func(c []pngChunk) []pngChunk {
+				return []pngChunk{c[0], c[1], {Type: "tEXt", Data: []byte("a\x00b")}, c[1], c[2]}
+			}),
+			wantProblem: "not contiguous",
+			wantKeys:    "a",
+		},
+		{
+			name: "gAMA after IDAT",
+			data: withChunks(func(c []pngChunk) []pngChunk {
+				return []pngChunk{c[0], c[1], {Type: "gAMA", Data: []byte{0, 0, 0xb1, 0x8f}}, c[2]}
+			}),
+			wantProblem: "gAMA chunk at offset",
+		},
+		{
+			name: "bad bit depth",
+			data: withChunks(func(c []pngChunk) []pngChunk {
+				c[0].Data = append([]byte(nil), c[0].Data...)
+				c[0].Data[8] = 3
+				return c
+			}),
+			wantProblem: "bit depth 3 is not allowed for grayscale",
+		},
+		{
+			name: "indexed without palette",
+			data: withChunks(func(c []pngChunk) []pngChunk {
+				c[0].Data = append([]byte(nil), c[0].Data...)
+				c[0].Data[9] = 3
+				return c
+			}),
+			wantProblem: "no PLTE chunk",
+		},
+		{
+			name: "unknown critical chunk",
+			data: withChunks(func(c []pngChunk) []pngChunk {
+				return append([]pngChunk{c[0], {Type: "QQQQ"}}, c[1:]...)
+			}),
+			wantProblem: "unknown critical chunk QQQQ",
+		},
+		{
+			name: "corrupt metadata",
+			data: withChunks(func(c []pngChunk) []pngChunk {
+				return append([]pngChunk{c[0], {Type: "tEXt", Data: []byte("agentpix\x00{not json")}}, c[1:]...)
+			}),
+			wantProblem: "not valid JSON",
+			wantKeys:    metadataKey,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ins := inspectPNG(tt.data)
+			problems := strings.Join(ins.Problems, "\n")
+			if tt.wantProblem == "" && problems != "" {
+				t.Errorf("unexpected problems:\n%s", problems)
+			}
+			if tt.wantProblem != "" && !strings.Contains(problems, tt.wantProblem) {
+				t.Errorf("problems = %q, want one containing %q", problems, tt.wantProblem)
+			}
+			warnings := strings.Join(ins.Warnings, "\n")
+			if (tt.wantWarning == "") != (warnings == "") || !strings.Contains(warnings, tt.wantWarning) {
+				t.Errorf("warnings = %q, want %q", warnings, tt.wantWarning)
+			}
+			if got := strings.Join(ins.TextKeys, ", "); got != tt.wantKeys {
+				t.Errorf("text keys = %q, want %q", got, tt.wantKeys)
+			}
+		})
+	}
+}
+
+func TestRunInspect(t *testing.T) {
+	dir := t.TempDir()
+	good := filepath.Join(dir, "good.png")
+	os.WriteFile(good, minimalPNG(), 0644)
+	bad := filepath.Join(dir, "bad.png")
+	os.WriteFile(bad, minimalPNG()[:40], 0644)
+	notPNG := filepath.Join(dir, "note.txt")
+	os.WriteFile(notPNG, []byte("hello"), 0644)
+
+	if err := runInspect([]string{good}); err != nil {
+		t.Errorf("runInspect(good): %v", err)
+	}
+	if err := runInspect([]string{bad}); err == nil || !strings.Contains(err.Error(), "problems found") {
+		t.Errorf("runInspect(bad): err = %v, want problems found", err)
+	}
+	if err := runInspect([]string{notPNG}); err == nil || !strings.Contains(err.Error(), "not a PNG") {
+		t.Errorf("runInspect(not PNG): err = %v", err)
+	}
+	if err := runInspect(nil); err == nil || !strings.Contains(err.Error(), "usage") {
+		t.Errorf("runInspect(): err = %v, want usage", err)
+	}
+}
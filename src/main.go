@@ -11,27 +11,42 @@ import (
 	"path/filepath"
 	"sort"
 	"strings"
+	"time"
 
 	"google.golang.org/genai"
 )
 
 type modelDef struct {
-	ID               string
-	Family           string
-	MaxInputImages   int
-	InputPerMTok     float64            // USD per 1M input tokens
-	OutputPerMTok    float64            // USD per 1M output text tokens
-	Sizes            []string           // supported output sizes, e.g. ["1K"] or ["1K","2K","4K"]
-	ImagePrices      map[string]float64 // size -> per-image USD cost
-	ThinkingLevels   []string           // supported thinking levels, e.g. ["min","high"]
+	ID                string
+	Family            string
+	MaxInputImages    int
+	Sizes             []string     // supported output sizes, e.g. ["1K"] or ["1K","2K","4K"]
+	ThinkingLevels    []string     // supported thinking levels, e.g. ["min","high"]
+	ThoughtSignatures bool         // model turns in history must carry thought signatures
+	Prices            []priceTable // dated price tables, oldest first
 }
 
-const pricesCollected = "2026-02-26"
+// priceTable holds published rates from a given date until the next table
+// for the same model takes effect.
+type priceTable struct {
+	Effective       string             // first day these rates apply (YYYY-MM-DD, UTC)
+	InputPerMTok    float64            // USD per 1M input tokens
+	CachedPerMTok   float64            // USD per 1M cached input tokens
+	OutputPerMTok   float64            // USD per 1M output text tokens
+	ThoughtsPerMTok float64            // USD per 1M thinking tokens
+	ImagePrices     map[string]float64 // size -> per-image USD cost
+}
 
 var modelDefs = map[string]modelDef{
-	"flash-2.5": {ID: "gemini-2.5-flash-image", Family: "flash", MaxInputImages: 3, InputPerMTok: 0.30, OutputPerMTok: 0.60, Sizes: []string{"1K"}, ImagePrices: map[string]float64{"1K": 0.039}},
-	"flash-3.1": {ID: "gemini-3.1-flash-image-preview", Family: "flash", MaxInputImages: 14, InputPerMTok: 0.25, OutputPerMTok: 1.50, Sizes: []string{"1K", "2K", "4K"}, ImagePrices: map[string]float64{"1K": 0.067, "2K": 0.101, "4K": 0.151}, ThinkingLevels: []string{"min", "high"}},
-	"pro-3.0":   {ID: "gemini-3-pro-image-preview", Family: "pro", MaxInputImages: 14, InputPerMTok: 2.00, OutputPerMTok: 12.00, Sizes: []string{"1K", "2K", "4K"}, ImagePrices: map[string]float64{"1K": 0.134, "2K": 0.134, "4K": 0.240}},
+	"flash-2.5": {ID: "gemini-2.5-flash-image", Family: "flash", MaxInputImages: 3, Sizes: []string{"1K"}, Prices: []priceTable{
+		{Effective: "2026-02-26", InputPerMTok: 0.30, CachedPerMTok: 0.03, OutputPerMTok: 0.60, ThoughtsPerMTok: 0.60, ImagePrices: map[string]float64{"1K": 0.039}},
+	}},
+	"flash-3.1": {ID: "gemini-3.1-flash-image-preview", Family: "flash", MaxInputImages: 14, Sizes: []string{"1K", "2K", "4K"}, ThinkingLevels: []string{"min", "high"}, ThoughtSignatures: true, Prices: []priceTable{
+		{Effective: "2026-02-26", InputPerMTok: 0.25, CachedPerMTok: 0.025, OutputPerMTok: 1.50, ThoughtsPerMTok: 1.50, ImagePrices: map[string]float64{"1K": 0.067, "2K": 0.101, "4K": 0.151}},
+	}},
+	"pro-3.0": {ID: "gemini-3-pro-image-preview", Family: "pro", MaxInputImages: 14, Sizes: []string{"1K", "2K", "4K"}, ThoughtSignatures: true, Prices: []priceTable{
+		{Effective: "2026-02-26", InputPerMTok: 2.00, CachedPerMTok: 0.20, OutputPerMTok: 12.00, ThoughtsPerMTok: 12.00, ImagePrices: map[string]float64{"1K": 0.134, "2K": 0.134, "4K": 0.240}},
+	}},
 }
 
 var modelAliases = map[string]string{
@@ -73,44 +88,67 @@ const outputPerm = 0644
 
 type stringSlice []string
 
-func (s *stringSlice) String() string    { return strings.Join(*s, ", ") }
+func (s *stringSlice) String() string     { return strings.Join(*s, ", ") }
 func (s *stringSlice) Set(v string) error { *s = append(*s, v); return nil }
 
 type options struct {
-	prompt  string
-	output  string
-	inputs  stringSlice
-	session string
-	model   string // resolved name: "flash-3.1", "flash-2.5", "pro-3.0"
-	modelID string // full model ID from modelDefs map
-	ratio    string
-	size     string // normalized: "" or "1K"/"2K"/"4K"
-	thinking string // "min" or "high"; empty means API default
-	force    bool
+	prompt       string
+	output       string
+	inputs       stringSlice
+	session      string
+	model        string // resolved name: "flash-3.1", "flash-2.5", "pro-3.0"
+	modelID      string // full model ID from modelDefs map
+	ratio        string
+	size         string // normalized: "" or "1K"/"2K"/"4K"
+	thinking     string // "min" or "high"; empty means API default
+	blobDir      string // store session images as files in this directory; empty means inline
+	compress     bool   // write the session as .session.json.gz
+	turn         int    // rewind the -s session to this many exchanges; 0 means all
+	keepImages   int    // send history images only from the last N exchanges; 0 sends all
+	embedSession bool   // also store the session inside the output PNG
+	force        bool
 }
 
 const usageText = `usage: agentpix -p <prompt> -o <output> [flags]
        agentpix transform -i <input> -o <output> [-f] <operation> [args]
-       agentpix meta <image.png>
-       agentpix cost <session-file-or-directory>
+       agentpix meta [--json] [-r] <image.png|directory>
+       agentpix meta set|strip|copy|import ...
+       agentpix inspect <file.png>
+       agentpix diff-meta <a.png|a.session.json> <b.png|b.session.json>
+       agentpix find [--prompt regex] [--model m] [--since date] [--ratio r] [--input name] [--index file] <directory>
+       agentpix cost [-r] [--group-by key] [--since date] [--until date] [--format text|csv|json] <session-file|image.png|directory>
        agentpix clean [-f] <directory>
+       agentpix session show|convert|migrate|upgrade|prune|export ...
+       agentpix tree [-r] [--format text|dot] <directory>
+       agentpix report [-r] [--link <dir>] -o <report.html> <session-file|image.png|directory>
 
 flags:
   -p   text prompt (required)
   -o   output PNG file path (required)
   -i   input image, repeatable (flash-2.5: 3 max, others: 14 max)
-  -s   session file to continue from
+  -s   session file, or PNG generated with --embed-session, to continue from
+  --turn  continue -s from its first N turns (rewind)
+  --keep-images  with -s, resend history images only from the last N turns (the new session keeps them all)
+  --embed-session  also store the compressed session inside the output PNG
   -m   model: flash (default), pro, flash-2.5, flash-3.1, pro-3.0
   -r   aspect ratio: 1:1 (default), 2:3, 3:2, 3:4, 4:3, 9:16, 16:9, 21:9
   -z   output size: 1K, 2K, 4K (flash-3.1, pro-3.0)
   -t   thinking level: min (default), high (flash-3.1)
+  -b   store session images as files in this directory (e.g. -b blobs)
+  -c   write the session gzip-compressed as .session.json.gz
   -f   overwrite existing output and session files
 
 subcommands:
   transform  flip, rotate, or resize an image locally (no API call)
   meta       show metadata embedded in a generated PNG
-  cost       estimate API cost from session files
-  clean      find and remove session files from a directory`
+  inspect    list and validate the chunks of a PNG file
+  find       search generated images by prompt and settings
+  diff-meta  compare the settings and prompt history of two images or sessions
+  cost       estimate API cost from session files or generated images
+  clean      find and remove session files from a directory
+  report     write a self-contained HTML report of sessions
+  session    inspect, convert, prune, and export session files
+  tree       show how sessions and images branch from each other`
 
 func main() {
 	if err := run(os.Args[1:]); err != nil {
@@ -132,10 +170,22 @@ func run(args []string) error {
 		return runClean(args[1:])
 	case "cost":
 		return runCost(args[1:])
+	case "diff-meta":
+		return runDiffMeta(args[1:])
+	case "find":
+		return runFind(args[1:])
+	case "inspect":
+		return runInspect(args[1:])
 	case "meta":
 		return runMeta(args[1:])
+	case "report":
+		return runReport(args[1:])
+	case "session":
+		return runSession(args[1:])
 	case "transform":
 		return runTransform(args[1:])
+	case "tree":
+		return runTree(args[1:])
 	}
 
 	opts, err := parseAndValidateFlags(args)
@@ -148,12 +198,34 @@ func run(args []string) error {
 	}
 
 	var history []*genai.Content
+	var sent []*genai.Content // history as sent to the API; pruned with --keep-images
+	var usageHistory []usageData
+	var parent *sessionParent
 	if opts.session != "" {
 		sess, loadErr := loadSession(opts.session, opts.model)
 		if loadErr != nil {
 			return loadErr
 		}
 		history = sess.History
+		usageHistory = sessionUsage(sess)
+		parent, err = newSessionParent(opts.session, sess)
+		if err != nil {
+			return err
+		}
+		if opts.turn > 0 {
+			modelTurns := countModelTurns(history)
+			history, err = rewindHistory(history, opts.turn)
+			if err != nil {
+				return fmt.Errorf("%s: %v", opts.session, err)
+			}
+			usageHistory = rewindUsage(usageHistory, modelTurns, opts.turn)
+			parent.Turn = opts.turn
+		}
+		if opts.keepImages > 0 {
+			var stats pruneStats
+			sent, stats = prunedCopy(history, opts.keepImages)
+			fmt.Fprintln(os.Stderr, describePrune(stats, opts.model))
+		}
 		// Inherit settings from session when not explicitly provided
 		if opts.ratio == "" && sess.Ratio != "" {
 			opts.ratio = sess.Ratio
@@ -164,6 +236,9 @@ func run(args []string) error {
 		if opts.thinking == "" && sess.Thinking != "" {
 			opts.thinking = sess.Thinking
 		}
+		if opts.blobDir == "" && sess.BlobDir != "" {
+			opts.blobDir = resolveSessionRelative(opts.session, sess.BlobDir)
+		}
 	}
 
 	// Apply defaults for settings not set by flags or session
@@ -197,7 +272,10 @@ func run(args []string) error {
 		}
 	}
 
-	chat, err := client.Chats.Create(ctx, opts.modelID, config, history)
+	if sent == nil {
+		sent = history
+	}
+	chat, err := client.Chats.Create(ctx, opts.modelID, config, sent)
 	if err != nil {
 		return fmt.Errorf("failed to create chat: %v", err)
 	}
@@ -237,32 +315,48 @@ func run(args []string) error {
 		fmt.Println(text)
 	}
 
-	meta := buildMetadata(opts, chat.History(true))
+	// Usage is recorded per API call and carried forward from the parent
+	// session, so the session and image metadata cover every turn.
+	if result.UsageMetadata != nil {
+		images := countImageParts(result.Candidates[0].Content.Parts)
+		usageHistory = append(usageHistory, newUsageEntry(result.UsageMetadata, images, opts.size, time.Now()))
+	}
+
+	// Images left out of the request with --keep-images stay in the new
+	// session; only this request was trimmed.
+	saved := chat.History(true)
+	if opts.keepImages > 0 {
+		saved = unprunedHistory(history, sent, saved)
+	}
+
+	meta := buildMetadata(opts, saved)
+	meta.Usage = usageHistory
+	meta.Backend = backendName(client.ClientConfig().Backend)
 	imageData = embedMetadata(imageData, meta)
 
+	sess := sessionData{Model: opts.model, Ratio: opts.ratio, Size: opts.size, Thinking: opts.thinking, History: saved, UsageHistory: usageHistory, Parent: parent}
+	if opts.embedSession {
+		imageData, err = embedSession(imageData, opts.output, sess)
+		if err != nil {
+			return fmt.Errorf("failed to embed session: %v", err)
+		}
+	}
+
 	if err := os.WriteFile(opts.output, imageData, outputPerm); err != nil {
 		return fmt.Errorf("failed to write output: %v", err)
 	}
 	fmt.Fprintf(os.Stderr, "saved %s (%d bytes)\n", opts.output, len(imageData))
 
 	// Save session alongside output (never overwrite the source session).
-	// Usage reflects this API call only; multi-turn sessions accumulate input
-	// tokens across calls, but we only record the final call's counts.
-	sessPath := sessionPath(opts.output)
-	var usage *usageData
-	if result.UsageMetadata != nil {
-		usage = &usageData{
-			PromptTokens:    result.UsageMetadata.PromptTokenCount,
-			CandidateTokens: result.UsageMetadata.CandidatesTokenCount,
-			TotalTokens:     result.UsageMetadata.TotalTokenCount,
-		}
+	sessPath := sessionPath(opts.output, opts.compress)
+	if opts.blobDir != "" {
+		sess.BlobDir = sessionRelative(sessPath, opts.blobDir)
 	}
-	sessBytes, err := json.Marshal(sessionData{Model: opts.model, Ratio: opts.ratio, Size: opts.size, Thinking: opts.thinking, History: chat.History(true), Usage: usage})
-	if err != nil {
-		return fmt.Errorf("failed to serialize session: %v", err)
+	if parent != nil {
+		parent.Path = sessionRelative(sessPath, parent.Path)
 	}
-	if err := os.WriteFile(sessPath, sessBytes, outputPerm); err != nil {
-		return fmt.Errorf("failed to write session: %v", err)
+	if err := writeSession(sessPath, sess); err != nil {
+		return err
 	}
 	fmt.Fprintf(os.Stderr, "session: %s\n", sessPath)
 
@@ -282,6 +376,11 @@ func parseAndValidateFlags(args []string) (*options, error) {
 	ratio := fs.String("r", "", "aspect ratio: 1:1, 2:3, 3:2, 3:4, 4:3, 9:16, 16:9, 21:9")
 	size := fs.String("z", "", "output size: 1K, 2K, or 4K (flash-3.1, pro-3.0)")
 	thinking := fs.String("t", "", "thinking level: min or high (flash-3.1)")
+	blobDir := fs.String("b", "", "store session images as files in this directory instead of inline")
+	compress := fs.Bool("c", false, "write the session gzip-compressed (.session.json.gz)")
+	turn := fs.Int("turn", 0, "continue -s from its first N turns")
+	keepImages := fs.Int("keep-images", 0, "send history images only from the last N exchanges of -s; the new session keeps them all")
+	embedSession := fs.Bool("embed-session", false, "also store the session inside the output PNG")
 	force := fs.Bool("f", false, "overwrite output and session files if they exist")
 
 	if err := fs.Parse(args); err != nil {
@@ -296,6 +395,19 @@ func parseAndValidateFlags(args []string) (*options, error) {
 		return nil, fmt.Errorf("usage: agentpix -p <prompt> -o <output> [-i <input>...] [-s <session>] [-m model] [-r <ratio>] [-z 1K|2K|4K] [-f]")
 	}
 
+	if *turn < 0 {
+		return nil, fmt.Errorf("invalid --turn %d: must be a positive turn number", *turn)
+	}
+	if *turn > 0 && *session == "" {
+		return nil, fmt.Errorf("--turn requires -s")
+	}
+	if *keepImages < 0 {
+		return nil, fmt.Errorf("invalid --keep-images %d: must be at least 1", *keepImages)
+	}
+	if *keepImages > 0 && *session == "" {
+		return nil, fmt.Errorf("--keep-images requires -s")
+	}
+
 	resolved := *model
 	if pinned, ok := modelAliases[resolved]; ok {
 		resolved = pinned
@@ -391,16 +503,21 @@ func parseAndValidateFlags(args []string) (*options, error) {
 	}
 
 	return &options{
-		prompt:  *prompt,
-		output:  *output,
-		inputs:  inputs,
-		session: *session,
-		model:    resolved,
-		modelID:  def.ID,
-		ratio:    *ratio,
-		size:     imageSize,
-		thinking: thinkingLevel,
-		force:    *force,
+		prompt:       *prompt,
+		output:       *output,
+		inputs:       inputs,
+		session:      *session,
+		model:        resolved,
+		modelID:      def.ID,
+		ratio:        *ratio,
+		size:         imageSize,
+		thinking:     thinkingLevel,
+		blobDir:      *blobDir,
+		compress:     *compress || strings.HasSuffix(*session, compressedSessionSuffix),
+		turn:         *turn,
+		keepImages:   *keepImages,
+		embedSession: *embedSession,
+		force:        *force,
 	}, nil
 }
 
@@ -409,7 +526,7 @@ func validatePaths(opts *options) error {
 		return fmt.Errorf("output directory %q does not exist", filepath.Dir(opts.output))
 	}
 
-	sessOut := sessionPath(opts.output)
+	sessOut := sessionPath(opts.output, opts.compress)
 
 	if opts.session != "" && !opts.force && filepath.Clean(sessOut) == filepath.Clean(opts.session) {
 		return fmt.Errorf("session save path %q collides with -s source (use -f to overwrite)", sessOut)
@@ -419,8 +536,12 @@ func validatePaths(opts *options) error {
 		return fmt.Errorf("output file %q already exists (use -f to overwrite)", opts.output)
 	}
 
-	if _, err := os.Stat(sessOut); err == nil && !opts.force {
-		return fmt.Errorf("session file %q already exists (use -f to overwrite)", sessOut)
+	// Both session variants are checked so an image never ends up with a
+	// plain and a compressed session that disagree.
+	for _, p := range []string{sessionPath(opts.output, false), sessionPath(opts.output, true)} {
+		if _, err := os.Stat(p); err == nil && !opts.force {
+			return fmt.Errorf("session file %q already exists (use -f to overwrite)", p)
+		}
 	}
 
 	for _, path := range opts.inputs {
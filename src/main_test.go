@@ -7,6 +7,7 @@ import (
 	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
 	"google.golang.org/genai"
 )
@@ -197,6 +198,80 @@ func TestParseAndValidateFlags(t *testing.T) {
 				}
 			},
 		},
+		{
+			name: "blob directory",
+			args: []string{"-p", "a cat", "-o", "out.png", "-b", "blobs"},
+			check: func(t *testing.T, opts *options) {
+				if opts.blobDir != "blobs" {
+					t.Errorf("blobDir = %q, want %q", opts.blobDir, "blobs")
+				}
+			},
+		},
+		{
+			name: "rewind turn",
+			args: []string{"-p", "a cat", "-o", "out.png", "-s", "prev.session.json", "--turn", "2"},
+			check: func(t *testing.T, opts *options) {
+				if opts.turn != 2 {
+					t.Errorf("turn = %d, want 2", opts.turn)
+				}
+			},
+		},
+		{
+			name:    "rewind turn without session",
+			args:    []string{"-p", "a cat", "-o", "out.png", "--turn", "2"},
+			wantErr: "--turn requires -s",
+		},
+		{
+			name:    "negative rewind turn",
+			args:    []string{"-p", "a cat", "-o", "out.png", "-s", "prev.session.json", "--turn", "-1"},
+			wantErr: "invalid --turn",
+		},
+		{
+			name: "keep images",
+			args: []string{"-p", "a cat", "-o", "out.png", "-s", "prev.session.json", "--keep-images", "2"},
+			check: func(t *testing.T, opts *options) {
+				if opts.keepImages != 2 {
+					t.Errorf("keepImages = %d, want 2", opts.keepImages)
+				}
+			},
+		},
+		{
+			name:    "keep images without session",
+			args:    []string{"-p", "a cat", "-o", "out.png", "--keep-images", "2"},
+			wantErr: "--keep-images requires -s",
+		},
+		{
+			name:    "negative keep images",
+			args:    []string{"-p", "a cat", "-o", "out.png", "-s", "prev.session.json", "--keep-images", "-1"},
+			wantErr: "invalid --keep-images",
+		},
+		{
+			name: "embed session",
+			args: []string{"-p", "a cat", "-o", "out.png", "--embed-session"},
+			check: func(t *testing.T, opts *options) {
+				if !opts.embedSession {
+					t.Error("embedSession = false, want true")
+				}
+			},
+		},
+		{
+			name: "compressed session",
+			args: []string{"-p", "a cat", "-o", "out.png", "-c"},
+			check: func(t *testing.T, opts *options) {
+				if !opts.compress {
+					t.Error("compress = false, want true")
+				}
+			},
+		},
+		{
+			name: "compressed source session is inherited",
+			args: []string{"-p", "a cat", "-o", "out.png", "-s", "prev.session.json.gz"},
+			check: func(t *testing.T, opts *options) {
+				if !opts.compress {
+					t.Error("compress = false, want true")
+				}
+			},
+		},
 		{
 			name: "pinned flash-2.5",
 			args: []string{"-p", "a cat", "-o", "out.png", "-m", "flash-2.5"},
@@ -292,7 +367,7 @@ func TestValidatePaths(t *testing.T) {
 			setup: func(t *testing.T) *options {
 				dir := t.TempDir()
 				outPath := filepath.Join(dir, "out.png")
-				os.WriteFile(sessionPath(outPath), []byte("{}"), 0644)
+				os.WriteFile(sessionPath(outPath, false), []byte("{}"), 0644)
 				return &options{output: outPath}
 			},
 			wantErr: "already exists",
@@ -302,10 +377,20 @@ func TestValidatePaths(t *testing.T) {
 			setup: func(t *testing.T) *options {
 				dir := t.TempDir()
 				outPath := filepath.Join(dir, "out.png")
-				os.WriteFile(sessionPath(outPath), []byte("{}"), 0644)
+				os.WriteFile(sessionPath(outPath, false), []byte("{}"), 0644)
 				return &options{output: outPath, force: true}
 			},
 		},
+		{
+			name: "compressed session collision on plain run",
+			setup: func(t *testing.T) *options {
+				dir := t.TempDir()
+				outPath := filepath.Join(dir, "out.png")
+				os.WriteFile(sessionPath(outPath, true), []byte("{}"), 0644)
+				return &options{output: outPath}
+			},
+			wantErr: "already exists",
+		},
 		{
 			name: "input file missing",
 			setup: func(t *testing.T) *options {
@@ -348,7 +433,7 @@ func TestValidatePaths(t *testing.T) {
 			setup: func(t *testing.T) *options {
 				dir := t.TempDir()
 				outPath := filepath.Join(dir, "out.png")
-				os.WriteFile(sessionPath(outPath), []byte("{}"), 0644)
+				os.WriteFile(sessionPath(outPath, false), []byte("{}"), 0644)
 				return &options{output: outPath, session: filepath.Join(dir, "other.json")}
 			},
 			wantErr: "already exists",
@@ -542,6 +627,257 @@ func TestLoadSession(t *testing.T) {
 	}
 }
 
+func TestSessionUsage(t *testing.T) {
+	t.Run("per-turn history returned as is", func(t *testing.T) {
+		sess := &sessionData{UsageHistory: []usageData{{PromptTokens: 10}, {PromptTokens: 20}}}
+		got := sessionUsage(sess)
+		if len(got) != 2 {
+			t.Fatalf("entries = %d, want 2", len(got))
+		}
+	})
+
+	t.Run("legacy single usage becomes one entry", func(t *testing.T) {
+		sess := &sessionData{Usage: &usageData{PromptTokens: 10, CandidateTokens: 5}}
+		got := sessionUsage(sess)
+		if len(got) != 1 || got[0].PromptTokens != 10 {
+			t.Fatalf("entries = %+v, want one entry with 10 prompt tokens", got)
+		}
+	})
+
+	t.Run("no usage data", func(t *testing.T) {
+		if got := sessionUsage(&sessionData{}); got != nil {
+			t.Fatalf("entries = %+v, want nil", got)
+		}
+		if sumUsage(nil) != nil {
+			t.Fatal("sumUsage(nil) should be nil")
+		}
+	})
+
+	t.Run("sum across turns", func(t *testing.T) {
+		sum := sumUsage([]usageData{
+			{PromptTokens: 100, CandidateTokens: 10, ThoughtsTokens: 5, CachedTokens: 40, TotalTokens: 115, Images: 1},
+			{PromptTokens: 200, CandidateTokens: 20, ThoughtsTokens: 7, TotalTokens: 227, Images: 1},
+		})
+		want := usageData{PromptTokens: 300, CandidateTokens: 30, ThoughtsTokens: 12, CachedTokens: 40, TotalTokens: 342, Images: 2}
+		if *sum != want {
+			t.Errorf("sum = %+v, want %+v", *sum, want)
+		}
+	})
+
+	t.Run("new entry copies metadata counts", func(t *testing.T) {
+		md := &genai.GenerateContentResponseUsageMetadata{
+			PromptTokenCount:        100,
+			CandidatesTokenCount:    20,
+			ThoughtsTokenCount:      30,
+			CachedContentTokenCount: 40,
+			TotalTokenCount:         150,
+		}
+		now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+		got := newUsageEntry(md, 1, "2K", now)
+		want := usageData{PromptTokens: 100, CandidateTokens: 20, ThoughtsTokens: 30, CachedTokens: 40, TotalTokens: 150, Images: 1, Size: "2K", Timestamp: "2026-03-01T12:00:00Z"}
+		if got != want {
+			t.Errorf("entry = %+v, want %+v", got, want)
+		}
+	})
+}
+
+func TestRewindHistory(t *testing.T) {
+	// Three exchanges; model turns carry signed image parts and unsigned text,
+	// as cleanHistoryForResume leaves them before rewinding.
+	history := func() []*genai.Content {
+		var h []*genai.Content
+		for _, p := range []string{"a cat", "make it blue", "add a hat"} {
+			h = append(h,
+				&genai.Content{Role: "user", Parts: []*genai.Part{{Text: p}}},
+				&genai.Content{Role: "model", Parts: []*genai.Part{
+					{InlineData: &genai.Blob{MIMEType: "image/png", Data: []byte(p)}, ThoughtSignature: []byte("sig")},
+				}},
+			)
+		}
+		return h
+	}
+
+	tests := []struct {
+		name     string
+		history  []*genai.Content
+		turn     int
+		wantLen  int
+		wantLast string
+		wantErr  string
+	}{
+		{name: "first turn", history: history(), turn: 1, wantLen: 2, wantLast: "a cat"},
+		{name: "middle turn", history: history(), turn: 2, wantLen: 4, wantLast: "make it blue"},
+		{name: "all turns", history: history(), turn: 3, wantLen: 6, wantLast: "add a hat"},
+		{name: "past the end", history: history(), turn: 4, wantErr: "past the end of the session (3 turns)"},
+		{
+			name:    "turn without reply",
+			history: append(history()[:2], &genai.Content{Role: "user", Parts: []*genai.Part{{Text: "dangling"}}}),
+			turn:    2,
+			wantErr: "no model reply",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := rewindHistory(tt.history, tt.turn)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != tt.wantLen {
+				t.Fatalf("len = %d, want %d", len(got), tt.wantLen)
+			}
+			last := got[len(got)-1]
+			if last.Role != "model" || string(last.Parts[0].InlineData.Data) != tt.wantLast {
+				t.Errorf("last content = %s %q, want model %q", last.Role, last.Parts[0].InlineData.Data, tt.wantLast)
+			}
+			if last.Parts[0].ThoughtSignature == nil {
+				t.Error("thought signature dropped")
+			}
+		})
+	}
+}
+
+func TestRewindUsage(t *testing.T) {
+	usage := []usageData{{TotalTokens: 1}, {TotalTokens: 2}, {TotalTokens: 3}}
+	tests := []struct {
+		name       string
+		usage      []usageData
+		modelTurns int
+		turn       int
+		want       int
+	}{
+		{name: "entry per turn", usage: usage, modelTurns: 3, turn: 2, want: 2},
+		{name: "older turns untracked", usage: usage[1:], modelTurns: 3, turn: 2, want: 1},
+		{name: "rewound before tracking", usage: usage[2:], modelTurns: 3, turn: 1, want: 0},
+		{name: "no usage", usage: nil, modelTurns: 3, turn: 3, want: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := rewindUsage(tt.usage, tt.modelTurns, tt.turn)
+			if len(got) != tt.want {
+				t.Fatalf("len = %d, want %d", len(got), tt.want)
+			}
+			if len(got) > 0 && got[0] != tt.usage[0] {
+				t.Errorf("first entry = %+v, want %+v", got[0], tt.usage[0])
+			}
+		})
+	}
+}
+
+func TestCompressedSession(t *testing.T) {
+	dir := t.TempDir()
+	out := filepath.Join(dir, "cat.png")
+	history := []*genai.Content{
+		{Role: "user", Parts: []*genai.Part{{Text: strings.Repeat("a tabby cat ", 200)}}},
+		{Role: "model", Parts: []*genai.Part{{Text: "here"}}},
+	}
+
+	gz := sessionPath(out, true)
+	if !strings.HasSuffix(gz, ".session.json.gz") {
+		t.Fatalf("sessionPath = %q, want .session.json.gz suffix", gz)
+	}
+	if err := writeSession(gz, sessionData{Model: testFlashName, History: history}); err != nil {
+		t.Fatalf("writeSession: %v", err)
+	}
+	raw, err := os.ReadFile(gz)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(raw) < 2 || raw[0] != 0x1f || raw[1] != 0x8b {
+		t.Fatal("session file is not gzip-compressed")
+	}
+
+	sess, size, err := readSession(gz)
+	if err != nil {
+		t.Fatalf("readSession: %v", err)
+	}
+	if size != int64(len(raw)) {
+		t.Errorf("size = %d, want on-disk size %d", size, len(raw))
+	}
+	if len(sess.History) != 2 || sess.History[0].Parts[0].Text != history[0].Parts[0].Text {
+		t.Errorf("history not preserved: %+v", sess.History)
+	}
+
+	// A compressed session that lost its .gz suffix still loads.
+	renamed := filepath.Join(dir, "renamed.session.json")
+	os.WriteFile(renamed, raw, 0644)
+	if _, _, err := readSession(renamed); err != nil {
+		t.Errorf("readSession(renamed): %v", err)
+	}
+
+	os.WriteFile(filepath.Join(dir, "broken.session.json.gz"), []byte{0x1f, 0x8b, 0x00}, 0644)
+	if _, _, err := readSession(filepath.Join(dir, "broken.session.json.gz")); err == nil || !strings.Contains(err.Error(), "decompress") {
+		t.Errorf("readSession(broken) error = %v, want decompress error", err)
+	}
+
+	paths, err := listSessionFiles(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) != 3 {
+		t.Errorf("listSessionFiles found %d files, want 3: %v", len(paths), paths)
+	}
+}
+
+func TestEmbeddedSession(t *testing.T) {
+	dir := t.TempDir()
+	history := []*genai.Content{
+		{Role: "user", Parts: []*genai.Part{{Text: "a cat"}}},
+		{Role: "model", Parts: []*genai.Part{{InlineData: &genai.Blob{MIMEType: "image/png", Data: minimalPNG()}}}},
+	}
+	parentPath := filepath.Join(dir, "base.session.json")
+	sess := sessionData{
+		Model:   testFlashName,
+		Ratio:   "16:9",
+		History: history,
+		BlobDir: "blobs",
+		Parent:  &sessionParent{Path: parentPath, SHA256: "abc", Turn: 1},
+	}
+
+	out := filepath.Join(dir, "cat.png")
+	data, err := embedSession(embedMetadata(minimalPNG(), imageMetadata{Version: metadataVersion, Model: testFlashName}), out, sess)
+	if err != nil {
+		t.Fatalf("embedSession: %v", err)
+	}
+	os.WriteFile(out, data, 0644)
+
+	if _, err := readImageMetadata(out); err != nil {
+		t.Errorf("metadata lost: %v", err)
+	}
+	if sess.Parent.Path != parentPath {
+		t.Errorf("embedSession modified the caller's parent: %q", sess.Parent.Path)
+	}
+
+	got, err := loadSession(out, testFlashName)
+	if err != nil {
+		t.Fatalf("loadSession: %v", err)
+	}
+	if got.Ratio != "16:9" || len(got.History) != 2 {
+		t.Errorf("session not preserved: ratio %q, %d turns", got.Ratio, len(got.History))
+	}
+	if got.BlobDir != "" {
+		t.Errorf("BlobDir = %q, want inline images", got.BlobDir)
+	}
+	if got.Parent == nil || got.Parent.Path != "base.session.json" {
+		t.Errorf("parent = %+v, want path relative to the PNG", got.Parent)
+	}
+
+	if _, err := loadSession(out, testProName); err == nil || !strings.Contains(err.Error(), "session was created with") {
+		t.Errorf("loadSession with other model: err = %v, want model mismatch", err)
+	}
+
+	plain := filepath.Join(dir, "plain.png")
+	os.WriteFile(plain, minimalPNG(), 0644)
+	if _, err := loadSession(plain, testFlashName); err == nil || !strings.Contains(err.Error(), "--embed-session") {
+		t.Errorf("loadSession(plain PNG): err = %v, want no embedded session", err)
+	}
+}
+
 func TestCleanHistoryForResume(t *testing.T) {
 	sig := []byte("opaque-signature-bytes-from-api")
 
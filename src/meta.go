@@ -1,8 +1,13 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
+	"image/png"
+	"io"
 	"os"
 	"path/filepath"
 	"strings"
@@ -11,20 +16,36 @@ import (
 	"google.golang.org/genai"
 )
 
-const metadataVersion = 1
+// metadataVersion 2 added input and session hashes, the tool version, output
+// dimensions, and backend. Version 1 records are read the same way; the new
+// fields are simply absent.
+const metadataVersion = 2
 const metadataKey = "agentpix"
 
+var errNoMetadata = errors.New("no agentpix metadata")
+
 type imageMetadata struct {
-	Version   int           `json:"version"`
-	Model     string        `json:"model"`
-	ModelID   string        `json:"model_id"`
-	Ratio     string        `json:"ratio"`
-	Size      string        `json:"size,omitempty"`
-	Thinking  string        `json:"thinking,omitempty"`
-	Inputs    []string      `json:"inputs,omitempty"`
-	Session   string        `json:"session,omitempty"`
-	Timestamp string        `json:"timestamp"`
-	Prompts   []promptEntry `json:"prompts"`
+	Version       int           `json:"version"`
+	Model         string        `json:"model"`
+	ModelID       string        `json:"model_id"`
+	Ratio         string        `json:"ratio"`
+	Size          string        `json:"size,omitempty"`
+	Thinking      string        `json:"thinking,omitempty"`
+	Inputs        []string      `json:"inputs,omitempty"`
+	InputSHA256   []string      `json:"input_sha256,omitempty"` // parallel to Inputs (v2)
+	Session       string        `json:"session,omitempty"`
+	SessionSHA256 string        `json:"session_sha256,omitempty"` // source session as read (v2)
+	Rewind        int           `json:"rewind,omitempty"`         // session was continued from this turn (--turn)
+	MigratedFrom  string        `json:"migrated_from,omitempty"`  // source model when created by session migrate
+	ImportedFrom  string        `json:"imported_from,omitempty"`  // source tool when created by meta import
+	Timestamp     string        `json:"timestamp"`
+	Prompts       []promptEntry `json:"prompts"`
+	Negative      string        `json:"negative_prompt,omitempty"` // imported from Stable Diffusion tools only
+	Usage         []usageData   `json:"usage,omitempty"`           // per-turn usage, as in the session
+	Width         int           `json:"width,omitempty"`           // output pixels (v2)
+	Height        int           `json:"height,omitempty"`          // output pixels (v2)
+	Backend       string        `json:"backend,omitempty"`         // "gemini-api" or "vertex-ai" (v2)
+	ToolVersion   string        `json:"tool_version,omitempty"`    // agentpix build version (v2)
 }
 
 type promptEntry struct {
@@ -33,6 +54,46 @@ type promptEntry struct {
 }
 
 func buildMetadata(opts *options, history []*genai.Content) imageMetadata {
+	prompts := historyPrompts(history)
+
+	// Hashes identify which version of each file was used; a file that can
+	// no longer be read gets an empty hash.
+	var inputs, inputHashes []string
+	for _, p := range opts.inputs {
+		inputs = append(inputs, filepath.Base(p))
+		sum, _ := fileSHA256(p)
+		inputHashes = append(inputHashes, sum)
+	}
+
+	var session, sessionHash string
+	var rewind int
+	if opts.session != "" {
+		session = filepath.Base(opts.session)
+		sessionHash, _ = fileSHA256(opts.session)
+		rewind = opts.turn
+	}
+
+	return imageMetadata{
+		Version:       metadataVersion,
+		Model:         opts.model,
+		ModelID:       opts.modelID,
+		Ratio:         opts.ratio,
+		Size:          opts.size,
+		Thinking:      opts.thinking,
+		Inputs:        inputs,
+		InputSHA256:   inputHashes,
+		Session:       session,
+		SessionSHA256: sessionHash,
+		Rewind:        rewind,
+		Timestamp:     time.Now().UTC().Format(time.RFC3339),
+		Prompts:       prompts,
+		ToolVersion:   buildVersion(),
+	}
+}
+
+// historyPrompts flattens history into one prompt entry per turn with text,
+// skipping images and thoughts.
+func historyPrompts(history []*genai.Content) []promptEntry {
 	var prompts []promptEntry
 	for _, c := range history {
 		if c == nil {
@@ -54,29 +115,18 @@ func buildMetadata(opts *options, history []*genai.Content) imageMetadata {
 			prompts = append(prompts, promptEntry{Role: c.Role, Text: textBuf.String()})
 		}
 	}
+	return prompts
+}
 
-	var inputs []string
-	for _, p := range opts.inputs {
-		inputs = append(inputs, filepath.Base(p))
-	}
-
-	var session string
-	if opts.session != "" {
-		session = filepath.Base(opts.session)
-	}
-
-	return imageMetadata{
-		Version:   metadataVersion,
-		Model:     opts.model,
-		ModelID:   opts.modelID,
-		Ratio:     opts.ratio,
-		Size:      opts.size,
-		Thinking:  opts.thinking,
-		Inputs:    inputs,
-		Session:   session,
-		Timestamp: time.Now().UTC().Format(time.RFC3339),
-		Prompts:   prompts,
+// backendName is the metadata name of the API backend a client talks to.
+func backendName(b genai.Backend) string {
+	switch b {
+	case genai.BackendVertexAI:
+		return "vertex-ai"
+	case genai.BackendGeminiAPI:
+		return "gemini-api"
 	}
+	return ""
 }
 
 func embedMetadata(imageData []byte, meta imageMetadata) []byte {
@@ -84,42 +134,141 @@ func embedMetadata(imageData []byte, meta imageMetadata) []byte {
 		fmt.Fprintln(os.Stderr, "note: output is not PNG, skipping metadata embedding")
 		return imageData
 	}
+	if cfg, err := png.DecodeConfig(bytes.NewReader(imageData)); err == nil {
+		meta.Width, meta.Height = cfg.Width, cfg.Height
+	}
 	jsonBytes, err := json.Marshal(meta)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "note: failed to marshal metadata: %v\n", err)
 		return imageData
 	}
-	result, err := pngSetText(imageData, metadataKey, string(jsonBytes))
+	result, err := setMetadataText(imageData, string(jsonBytes))
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "note: failed to embed metadata: %v\n", err)
 		return imageData
 	}
+	// XMP readers expect the packet uncompressed.
+	if tagged, err := pngSetITXt(result, xmpKey, buildXMP(meta), false); err == nil {
+		result = tagged
+	} else {
+		fmt.Fprintf(os.Stderr, "note: failed to embed XMP: %v\n", err)
+	}
 	return result
 }
 
-func runMeta(args []string) error {
-	if len(args) != 1 {
-		return fmt.Errorf("usage: agentpix meta <image.png>")
-	}
-	path := args[0]
+// metadataCompressThreshold is the metadata size, in bytes, above which the
+// iTXt chunk is zlib-compressed. Short records are left readable with
+// generic PNG tools.
+const metadataCompressThreshold = 1024
+
+// setMetadataText embeds raw metadata JSON into a PNG as a UTF-8 iTXt chunk.
+func setMetadataText(data []byte, raw string) ([]byte, error) {
+	return pngSetITXt(data, metadataKey, raw, len(raw) > metadataCompressThreshold)
+}
 
+// readMetadataText returns the raw agentpix metadata JSON embedded in a PNG.
+func readMetadataText(path string) (string, error) {
 	data, err := os.ReadFile(path)
 	if err != nil {
-		return fmt.Errorf("failed to read %q: %v", path, err)
+		return "", fmt.Errorf("failed to read %q: %v", path, err)
 	}
 
 	if !pngHasSignature(data) {
-		return fmt.Errorf("%q is not a PNG file (metadata is only embedded in PNG output)", path)
+		return "", fmt.Errorf("%q is not a PNG file (metadata is only embedded in PNG output)", path)
 	}
 
 	raw, err := pngGetText(data, metadataKey)
 	if err != nil {
-		return fmt.Errorf("no agentpix metadata found in %q", path)
+		return "", fmt.Errorf("%w found in %q", errNoMetadata, path)
+	}
+	return raw, nil
+}
+
+// readImageMetadata reads a PNG file and parses its embedded agentpix metadata.
+func readImageMetadata(path string) (*imageMetadata, error) {
+	raw, err := readMetadataText(path)
+	if err != nil {
+		return nil, err
 	}
 
 	var meta imageMetadata
 	if err := json.Unmarshal([]byte(raw), &meta); err != nil {
-		return fmt.Errorf("failed to parse metadata: %v", err)
+		return nil, fmt.Errorf("failed to parse metadata: %v", err)
+	}
+	return &meta, nil
+}
+
+const metaUsage = `usage: agentpix meta [--json] [-r] <image.png|directory>
+       agentpix meta set <image.png> key=value...
+       agentpix meta strip [--all] [-o <output.png>] [-f] <image.png>
+       agentpix meta copy [-f] <source.png> <destination.png>
+       agentpix meta import [-r] [-f] <image.png|directory>`
+
+func runMeta(args []string) error {
+	if len(args) > 0 {
+		switch args[0] {
+		case "set":
+			return runMetaSet(args[1:])
+		case "strip":
+			return runMetaStrip(args[1:])
+		case "copy":
+			return runMetaCopy(args[1:])
+		case "import":
+			return runMetaImport(args[1:])
+		}
+	}
+
+	fs := flag.NewFlagSet("agentpix meta", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+
+	asJSON := fs.Bool("json", false, "print the embedded metadata as JSON")
+	recursive := fs.Bool("r", false, "scan directories recursively")
+
+	if err := fs.Parse(args); err != nil {
+		return fmt.Errorf("%s", metaUsage)
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("%s", metaUsage)
+	}
+	path := fs.Arg(0)
+
+	if info, err := os.Stat(path); err == nil && info.IsDir() {
+		return runMetaDir(path, *recursive, *asJSON)
+	}
+
+	if *asJSON {
+		raw, err := readMetadataText(path)
+		if errors.Is(err, errNoMetadata) {
+			if fm, ferr := readForeignFile(path); ferr == nil {
+				out, err := json.MarshalIndent(fm, "", "  ")
+				if err != nil {
+					return fmt.Errorf("failed to serialize metadata: %v", err)
+				}
+				fmt.Println(string(out))
+				return nil
+			}
+		}
+		if err != nil {
+			return err
+		}
+		var buf bytes.Buffer
+		if err := json.Indent(&buf, []byte(raw), "", "  "); err != nil {
+			return fmt.Errorf("failed to parse metadata: %v", err)
+		}
+		fmt.Println(buf.String())
+		return nil
+	}
+
+	meta, err := readImageMetadata(path)
+	if errors.Is(err, errNoMetadata) {
+		// Automatic1111 and ComfyUI images are shown as found.
+		if fm, ferr := readForeignFile(path); ferr == nil {
+			printForeignMetadata(fm)
+			return nil
+		}
+	}
+	if err != nil {
+		return err
 	}
 
 	fmt.Printf("version:   %d\n", meta.Version)
@@ -131,12 +280,46 @@ func runMeta(args []string) error {
 	if meta.Thinking != "" {
 		fmt.Printf("thinking:  %s\n", meta.Thinking)
 	}
+	if meta.Width > 0 && meta.Height > 0 {
+		fmt.Printf("pixels:    %dx%d\n", meta.Width, meta.Height)
+	}
 	fmt.Printf("timestamp: %s\n", meta.Timestamp)
-	if len(meta.Inputs) > 0 {
+	if meta.Backend != "" {
+		fmt.Printf("backend:   %s\n", meta.Backend)
+	}
+	if meta.ToolVersion != "" {
+		fmt.Printf("tool:      agentpix %s\n", meta.ToolVersion)
+	}
+	if len(meta.Inputs) > 0 && len(meta.InputSHA256) == len(meta.Inputs) {
+		for i, in := range meta.Inputs {
+			label := "inputs:"
+			if i > 0 {
+				label = ""
+			}
+			fmt.Printf("%-10s %s%s\n", label, in, shortHash(meta.InputSHA256[i]))
+		}
+	} else if len(meta.Inputs) > 0 {
 		fmt.Printf("inputs:    %s\n", strings.Join(meta.Inputs, ", "))
 	}
 	if meta.Session != "" {
-		fmt.Printf("session:   %s\n", meta.Session)
+		line := meta.Session
+		if meta.Rewind > 0 {
+			line += fmt.Sprintf(" (from turn %d)", meta.Rewind)
+		}
+		fmt.Printf("session:   %s%s\n", line, shortHash(meta.SessionSHA256))
+	}
+	if meta.MigratedFrom != "" {
+		fmt.Printf("migrated:  from %s\n", meta.MigratedFrom)
+	}
+	if meta.ImportedFrom != "" {
+		name := foreignToolNames[meta.ImportedFrom]
+		if name == "" {
+			name = meta.ImportedFrom
+		}
+		fmt.Printf("imported:  from %s\n", name)
+	}
+	if usage := sumUsage(meta.Usage); usage != nil {
+		fmt.Printf("usage:     %s input, %s output tokens over %d calls\n", formatTokenCount(usage.PromptTokens), formatTokenCount(usage.CandidateTokens+usage.ThoughtsTokens), len(meta.Usage))
 	}
 
 	if len(meta.Prompts) > 0 {
@@ -145,7 +328,104 @@ func runMeta(args []string) error {
 		for i, p := range meta.Prompts {
 			fmt.Printf("  [%d] %s: %s\n", i+1, p.Role, p.Text)
 		}
+		if meta.Negative != "" {
+			fmt.Printf("  negative: %s\n", meta.Negative)
+		}
 	}
 
 	return nil
 }
+
+// shortHash formats a SHA-256 for display next to a file name, or returns ""
+// when there is none.
+func shortHash(sum string) string {
+	if len(sum) < 12 {
+		return ""
+	}
+	return "  sha256:" + sum[:12]
+}
+
+// metaListing is one image in the JSON form of a directory listing. Metadata
+// is passed through unchanged so fields from newer versions are kept.
+type metaListing struct {
+	File     string          `json:"file"`
+	Metadata json.RawMessage `json:"metadata"`
+}
+
+// runMetaDir lists the metadata of every generated PNG in a directory, one
+// line per image. PNGs without agentpix metadata are skipped with a note.
+func runMetaDir(dir string, recursive, asJSON bool) error {
+	paths, err := listFiles(dir, recursive, isPNGPath)
+	if err != nil {
+		return err
+	}
+
+	var listings []metaListing
+	var skipped int
+	for _, p := range paths {
+		raw, err := readMetadataText(p)
+		var meta imageMetadata
+		if err == nil {
+			if jerr := json.Unmarshal([]byte(raw), &meta); jerr != nil {
+				err = fmt.Errorf("failed to parse metadata: %v", jerr)
+			}
+		}
+		if err != nil {
+			if errors.Is(err, errNoMetadata) {
+				err = errNoMetadata
+				if fm, ferr := readForeignFile(p); ferr == nil {
+					err = fmt.Errorf("%s metadata only (convert with agentpix meta import)", foreignToolNames[fm.Tool])
+				}
+			}
+			fmt.Fprintf(os.Stderr, "skip %s: %v\n", p, err)
+			skipped++
+			continue
+		}
+		rel, relErr := filepath.Rel(dir, p)
+		if relErr != nil {
+			rel = p
+		}
+		if asJSON {
+			listings = append(listings, metaListing{File: rel, Metadata: json.RawMessage(raw)})
+			continue
+		}
+		size := meta.Size
+		if size == "" {
+			size = "-"
+		}
+		fmt.Printf("  %-30s %-10s %-5s %-3s %-20s %s\n", rel, meta.Model, meta.Ratio, size, meta.Timestamp, firstPrompt(meta.Prompts, 50))
+	}
+
+	if asJSON {
+		if listings == nil {
+			listings = []metaListing{}
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(listings); err != nil {
+			return fmt.Errorf("failed to write JSON: %v", err)
+		}
+	}
+	if len(paths)-skipped == 0 {
+		fmt.Fprintf(os.Stderr, "no agentpix images found in %s\n", dir)
+	} else if skipped > 0 {
+		fmt.Fprintf(os.Stderr, "skipped %d PNG files without readable agentpix metadata\n", skipped)
+	}
+	return nil
+}
+
+// firstPrompt returns the first user prompt on one line, shortened to limit
+// runes.
+func firstPrompt(prompts []promptEntry, limit int) string {
+	for _, p := range prompts {
+		if p.Role != "user" {
+			continue
+		}
+		text := []rune(strings.Join(strings.Fields(p.Text), " "))
+		if len(text) > limit {
+			return string(text[:limit-1]) + "…"
+		}
+		return string(text)
+	}
+	return ""
+}
@@ -1,7 +1,11 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
+	"fmt"
+	"image"
+	"image/png"
 	"os"
 	"path/filepath"
 	"strings"
@@ -152,6 +156,17 @@ func TestBuildMetadata(t *testing.T) {
 				}
 			},
 		},
+		{
+			name:        "rewind point recorded",
+			opts:        &options{model: testFlashName, modelID: testFlashModelID, ratio: "1:1", session: "dir/cat.session.json", turn: 2},
+			history:     []*genai.Content{{Role: "user", Parts: []*genai.Part{{Text: "x"}}}},
+			wantPrompts: 1,
+			check: func(t *testing.T, meta imageMetadata) {
+				if meta.Session != "cat.session.json" || meta.Rewind != 2 {
+					t.Errorf("session = %q, rewind = %d", meta.Session, meta.Rewind)
+				}
+			},
+		},
 	}
 
 	for _, tt := range tests {
@@ -167,6 +182,78 @@ func TestBuildMetadata(t *testing.T) {
 	}
 }
 
+func TestBuildMetadataHashes(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "hero.png")
+	os.WriteFile(input, []byte("hero v1"), 0644)
+	session := writeSessionFile(t, dir, "cat.session.json", sessionData{Model: testFlashName, History: []*genai.Content{}})
+
+	opts := &options{model: testFlashName, modelID: testFlashModelID, ratio: "1:1", session: session, inputs: stringSlice{input, filepath.Join(dir, "gone.png")}}
+	meta := buildMetadata(opts, nil)
+
+	if meta.Version != 2 {
+		t.Errorf("version = %d, want 2", meta.Version)
+	}
+	want, _ := fileSHA256(input)
+	if len(meta.InputSHA256) != 2 || meta.InputSHA256[0] != want || meta.InputSHA256[1] != "" {
+		t.Errorf("input hashes = %v, want [%s, \"\"]", meta.InputSHA256, want)
+	}
+	if want, _ := fileSHA256(session); meta.SessionSHA256 != want {
+		t.Errorf("session hash = %q, want %q", meta.SessionSHA256, want)
+	}
+	if meta.ToolVersion != buildVersion() {
+		t.Errorf("tool version = %q, want %q", meta.ToolVersion, buildVersion())
+	}
+
+	var buf bytes.Buffer
+	png.Encode(&buf, image.NewGray(image.Rect(0, 0, 3, 2)))
+	path := filepath.Join(dir, "out.png")
+	os.WriteFile(path, embedMetadata(buf.Bytes(), meta), 0644)
+	got, err := readImageMetadata(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Width != 3 || got.Height != 2 {
+		t.Errorf("dimensions = %dx%d, want 3x2", got.Width, got.Height)
+	}
+}
+
+func TestBackendName(t *testing.T) {
+	tests := []struct {
+		backend genai.Backend
+		want    string
+	}{
+		{genai.BackendGeminiAPI, "gemini-api"},
+		{genai.BackendVertexAI, "vertex-ai"},
+		{genai.BackendUnspecified, ""},
+	}
+	for _, tt := range tests {
+		if got := backendName(tt.backend); got != tt.want {
+			t.Errorf("backendName(%v) = %q, want %q", tt.backend, got, tt.want)
+		}
+	}
+}
+
+func TestFirstPrompt(t *testing.T) {
+	tests := []struct {
+		name    string
+		prompts []promptEntry
+		want    string
+	}{
+		{name: "none", prompts: nil, want: ""},
+		{name: "skips model text", prompts: []promptEntry{{Role: "model", Text: "hi"}, {Role: "user", Text: "a cat"}}, want: "a cat"},
+		{name: "joins lines", prompts: []promptEntry{{Role: "user", Text: "a\n\n  cat"}}, want: "a cat"},
+		{name: "truncates", prompts: []promptEntry{{Role: "user", Text: "a very long prompt"}}, want: "a very lo…"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := firstPrompt(tt.prompts, 10); got != tt.want {
+				t.Errorf("firstPrompt = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
 func TestRunMeta(t *testing.T) {
 	t.Run("valid embedded metadata", func(t *testing.T) {
 		dir := t.TempDir()
@@ -180,7 +267,7 @@ func TestRunMeta(t *testing.T) {
 			Prompts:   []promptEntry{{Role: "user", Text: "a cat"}},
 		}
 		jsonBytes, _ := json.Marshal(meta)
-		embedded, err := pngSetText(png, "agentpix", string(jsonBytes))
+		embedded, err := withText(png, "agentpix", string(jsonBytes))
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -192,6 +279,22 @@ func TestRunMeta(t *testing.T) {
 		}
 	})
 
+	t.Run("version 1 and 2 records", func(t *testing.T) {
+		dir := t.TempDir()
+		records := []string{
+			`{"version":1,"model":"flash-3.1","model_id":"gemini-3.1-flash-image-preview","ratio":"1:1","inputs":["a.png"],"timestamp":"2026-02-26T12:00:00Z","prompts":[]}`,
+			`{"version":2,"model":"flash-3.1","model_id":"gemini-3.1-flash-image-preview","ratio":"1:1","inputs":["a.png"],"input_sha256":["` + strings.Repeat("ab", 32) + `"],"session":"s.session.json","session_sha256":"` + strings.Repeat("cd", 32) + `","timestamp":"2026-02-26T12:00:00Z","prompts":[],"width":1024,"height":1024,"backend":"gemini-api","tool_version":"v1.4.0"}`,
+		}
+		for i, raw := range records {
+			data, _ := setMetadataText(minimalPNG(), raw)
+			path := filepath.Join(dir, fmt.Sprintf("v%d.png", i+1))
+			os.WriteFile(path, data, 0644)
+			if err := runMeta([]string{path}); err != nil {
+				t.Errorf("runMeta(v%d): %v", i+1, err)
+			}
+		}
+	})
+
 	t.Run("no metadata", func(t *testing.T) {
 		dir := t.TempDir()
 		path := filepath.Join(dir, "plain.png")
@@ -227,6 +330,30 @@ func TestRunMeta(t *testing.T) {
 		}
 	})
 
+	t.Run("json output", func(t *testing.T) {
+		dir := t.TempDir()
+		path := writeMetaPNG(t, dir, "cat.png", imageMetadata{Version: metadataVersion, Model: testFlashName})
+		if err := runMeta([]string{"--json", path}); err != nil {
+			t.Fatalf("runMeta --json: %v", err)
+		}
+	})
+
+	t.Run("directory listing", func(t *testing.T) {
+		dir := t.TempDir()
+		writeMetaPNG(t, dir, "cat.png", imageMetadata{Version: metadataVersion, Model: testFlashName, Prompts: []promptEntry{{Role: "user", Text: "a cat"}}})
+		os.WriteFile(filepath.Join(dir, "photo.png"), minimalPNG(), 0644)
+		os.WriteFile(filepath.Join(dir, "broken.png"), []byte("not a png"), 0644)
+		sub := filepath.Join(dir, "nested")
+		os.Mkdir(sub, 0755)
+		writeMetaPNG(t, sub, "dog.png", imageMetadata{Version: metadataVersion, Model: testProName})
+
+		for _, args := range [][]string{{dir}, {"-r", dir}, {"--json", "-r", dir}, {t.TempDir()}} {
+			if err := runMeta(args); err != nil {
+				t.Errorf("runMeta(%v): %v", args, err)
+			}
+		}
+	})
+
 	t.Run("no args", func(t *testing.T) {
 		err := runMeta(nil)
 		if err == nil {
@@ -237,3 +364,53 @@ func TestRunMeta(t *testing.T) {
 		}
 	})
 }
+
+func TestEmbedMetadata(t *testing.T) {
+	tests := []struct {
+		name       string
+		prompt     string
+		compressed bool
+	}{
+		{"short", "ein Hund mit Mütze", false},
+		{"long history", strings.Repeat("a cat in a hat, ", 200), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			meta := imageMetadata{
+				Version:   metadataVersion,
+				Model:     "flash-3.1",
+				ModelID:   "gemini-3.1-flash-image-preview",
+				Ratio:     "1:1",
+				Timestamp: "2026-02-26T12:00:00Z",
+				Prompts:   []promptEntry{{Role: "user", Text: tt.prompt}},
+			}
+			data := embedMetadata(minimalPNG(), meta)
+			chunks, err := pngChunks(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var c pngChunk
+			for _, ch := range chunks {
+				if isTextChunk(ch.Type) && textChunkKey(ch) == metadataKey {
+					c = ch
+				}
+			}
+			if c.Type != "iTXt" {
+				t.Fatalf("metadata chunk type = %q, want iTXt", c.Type)
+			}
+			if got := c.Data[len(metadataKey)+1] == 1; got != tt.compressed {
+				t.Errorf("compressed = %v, want %v", got, tt.compressed)
+			}
+
+			path := filepath.Join(t.TempDir(), "img.png")
+			os.WriteFile(path, data, 0644)
+			got, err := readImageMetadata(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Prompts[0].Text != tt.prompt {
+				t.Errorf("prompt = %q, want %q", got.Prompts[0].Text, tt.prompt)
+			}
+		})
+	}
+}
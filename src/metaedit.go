@@ -0,0 +1,289 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// metaSetters validates and encodes the metadata fields meta set may change.
+// An empty value removes optional fields. Prompt history and usage are not
+// editable; they describe what the API actually received and returned.
+var metaSetters = map[string]func(value string) (any, error){
+	"model": func(v string) (any, error) {
+		if !isKnownModel(v) {
+			return nil, fmt.Errorf("unknown model %q: valid models are %s", v, validModelNames())
+		}
+		// Aliases are stored pinned, as generation records them.
+		if pinned, ok := modelAliases[v]; ok {
+			v = pinned
+		}
+		return v, nil
+	},
+	"model_id": func(v string) (any, error) { return v, nil },
+	"ratio": func(v string) (any, error) {
+		if !validRatios[v] {
+			return nil, fmt.Errorf("invalid aspect ratio %q", v)
+		}
+		return v, nil
+	},
+	"size": func(v string) (any, error) {
+		v = strings.ToUpper(v)
+		if v != "1K" && v != "2K" && v != "4K" {
+			return nil, fmt.Errorf("invalid size %q: use 1K, 2K, or 4K", v)
+		}
+		return v, nil
+	},
+	"thinking": func(v string) (any, error) {
+		v = strings.ToLower(v)
+		if v != "min" && v != "high" {
+			return nil, fmt.Errorf("invalid thinking level %q: use min or high", v)
+		}
+		return v, nil
+	},
+	"session": func(v string) (any, error) { return v, nil },
+	"timestamp": func(v string) (any, error) {
+		if _, err := time.Parse(time.RFC3339, v); err != nil {
+			return nil, fmt.Errorf("invalid timestamp %q: use RFC 3339, e.g. 2026-02-26T15:04:05Z", v)
+		}
+		return v, nil
+	},
+	"inputs": func(v string) (any, error) { return strings.Split(v, ","), nil },
+}
+
+// requiredMetaFields cannot be removed with an empty value.
+var requiredMetaFields = map[string]bool{"model": true, "model_id": true, "ratio": true, "timestamp": true}
+
+func metaSetterNames() string {
+	var names []string
+	for k := range metaSetters {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// runMetaSet changes fields of the agentpix metadata in place. The JSON is
+// edited as a map so fields this version does not know are kept. Changing
+// model also sets model_id to that model's ID unless model_id is given too.
+func runMetaSet(args []string) error {
+	const usage = "usage: agentpix meta set <image.png> key=value..."
+	if len(args) < 2 {
+		return fmt.Errorf("%s", usage)
+	}
+	path := args[0]
+
+	raw, err := readMetadataText(path)
+	if err != nil {
+		return err
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(raw), &fields); err != nil {
+		return fmt.Errorf("failed to parse metadata: %v", err)
+	}
+
+	var explicitID bool
+	for _, arg := range args[1:] {
+		if strings.HasPrefix(arg, "model_id=") {
+			explicitID = true
+		}
+	}
+
+	for _, arg := range args[1:] {
+		key, value, ok := strings.Cut(arg, "=")
+		if !ok {
+			return fmt.Errorf("invalid assignment %q: use key=value\n%s", arg, usage)
+		}
+		setter, known := metaSetters[key]
+		if !known {
+			return fmt.Errorf("cannot set %q: settable fields are %s", key, metaSetterNames())
+		}
+		// Recorded hashes belong to the original inputs and session.
+		switch key {
+		case "inputs":
+			delete(fields, "input_sha256")
+		case "session":
+			delete(fields, "session_sha256")
+		}
+		if value == "" {
+			if requiredMetaFields[key] {
+				return fmt.Errorf("cannot remove required field %q", key)
+			}
+			delete(fields, key)
+			continue
+		}
+		v, err := setter(value)
+		if err != nil {
+			return err
+		}
+		encoded, _ := json.Marshal(v)
+		fields[key] = encoded
+		if key == "model" && !explicitID {
+			id, _ := json.Marshal(modelDefs[v.(string)].ID)
+			fields["model_id"] = id
+		}
+	}
+
+	updated, err := json.Marshal(fields)
+	if err != nil {
+		return fmt.Errorf("failed to serialize metadata: %v", err)
+	}
+	if err := replaceMetadata(path, path, string(updated)); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "updated %s\n", path)
+	return nil
+}
+
+// runMetaStrip removes the agentpix metadata and the XMP packet, or with --all
+// every text chunk, from a PNG, along with any embedded session. The image is
+// rewritten in place unless -o is given.
+func runMetaStrip(args []string) error {
+	fs := flag.NewFlagSet("agentpix meta strip", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+
+	all := fs.Bool("all", false, "remove all tEXt, zTXt and iTXt chunks, not just agentpix metadata")
+	output := fs.String("o", "", "write the stripped image here instead of in place")
+	force := fs.Bool("f", false, "overwrite the output file if it exists")
+
+	const usage = "usage: agentpix meta strip [--all] [-o <output.png>] [-f] <image.png>"
+
+	if err := fs.Parse(args); err != nil {
+		return fmt.Errorf("%s", usage)
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("%s", usage)
+	}
+	path := fs.Arg(0)
+	dst := path
+	if *output != "" {
+		dst = *output
+		if _, err := os.Stat(dst); err == nil && !*force {
+			return fmt.Errorf("output file %q already exists (use -f to overwrite)", dst)
+		}
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read %q: %v", path, err)
+	}
+	// The XMP packet repeats the prompt, so it goes with the metadata.
+	keys := []string{metadataKey, xmpKey}
+	if *all {
+		keys = []string{""}
+	}
+	stripped, removed := data, 0
+	for _, key := range keys {
+		var n int
+		stripped, n, err = pngRemoveText(stripped, key)
+		if err != nil {
+			return fmt.Errorf("%q: %v", path, err)
+		}
+		removed += n
+	}
+	stripped, sessions, err := pngRemoveChunks(stripped, sessionChunkType)
+	if err != nil {
+		return fmt.Errorf("%q: %v", path, err)
+	}
+	removed += sessions
+	if removed == 0 && dst == path {
+		fmt.Fprintf(os.Stderr, "%s: nothing to strip\n", path)
+		return nil
+	}
+	if err := writeFileAtomic(dst, stripped); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "removed %d metadata chunks, saved %s\n", removed, dst)
+	return nil
+}
+
+// runMetaCopy copies the agentpix metadata of one PNG onto another, replacing
+// any metadata the destination already has.
+func runMetaCopy(args []string) error {
+	fs := flag.NewFlagSet("agentpix meta copy", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+
+	force := fs.Bool("f", false, "replace existing agentpix metadata in the destination")
+
+	const usage = "usage: agentpix meta copy [-f] <source.png> <destination.png>"
+
+	if err := fs.Parse(args); err != nil {
+		return fmt.Errorf("%s", usage)
+	}
+	if fs.NArg() != 2 {
+		return fmt.Errorf("%s", usage)
+	}
+	src, dst := fs.Arg(0), fs.Arg(1)
+
+	raw, err := readMetadataText(src)
+	if err != nil {
+		return err
+	}
+	if _, err := readMetadataText(dst); err == nil && !*force {
+		return fmt.Errorf("%q already has agentpix metadata (use -f to replace it)", dst)
+	}
+	if err := replaceMetadata(dst, dst, raw); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "copied metadata from %s to %s\n", src, dst)
+	return nil
+}
+
+// replaceMetadata reads the PNG at src, replaces its agentpix metadata with
+// value, and writes the result to dst. The XMP packet is rebuilt from the new
+// record, as embedMetadata writes it; other chunks are copied unchanged.
+func replaceMetadata(src, dst, value string) error {
+	var meta imageMetadata
+	if err := json.Unmarshal([]byte(value), &meta); err != nil {
+		return fmt.Errorf("failed to parse metadata: %v", err)
+	}
+	data, err := os.ReadFile(src)
+	if err != nil {
+		return fmt.Errorf("failed to read %q: %v", src, err)
+	}
+	for _, key := range []string{metadataKey, xmpKey} {
+		data, _, err = pngRemoveText(data, key)
+		if err != nil {
+			return fmt.Errorf("%q: %v", src, err)
+		}
+	}
+	data, err = setMetadataText(data, value)
+	if err != nil {
+		return fmt.Errorf("%q: %v", src, err)
+	}
+	data, err = pngSetITXt(data, xmpKey, buildXMP(meta), false)
+	if err != nil {
+		return fmt.Errorf("%q: %v", src, err)
+	}
+	return writeFileAtomic(dst, data)
+}
+
+// writeFileAtomic writes data to a temporary file next to path and renames it
+// into place, so an interrupted write never leaves a truncated image.
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return fmt.Errorf("failed to write %q: %v", path, err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write %q: %v", path, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write %q: %v", path, err)
+	}
+	if err := os.Chmod(tmp.Name(), outputPerm); err != nil {
+		return fmt.Errorf("failed to write %q: %v", path, err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to write %q: %v", path, err)
+	}
+	return nil
+}
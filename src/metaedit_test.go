@@ -0,0 +1,347 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// idatBytes concatenates the image data chunks so tests can check that
+// metadata edits never touch pixels.
+func idatBytes(t *testing.T, path string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chunks, err := pngChunks(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out []byte
+	for _, c := range chunks {
+		if c.Type == "IDAT" {
+			out = append(out, c.Data...)
+		}
+	}
+	return out
+}
+
+func testEditMetadata() imageMetadata {
+	return imageMetadata{
+		Version:       metadataVersion,
+		Model:         "flash-3.1",
+		ModelID:       "gemini-3.1-flash-image-preview",
+		Ratio:         "1:1",
+		Inputs:        []string{"a.png"},
+		InputSHA256:   []string{"aaaa"},
+		Session:       "a.session.json",
+		SessionSHA256: "bbbb",
+		Timestamp:     "2026-02-26T12:00:00Z",
+		Prompts:       []promptEntry{{Role: "user", Text: "a cat"}},
+	}
+}
+
+func TestRunMetaSet(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		wantErr string
+		check   func(t *testing.T, m imageMetadata, raw string)
+	}{
+		{
+			name: "ratio and inputs",
+			args: []string{"ratio=16:9", "inputs=x.png,y.png"},
+			check: func(t *testing.T, m imageMetadata, raw string) {
+				if m.Ratio != "16:9" || strings.Join(m.Inputs, ",") != "x.png,y.png" {
+					t.Errorf("ratio = %q, inputs = %v", m.Ratio, m.Inputs)
+				}
+				if len(m.Prompts) != 1 {
+					t.Errorf("prompts changed: %v", m.Prompts)
+				}
+				if m.InputSHA256 != nil {
+					t.Errorf("stale input hashes kept: %v", m.InputSHA256)
+				}
+			},
+		},
+		{
+			name: "session drops its hash",
+			args: []string{"session=b.session.json"},
+			check: func(t *testing.T, m imageMetadata, raw string) {
+				if m.Session != "b.session.json" || m.SessionSHA256 != "" {
+					t.Errorf("session = %q, session_sha256 = %q", m.Session, m.SessionSHA256)
+				}
+				if len(m.InputSHA256) != 1 {
+					t.Errorf("input hashes dropped: %v", m.InputSHA256)
+				}
+			},
+		},
+		{
+			name: "remove optional field",
+			args: []string{"inputs="},
+			check: func(t *testing.T, m imageMetadata, raw string) {
+				if strings.Contains(raw, `"inputs"`) {
+					t.Errorf("inputs still present: %s", raw)
+				}
+			},
+		},
+		{
+			name: "unknown fields are kept",
+			args: []string{"size=2k"},
+			check: func(t *testing.T, m imageMetadata, raw string) {
+				if m.Size != "2K" {
+					t.Errorf("size = %q, want 2K", m.Size)
+				}
+				if !strings.Contains(raw, `"future":true`) {
+					t.Errorf("unknown field dropped: %s", raw)
+				}
+			},
+		},
+		{
+			name: "model alias updates model_id",
+			args: []string{"model=pro"},
+			check: func(t *testing.T, m imageMetadata, raw string) {
+				if m.Model != testProName || m.ModelID != testProModelID {
+					t.Errorf("model = %q, model_id = %q, want %q, %q", m.Model, m.ModelID, testProName, testProModelID)
+				}
+			},
+		},
+		{
+			name: "explicit model_id wins",
+			args: []string{"model_id=custom-endpoint", "model=pro"},
+			check: func(t *testing.T, m imageMetadata, raw string) {
+				if m.Model != testProName || m.ModelID != "custom-endpoint" {
+					t.Errorf("model = %q, model_id = %q", m.Model, m.ModelID)
+				}
+			},
+		},
+		{name: "invalid ratio", args: []string{"ratio=5:1"}, wantErr: "invalid aspect ratio"},
+		{name: "unknown model", args: []string{"model=nope"}, wantErr: "unknown model"},
+		{name: "bad timestamp", args: []string{"timestamp=yesterday"}, wantErr: "RFC 3339"},
+		{name: "required field", args: []string{"model="}, wantErr: "cannot remove required"},
+		{name: "not settable", args: []string{"prompts=x"}, wantErr: "cannot set"},
+		{name: "no equals", args: []string{"ratio"}, wantErr: "key=value"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := writeMetaPNG(t, dir, "img.png", testEditMetadata())
+			// Simulate metadata written by a newer version.
+			raw, _ := readMetadataText(path)
+			if err := replaceMetadata(path, path, strings.TrimSuffix(raw, "}")+`,"future":true}`); err != nil {
+				t.Fatal(err)
+			}
+			before := idatBytes(t, path)
+
+			err := runMeta(append([]string{"set", path}, tt.args...))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(idatBytes(t, path), before) {
+				t.Error("pixel data changed")
+			}
+			raw, err = readMetadataText(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var m imageMetadata
+			if err := json.Unmarshal([]byte(raw), &m); err != nil {
+				t.Fatal(err)
+			}
+			tt.check(t, m, raw)
+		})
+	}
+}
+
+func TestReplaceMetadataXMP(t *testing.T) {
+	dir := t.TempDir()
+	meta := testEditMetadata()
+	path := filepath.Join(dir, "img.png")
+	os.WriteFile(path, embedMetadata(minimalPNG(), meta), 0644)
+
+	if err := runMeta([]string{"set", path, "model=pro"}); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := os.ReadFile(path)
+	xmp, err := pngGetText(data, xmpKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(xmp, testProModelID) || strings.Contains(xmp, meta.ModelID) {
+		t.Errorf("XMP after set does not name the new model:\n%s", xmp)
+	}
+	chunks, _ := pngChunks(data)
+	var packets int
+	for _, c := range chunks {
+		if isTextChunk(c.Type) && textChunkKey(c) == xmpKey {
+			packets++
+		}
+	}
+	if packets != 1 {
+		t.Errorf("XMP packets = %d, want 1", packets)
+	}
+
+	// The copied record brings its own XMP description.
+	other := meta
+	other.Prompts = []promptEntry{{Role: "user", Text: "a dog"}}
+	dst := filepath.Join(dir, "dst.png")
+	os.WriteFile(dst, embedMetadata(minimalPNG(), other), 0644)
+	if err := runMeta([]string{"copy", "-f", path, dst}); err != nil {
+		t.Fatal(err)
+	}
+	data, _ = os.ReadFile(dst)
+	xmp, _ = pngGetText(data, xmpKey)
+	if !strings.Contains(xmp, "a cat") || strings.Contains(xmp, "a dog") {
+		t.Errorf("destination XMP after copy:\n%s", xmp)
+	}
+}
+
+func TestRunMetaStrip(t *testing.T) {
+	t.Run("in place", func(t *testing.T) {
+		dir := t.TempDir()
+		path := writeMetaPNG(t, dir, "img.png", testEditMetadata())
+		data, _ := os.ReadFile(path)
+		data, _ = withText(data, "Comment", "keep me")
+		os.WriteFile(path, data, 0644)
+		before := idatBytes(t, path)
+
+		if err := runMeta([]string{"strip", path}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := readMetadataText(path); err == nil {
+			t.Error("metadata still present")
+		}
+		data, _ = os.ReadFile(path)
+		if _, err := pngGetText(data, "Comment"); err != nil {
+			t.Errorf("other text chunk removed: %v", err)
+		}
+		if !bytes.Equal(idatBytes(t, path), before) {
+			t.Error("pixel data changed")
+		}
+	})
+
+	t.Run("all to output", func(t *testing.T) {
+		dir := t.TempDir()
+		path := writeMetaPNG(t, dir, "img.png", testEditMetadata())
+		out := filepath.Join(dir, "clean.png")
+
+		if err := runMeta([]string{"strip", "--all", "-o", out, path}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := readMetadataText(path); err != nil {
+			t.Errorf("source modified: %v", err)
+		}
+		data, _ := os.ReadFile(out)
+		chunks, err := pngChunks(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, c := range chunks {
+			if isTextChunk(c.Type) {
+				t.Errorf("text chunk %s left in output", c.Type)
+			}
+		}
+		if err := runMeta([]string{"strip", "-o", out, path}); err == nil {
+			t.Error("expected error for existing output without -f")
+		}
+	})
+
+	t.Run("no prompt left", func(t *testing.T) {
+		meta := testEditMetadata()
+		meta.Prompts = []promptEntry{{Role: "user", Text: "a tabby on the windowsill"}}
+		data, err := withText(embedMetadata(minimalPNG(), meta), "Comment", "keep me")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Contains(data, []byte(meta.Prompts[0].Text)) {
+			t.Fatal("test image does not carry the prompt in the clear")
+		}
+		path := filepath.Join(t.TempDir(), "img.png")
+		os.WriteFile(path, data, 0644)
+
+		if err := runMeta([]string{"strip", path}); err != nil {
+			t.Fatal(err)
+		}
+		data, _ = os.ReadFile(path)
+		if bytes.Contains(data, []byte(meta.Prompts[0].Text)) {
+			t.Error("prompt text left in stripped image")
+		}
+		if _, err := pngGetText(data, xmpKey); err == nil {
+			t.Error("XMP packet left in stripped image")
+		}
+		if _, err := pngGetText(data, "Comment"); err != nil {
+			t.Errorf("other text chunk removed: %v", err)
+		}
+	})
+
+	t.Run("embedded session", func(t *testing.T) {
+		dir := t.TempDir()
+		sess := sessionData{Version: sessionVersion, Model: testFlashName, History: blobTestHistory()}
+		for _, args := range [][]string{{"strip"}, {"strip", "--all"}} {
+			path := writeEmbeddedSessionPNG(t, dir, "img.png", sess)
+			if err := runMeta(append(args, path)); err != nil {
+				t.Fatal(err)
+			}
+			data, _ := os.ReadFile(path)
+			if _, found, err := pngFindChunk(data, sessionChunkType); err != nil || found {
+				t.Errorf("%v: session chunk left (found=%v, err=%v)", args, found, err)
+			}
+			checkStillPNG(t, path)
+		}
+	})
+
+	t.Run("nothing to strip", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "plain.png")
+		os.WriteFile(path, minimalPNG(), 0644)
+		if err := runMeta([]string{"strip", path}); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+func TestRunMetaCopy(t *testing.T) {
+	dir := t.TempDir()
+	src := writeMetaPNG(t, dir, "src.png", testEditMetadata())
+	dst := filepath.Join(dir, "dst.png")
+	os.WriteFile(dst, minimalPNG(), 0644)
+	before := idatBytes(t, dst)
+
+	if err := runMeta([]string{"copy", src, dst}); err != nil {
+		t.Fatal(err)
+	}
+	want, _ := readMetadataText(src)
+	got, err := readMetadataText(dst)
+	if err != nil || got != want {
+		t.Fatalf("dst metadata = %q, %v; want %q", got, err, want)
+	}
+	if !bytes.Equal(idatBytes(t, dst), before) {
+		t.Error("pixel data changed")
+	}
+
+	if err := runMeta([]string{"copy", src, dst}); err == nil || !strings.Contains(err.Error(), "-f") {
+		t.Errorf("err = %v, want refusal without -f", err)
+	}
+	if err := runMeta([]string{"copy", "-f", src, dst}); err != nil {
+		t.Errorf("copy -f: %v", err)
+	}
+	data, _ := os.ReadFile(dst)
+	chunks, _ := pngChunks(data)
+	var n int
+	for _, c := range chunks {
+		if isTextChunk(c.Type) && textChunkKey(c) == metadataKey {
+			n++
+		}
+	}
+	if n != 1 {
+		t.Errorf("%d agentpix chunks after copy -f, want 1", n)
+	}
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"google.golang.org/genai"
+)
+
+// skipSignature is the placeholder thought signature the Gemini API accepts
+// on model turns that were not produced by the model being called, such as
+// history carried over from another model.
+var skipSignature = []byte("skip_thought_signature_validator")
+
+const migrateUsage = "usage: agentpix session migrate -s <session-file> -m <model> -o <output.png> [-c] [-f]"
+
+// runSessionMigrate starts a session for a different model from an existing
+// one. No API call is made: the new session holds the source's prompt history
+// with the last output image as the latest model reply, and the output PNG is
+// that image, so the next generation with -s continues from it.
+func runSessionMigrate(args []string) error {
+	fs := flag.NewFlagSet("agentpix session migrate", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+
+	source := fs.String("s", "", "session file to migrate")
+	model := fs.String("m", "", "target model")
+	output := fs.String("o", "", "output PNG file path")
+	compress := fs.Bool("c", false, "write the session gzip-compressed (.session.json.gz)")
+	force := fs.Bool("f", false, "overwrite output and session files if they exist")
+
+	if err := fs.Parse(args); err != nil {
+		return fmt.Errorf("%s", migrateUsage)
+	}
+	if fs.NArg() > 0 || *source == "" || *model == "" || *output == "" {
+		return fmt.Errorf("%s", migrateUsage)
+	}
+
+	target := *model
+	if pinned, ok := modelAliases[target]; ok {
+		target = pinned
+	}
+	def, ok := modelDefs[target]
+	if !ok {
+		return fmt.Errorf("unknown model %q: valid models are %s", *model, validModelNames())
+	}
+	if strings.ToLower(filepath.Ext(*output)) != ".png" {
+		return fmt.Errorf("output file must be .png")
+	}
+
+	opts := &options{output: *output, session: *source, model: target, modelID: def.ID, compress: *compress, force: *force}
+	if err := validatePaths(opts); err != nil {
+		return err
+	}
+
+	sess, _, err := readSessionImages(*source)
+	if err != nil {
+		return err
+	}
+	if len(sess.Redacted) > 0 {
+		return fmt.Errorf("%q is a redacted export and cannot be migrated", *source)
+	}
+	from := sess.Model
+	if pinned, ok := modelAliases[from]; ok {
+		from = pinned
+	}
+	if from == target {
+		return fmt.Errorf("%s already uses %s; continue it with -s", *source, target)
+	}
+
+	history, image, err := migrateHistory(sess.History, def.ThoughtSignatures)
+	if err != nil {
+		return fmt.Errorf("%s: %v", *source, err)
+	}
+	parent, err := newSessionParent(*source, sess)
+	if err != nil {
+		return err
+	}
+
+	// Settings carry over only where the target model supports them.
+	opts.ratio = sess.Ratio
+	if opts.ratio == "" {
+		opts.ratio = "1:1"
+	}
+	for _, s := range def.Sizes {
+		if s == sess.Size && len(def.Sizes) > 1 {
+			opts.size = sess.Size
+		}
+	}
+	for _, l := range def.ThinkingLevels {
+		if l == sess.Thinking {
+			opts.thinking = sess.Thinking
+		}
+	}
+
+	// The source's usage is carried forward like a continuation's, tagged
+	// with the source model so cost prices it at that model's rates and does
+	// not charge the carried-over image again. A source without usage records
+	// gets one entry standing for that image.
+	var usage []usageData
+	for _, u := range sessionUsage(sess) {
+		if u.Model == "" {
+			u.Model = from
+		}
+		usage = append(usage, u)
+	}
+	if len(usage) == 0 {
+		usage = []usageData{{Images: 1, Size: sess.Size, Model: from}}
+	}
+
+	imageData, err := ensurePNG(image.Data)
+	if err != nil {
+		return fmt.Errorf("cannot convert the last output image to PNG: %v", err)
+	}
+	meta := buildMetadata(opts, history)
+	meta.MigratedFrom = sess.Model
+	meta.Usage = usage
+	imageData = embedMetadata(imageData, meta)
+	if err := os.WriteFile(opts.output, imageData, outputPerm); err != nil {
+		return fmt.Errorf("failed to write output: %v", err)
+	}
+	fmt.Fprintf(os.Stderr, "saved %s (%d bytes)\n", opts.output, len(imageData))
+
+	sessPath := sessionPath(opts.output, opts.compress)
+	parent.Path = sessionRelative(sessPath, parent.Path)
+	out := sessionData{Model: target, Ratio: opts.ratio, Size: opts.size, Thinking: opts.thinking, History: history, UsageHistory: usage, Parent: parent, MigratedFrom: sess.Model}
+	if err := writeSession(sessPath, out); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "session: %s (migrated from %s)\n", sessPath, sess.Model)
+	return nil
+}
+
+// migrateHistory builds a history for another model from a session's history.
+// User turns keep their prompt text. Model turns keep their reply text, and
+// the last output image becomes part of the final model turn; earlier images,
+// input images, and thought parts are dropped. Thought signatures are only
+// valid for the model that issued them, so all are removed; when the target
+// model requires signed model turns, the placeholder signature is set on every
+// model part instead. Returns the new history and the last output image.
+func migrateHistory(history []*genai.Content, signed bool) ([]*genai.Content, *genai.Blob, error) {
+	var image *genai.Blob
+	for _, c := range history {
+		if c == nil || c.Role != "model" {
+			continue
+		}
+		for _, p := range c.Parts {
+			if p != nil && p.InlineData != nil && len(p.InlineData.Data) > 0 && !p.Thought {
+				image = p.InlineData
+			}
+		}
+	}
+	if image == nil {
+		return nil, nil, fmt.Errorf("session has no output image to migrate")
+	}
+
+	var out []*genai.Content
+	for _, c := range history {
+		if c == nil || (c.Role != "user" && c.Role != "model") {
+			continue
+		}
+		var texts []string
+		var hadImage bool
+		for _, p := range c.Parts {
+			switch {
+			case p == nil || p.Thought:
+			case p.InlineData != nil || p.FileData != nil:
+				hadImage = true
+			case p.Text != "":
+				texts = append(texts, p.Text)
+			}
+		}
+		if len(texts) == 0 && hadImage {
+			texts = append(texts, "[image omitted]")
+		}
+		if len(texts) == 0 {
+			continue
+		}
+		nc := &genai.Content{Role: c.Role}
+		for _, t := range texts {
+			nc.Parts = append(nc.Parts, &genai.Part{Text: t})
+		}
+		// Consecutive turns of the same role are merged so the history still
+		// alternates after empty turns are dropped.
+		if n := len(out); n > 0 && out[n-1].Role == nc.Role {
+			out[n-1].Parts = append(out[n-1].Parts, nc.Parts...)
+		} else {
+			out = append(out, nc)
+		}
+	}
+	if len(out) == 0 || out[len(out)-1].Role != "model" {
+		out = append(out, &genai.Content{Role: "model"})
+	}
+
+	last := out[len(out)-1]
+	if len(last.Parts) == 1 && last.Parts[0].Text == "[image omitted]" {
+		last.Parts = nil
+	}
+	last.Parts = append(last.Parts, &genai.Part{InlineData: &genai.Blob{MIMEType: image.MIMEType, Data: image.Data}})
+
+	if signed {
+		for _, c := range out {
+			if c.Role != "model" {
+				continue
+			}
+			for _, p := range c.Parts {
+				p.ThoughtSignature = skipSignature
+			}
+		}
+	}
+	return out, image, nil
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"google.golang.org/genai"
+)
+
+func migrateTestHistory() []*genai.Content {
+	return testHistory(
+		testTurn{prompt: "a cat", input: testBlob("image/jpeg", []byte("reference")), thought: "thinking", reply: "here is your cat", output: testBlob("image/png", []byte("first")), signature: "flash-sig"},
+		testTurn{prompt: "make it blue", output: testBlob("image/png", minimalPNG()), signature: "flash-sig"},
+	)
+}
+
+func TestMigrateHistory(t *testing.T) {
+	t.Run("signed target", func(t *testing.T) {
+		history, image, err := migrateHistory(migrateTestHistory(), true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(image.Data) != string(minimalPNG()) {
+			t.Errorf("image = %q, want last output image", image.Data)
+		}
+		if len(history) != 4 {
+			t.Fatalf("len = %d, want 4", len(history))
+		}
+		if len(history[0].Parts) != 1 || history[0].Parts[0].Text != "a cat" {
+			t.Errorf("user turn = %+v, want prompt text only", history[0].Parts)
+		}
+		if len(history[1].Parts) != 1 || history[1].Parts[0].Text != "here is your cat" {
+			t.Errorf("model turn = %+v, want reply text only", history[1].Parts)
+		}
+		last := history[3].Parts
+		if len(last) != 1 || last[0].InlineData == nil {
+			t.Fatalf("final model turn = %+v, want the last image", last)
+		}
+		for _, c := range history {
+			for _, p := range c.Parts {
+				want := ""
+				if c.Role == "model" {
+					want = string(skipSignature)
+				}
+				if string(p.ThoughtSignature) != want {
+					t.Errorf("%s part signature = %q, want %q", c.Role, p.ThoughtSignature, want)
+				}
+			}
+		}
+		// Signed turns survive resume cleaning intact.
+		if cleaned := cleanHistoryForResume(history); len(cleaned[1].Parts) != 1 {
+			t.Error("cleanHistoryForResume dropped migrated parts")
+		}
+	})
+
+	t.Run("unsigned target", func(t *testing.T) {
+		history, _, err := migrateHistory(migrateTestHistory(), false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, c := range history {
+			for _, p := range c.Parts {
+				if p.ThoughtSignature != nil {
+					t.Errorf("%s part keeps signature %q", c.Role, p.ThoughtSignature)
+				}
+			}
+		}
+	})
+
+	t.Run("source history untouched", func(t *testing.T) {
+		src := migrateTestHistory()
+		migrateHistory(src, true)
+		if string(src[3].Parts[0].ThoughtSignature) != "flash-sig" {
+			t.Error("source signature was modified")
+		}
+	})
+
+	t.Run("image-only turns", func(t *testing.T) {
+		src := []*genai.Content{
+			{Role: "user", Parts: []*genai.Part{{InlineData: &genai.Blob{MIMEType: "image/png", Data: []byte("in")}}}},
+			{Role: "model", Parts: []*genai.Part{{InlineData: &genai.Blob{MIMEType: "image/png", Data: []byte("out")}}}},
+		}
+		history, _, err := migrateHistory(src, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if history[0].Parts[0].Text != "[image omitted]" {
+			t.Errorf("user turn = %+v", history[0].Parts)
+		}
+		if len(history[1].Parts) != 1 || string(history[1].Parts[0].InlineData.Data) != "out" {
+			t.Errorf("model turn = %+v", history[1].Parts)
+		}
+	})
+
+	t.Run("no output image", func(t *testing.T) {
+		src := []*genai.Content{
+			{Role: "user", Parts: []*genai.Part{{Text: "a cat"}}},
+			{Role: "model", Parts: []*genai.Part{{Text: "sorry"}}},
+		}
+		if _, _, err := migrateHistory(src, false); err == nil || !strings.Contains(err.Error(), "no output image") {
+			t.Errorf("err = %v", err)
+		}
+	})
+}
+
+func TestRunSessionMigrate(t *testing.T) {
+	dir := t.TempDir()
+	src := writeSessionFile(t, dir, "cat.session.json", sessionData{
+		Model: testFlashName, Ratio: "16:9", Size: "2K", Thinking: "high",
+		History:      migrateTestHistory(),
+		UsageHistory: []usageData{
+			{PromptTokens: 1000, CandidateTokens: 1300, TotalTokens: 2300, Images: 1, Timestamp: "2026-03-01T10:00:00Z"},
+			{PromptTokens: 2000, CandidateTokens: 1300, TotalTokens: 3300, Images: 1, Timestamp: "2026-03-01T10:05:00Z"},
+		},
+	})
+	out := filepath.Join(dir, "cat-pro.png")
+
+	if err := runSessionMigrate([]string{"-s", src, "-m", "pro", "-o", out}); err != nil {
+		t.Fatalf("runSessionMigrate: %v", err)
+	}
+
+	sess, err := loadSession(sessionPath(out, false), testProName)
+	if err != nil {
+		t.Fatalf("loadSession: %v", err)
+	}
+	if sess.MigratedFrom != testFlashName {
+		t.Errorf("MigratedFrom = %q", sess.MigratedFrom)
+	}
+	if sess.Ratio != "16:9" || sess.Size != "2K" || sess.Thinking != "" {
+		t.Errorf("settings = %q %q %q, want ratio and size kept, thinking dropped", sess.Ratio, sess.Size, sess.Thinking)
+	}
+	if sess.Parent == nil || sess.Parent.Path != "cat.session.json" || sess.Parent.Turn != 2 {
+		t.Errorf("Parent = %+v", sess.Parent)
+	}
+	if len(sess.UsageHistory) != 2 || sess.UsageHistory[1].Model != testFlashName || sess.UsageHistory[1].PromptTokens != 2000 {
+		t.Errorf("usage not carried over with the source model: %+v", sess.UsageHistory)
+	}
+
+	// The carried-over image was generated by the source model, not the target.
+	srcCost, err := analyzeSession(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	migratedCost, err := analyzeSession(sessionPath(out, false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := migratedCost.Total - srcCost.Total; d > 1e-9 || d < -1e-9 {
+		t.Errorf("migrated session cost = %v, want the source's %v", migratedCost.Total, srcCost.Total)
+	}
+
+	// Without usage records the carried image still counts as the source's.
+	legacy := writeSessionFile(t, dir, "old.session.json", sessionData{Model: testFlashName, Size: "2K", History: migrateTestHistory()})
+	legacyOut := filepath.Join(dir, "old-pro.png")
+	if err := runSessionMigrate([]string{"-s", legacy, "-m", "pro", "-o", legacyOut}); err != nil {
+		t.Fatal(err)
+	}
+	legacyCost, err := analyzeSession(sessionPath(legacyOut, false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := imagePrice(currentPrices(modelDefs[testFlashName]), "2K"); legacyCost.Total != want {
+		t.Errorf("migrated legacy session cost = %v, want one %s image at %v", legacyCost.Total, testFlashName, want)
+	}
+
+	meta, err := readImageMetadata(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.Model != testProName || meta.MigratedFrom != testFlashName || meta.Session != "cat.session.json" {
+		t.Errorf("metadata = %+v", meta)
+	}
+
+	errTests := []struct {
+		name    string
+		args    []string
+		wantErr string
+	}{
+		{name: "missing flags", args: []string{"-s", src}, wantErr: "usage:"},
+		{name: "unknown model", args: []string{"-s", src, "-m", "nano", "-o", filepath.Join(dir, "x.png")}, wantErr: "unknown model"},
+		{name: "same model", args: []string{"-s", src, "-m", "flash", "-o", filepath.Join(dir, "y.png")}, wantErr: "already uses"},
+		{name: "output exists", args: []string{"-s", src, "-m", "pro", "-o", out}, wantErr: "already exists"},
+		{name: "not png", args: []string{"-s", src, "-m", "pro", "-o", filepath.Join(dir, "z.jpg")}, wantErr: "must be .png"},
+	}
+	for _, tt := range errTests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := runSessionMigrate(tt.args)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "y.png")); err == nil {
+		t.Error("failed migration wrote an output file")
+	}
+}
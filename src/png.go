@@ -2,12 +2,15 @@ package main
 
 import (
 	"bytes"
+	"compress/zlib"
 	"encoding/binary"
 	"errors"
 	"fmt"
 	"hash/crc32"
 	"image"
 	"image/png"
+	"io"
+	"os"
 
 	_ "image/jpeg"
 )
@@ -26,48 +29,19 @@ func pngHasSignature(data []byte) bool {
 	return true
 }
 
-// pngSetText inserts a tEXt chunk with the given key and value after the IHDR chunk.
-// The input must be a valid PNG (starts with the 8-byte signature followed by IHDR).
-func pngSetText(data []byte, key, value string) ([]byte, error) {
-	if !pngHasSignature(data) {
-		return nil, errors.New("not a PNG file")
-	}
-
-	// IHDR starts at offset 8. Read its data length to find where it ends.
-	if len(data) < 8+8 { // signature + length + type minimum
-		return nil, errors.New("PNG too short")
+// isPNGFile reports whether the file at path starts with the PNG signature,
+// whatever its name.
+func isPNGFile(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
 	}
-	ihdrLen := binary.BigEndian.Uint32(data[8:12])
-	// End of IHDR chunk: signature(8) + length(4) + type(4) + data(ihdrLen) + crc(4)
-	insertAt := 8 + 4 + 4 + int(ihdrLen) + 4
-	if insertAt > len(data) {
-		return nil, errors.New("PNG IHDR extends beyond data")
+	defer f.Close()
+	sig := make([]byte, len(pngSignature))
+	if _, err := io.ReadFull(f, sig); err != nil {
+		return false
 	}
-
-	// Build tEXt chunk payload: key + null separator + value
-	payload := make([]byte, len(key)+1+len(value))
-	copy(payload, key)
-	payload[len(key)] = 0x00
-	copy(payload[len(key)+1:], value)
-
-	// Build the full chunk: length(4) + "tEXt"(4) + payload + CRC(4)
-	chunkType := []byte("tEXt")
-	chunk := make([]byte, 4+4+len(payload)+4)
-	binary.BigEndian.PutUint32(chunk[0:4], uint32(len(payload)))
-	copy(chunk[4:8], chunkType)
-	copy(chunk[8:], payload)
-	crc := crc32.NewIEEE()
-	crc.Write(chunkType)
-	crc.Write(payload)
-	binary.BigEndian.PutUint32(chunk[8+len(payload):], crc.Sum32())
-
-	// Splice: data[:insertAt] + chunk + data[insertAt:]
-	result := make([]byte, len(data)+len(chunk))
-	copy(result, data[:insertAt])
-	copy(result[insertAt:], chunk)
-	copy(result[insertAt+len(chunk):], data[insertAt:])
-
-	return result, nil
+	return pngHasSignature(sig)
 }
 
 // ensurePNG returns the data unchanged if it is already PNG. Otherwise it decodes
@@ -87,7 +61,13 @@ func ensurePNG(data []byte) ([]byte, error) {
 	return buf.Bytes(), nil
 }
 
-// pngGetText scans a PNG for a tEXt chunk matching the given key and returns its value.
+// maxTextSize bounds the decompressed size of a zTXt or iTXt value, so a
+// crafted chunk cannot exhaust memory.
+const maxTextSize = 64 << 20
+
+// pngGetText scans a PNG for a tEXt, zTXt or iTXt chunk matching the given
+// key and returns its value. tEXt values are returned as stored: older
+// agentpix versions wrote UTF-8 there despite the spec's Latin-1.
 func pngGetText(data []byte, key string) (string, error) {
 	if !pngHasSignature(data) {
 		return "", errors.New("not a PNG file")
@@ -103,21 +83,227 @@ func pngGetText(data []byte, key string) (string, error) {
 			break
 		}
 
-		if chunkType == "tEXt" {
-			payload := data[offset+8 : offset+8+chunkLen]
-			// Find null separator between key and value
-			for i := 0; i < len(payload); i++ {
-				if payload[i] == 0x00 {
-					if string(payload[:i]) == key {
-						return string(payload[i+1:]), nil
-					}
-					break
-				}
+		if isTextChunk(chunkType) {
+			c := pngChunk{Type: chunkType, Data: data[offset+8 : offset+8+chunkLen]}
+			if textChunkKey(c) == key {
+				return textChunkValue(c)
+			}
+		}
+
+		offset = chunkEnd
+	}
+
+	return "", errors.New("text chunk not found for key: " + key)
+}
+
+// textChunkValue decodes the value of a tEXt, zTXt or iTXt chunk.
+func textChunkValue(c pngChunk) (string, error) {
+	i := bytes.IndexByte(c.Data, 0)
+	if i < 0 {
+		return "", fmt.Errorf("%s chunk has no keyword separator", c.Type)
+	}
+	rest := c.Data[i+1:]
+	switch c.Type {
+	case "tEXt":
+		return string(rest), nil
+	case "zTXt":
+		// Compression method byte, then a zlib stream.
+		if len(rest) < 1 || rest[0] != 0 {
+			return "", errors.New("zTXt chunk uses an unknown compression method")
+		}
+		return inflateText(rest[1:])
+	case "iTXt":
+		// Compression flag, compression method, language tag, translated
+		// keyword, then the UTF-8 text.
+		if len(rest) < 2 {
+			return "", errors.New("iTXt chunk is truncated")
+		}
+		compressed, method := rest[0], rest[1]
+		rest = rest[2:]
+		for range 2 {
+			j := bytes.IndexByte(rest, 0)
+			if j < 0 {
+				return "", errors.New("iTXt chunk is truncated")
 			}
+			rest = rest[j+1:]
+		}
+		if compressed == 0 {
+			return string(rest), nil
+		}
+		if method != 0 {
+			return "", errors.New("iTXt chunk uses an unknown compression method")
+		}
+		return inflateText(rest)
+	}
+	return "", fmt.Errorf("%s is not a text chunk", c.Type)
+}
+
+func inflateText(data []byte) (string, error) {
+	r, err := zlib.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return "", fmt.Errorf("failed to decompress text: %v", err)
+	}
+	defer r.Close()
+	text, err := io.ReadAll(io.LimitReader(r, maxTextSize+1))
+	if err != nil {
+		return "", fmt.Errorf("failed to decompress text: %v", err)
+	}
+	if len(text) > maxTextSize {
+		return "", errors.New("decompressed text exceeds size limit")
+	}
+	return string(text), nil
+}
+
+// pngSetITXt inserts an iTXt chunk with the given key and UTF-8 value after
+// the IHDR chunk, zlib-compressing the value when compress is set. Language
+// tag and translated keyword are left empty.
+func pngSetITXt(data []byte, key, value string, compress bool) ([]byte, error) {
+	payload := append([]byte(key), 0)
+	if compress {
+		var buf bytes.Buffer
+		zw := zlib.NewWriter(&buf)
+		zw.Write([]byte(value))
+		zw.Close()
+		payload = append(payload, 1, 0, 0, 0)
+		payload = append(payload, buf.Bytes()...)
+	} else {
+		payload = append(payload, 0, 0, 0, 0)
+		payload = append(payload, value...)
+	}
+
+	return pngInsertChunk(data, pngChunk{Type: "iTXt", Data: payload})
+}
+
+// pngInsertChunk inserts a chunk directly after IHDR.
+func pngInsertChunk(data []byte, c pngChunk) ([]byte, error) {
+	chunks, err := pngChunks(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(chunks) == 0 || chunks[0].Type != "IHDR" {
+		return nil, errors.New("PNG does not start with IHDR")
+	}
+	out := make([]pngChunk, 0, len(chunks)+1)
+	out = append(out, chunks[0], c)
+	out = append(out, chunks[1:]...)
+	return pngAssemble(out), nil
+}
+
+// pngFindChunk returns the data of the first chunk of the given type.
+func pngFindChunk(data []byte, chunkType string) ([]byte, bool, error) {
+	chunks, err := pngChunks(data)
+	if err != nil {
+		return nil, false, err
+	}
+	for _, c := range chunks {
+		if c.Type == chunkType {
+			return c.Data, true, nil
 		}
+	}
+	return nil, false, nil
+}
+
+// pngChunk is one chunk of a PNG stream. The length and CRC are derived from
+// Type and Data when the stream is reassembled.
+type pngChunk struct {
+	Type string
+	Data []byte
+}
 
+// pngChunks splits a PNG into its chunks without checking CRCs.
+func pngChunks(data []byte) ([]pngChunk, error) {
+	if !pngHasSignature(data) {
+		return nil, errors.New("not a PNG file")
+	}
+	var chunks []pngChunk
+	offset := 8
+	for offset < len(data) {
+		if offset+8 > len(data) {
+			return nil, fmt.Errorf("truncated chunk header at offset %d", offset)
+		}
+		chunkLen := int(binary.BigEndian.Uint32(data[offset : offset+4]))
+		chunkEnd := offset + 8 + chunkLen + 4
+		if chunkEnd > len(data) {
+			return nil, fmt.Errorf("chunk at offset %d extends beyond data", offset)
+		}
+		chunks = append(chunks, pngChunk{Type: string(data[offset+4 : offset+8]), Data: data[offset+8 : offset+8+chunkLen]})
 		offset = chunkEnd
 	}
+	return chunks, nil
+}
+
+// pngAssemble writes the PNG signature followed by the chunks, computing each
+// chunk's length and CRC. Pixel data is copied through unchanged.
+func pngAssemble(chunks []pngChunk) []byte {
+	size := len(pngSignature)
+	for _, c := range chunks {
+		size += 12 + len(c.Data)
+	}
+	out := make([]byte, 0, size)
+	out = append(out, pngSignature...)
+	for _, c := range chunks {
+		out = binary.BigEndian.AppendUint32(out, uint32(len(c.Data)))
+		out = append(out, c.Type...)
+		out = append(out, c.Data...)
+		crc := crc32.NewIEEE()
+		crc.Write([]byte(c.Type))
+		crc.Write(c.Data)
+		out = binary.BigEndian.AppendUint32(out, crc.Sum32())
+	}
+	return out
+}
+
+// isTextChunk reports whether a chunk type holds textual metadata.
+func isTextChunk(chunkType string) bool {
+	return chunkType == "tEXt" || chunkType == "zTXt" || chunkType == "iTXt"
+}
+
+// textChunkKey returns the keyword of a tEXt, zTXt or iTXt chunk.
+func textChunkKey(c pngChunk) string {
+	if i := bytes.IndexByte(c.Data, 0); i >= 0 {
+		return string(c.Data[:i])
+	}
+	return string(c.Data)
+}
+
+// pngRemoveChunks removes every chunk of the given type. Returns the
+// rewritten PNG and the number of chunks removed.
+func pngRemoveChunks(data []byte, chunkType string) ([]byte, int, error) {
+	chunks, err := pngChunks(data)
+	if err != nil {
+		return nil, 0, err
+	}
+	kept := chunks[:0:0]
+	for _, c := range chunks {
+		if c.Type != chunkType {
+			kept = append(kept, c)
+		}
+	}
+	removed := len(chunks) - len(kept)
+	if removed == 0 {
+		return data, 0, nil
+	}
+	return pngAssemble(kept), removed, nil
+}
 
-	return "", errors.New("tEXt chunk not found for key: " + key)
+// pngRemoveText removes the text chunks with the given keyword, or every text
+// chunk when key is empty. Returns the rewritten PNG and the number of chunks
+// removed.
+func pngRemoveText(data []byte, key string) ([]byte, int, error) {
+	chunks, err := pngChunks(data)
+	if err != nil {
+		return nil, 0, err
+	}
+	kept := chunks[:0:0]
+	for _, c := range chunks {
+		if isTextChunk(c.Type) && (key == "" || textChunkKey(c) == key) {
+			continue
+		}
+		kept = append(kept, c)
+	}
+	removed := len(chunks) - len(kept)
+	if removed == 0 {
+		return data, 0, nil
+	}
+	return pngAssemble(kept), removed, nil
 }
@@ -2,11 +2,13 @@ package main
 
 import (
 	"bytes"
+	"compress/zlib"
 	"encoding/binary"
 	"hash/crc32"
 	"image"
 	"image/color"
 	"image/jpeg"
+	"image/png"
 	"strings"
 	"testing"
 )
@@ -122,38 +124,10 @@ func TestPngHasSignature(t *testing.T) {
 	}
 }
 
-func TestPngSetGetRoundTrip(t *testing.T) {
-	png := minimalPNG()
-	modified, err := pngSetText(png, "agentpix", `{"model":"flash"}`)
-	if err != nil {
-		t.Fatalf("pngSetText: %v", err)
-	}
-
-	// Modified should still be valid PNG
-	if !pngHasSignature(modified) {
-		t.Fatal("modified data lost PNG signature")
-	}
-
-	// Should be longer than original
-	if len(modified) <= len(png) {
-		t.Fatalf("modified (%d bytes) should be longer than original (%d bytes)", len(modified), len(png))
-	}
-
-	// Read it back
-	val, err := pngGetText(modified, "agentpix")
-	if err != nil {
-		t.Fatalf("pngGetText: %v", err)
-	}
-	if val != `{"model":"flash"}` {
-		t.Errorf("got %q, want %q", val, `{"model":"flash"}`)
-	}
-}
-
-func TestPngSetTextRejectsNonPNG(t *testing.T) {
-	_, err := pngSetText([]byte("not a png"), "key", "val")
-	if err == nil {
-		t.Fatal("expected error for non-PNG data")
-	}
+// withText inserts a tEXt chunk after IHDR, the Latin-1 text chunk older
+// agentpix versions and other tools write.
+func withText(data []byte, key, value string) ([]byte, error) {
+	return pngInsertChunk(data, pngChunk{Type: "tEXt", Data: []byte(key + "\x00" + value)})
 }
 
 func TestPngGetTextRejectsNonPNG(t *testing.T) {
@@ -165,9 +139,9 @@ func TestPngGetTextRejectsNonPNG(t *testing.T) {
 
 func TestPngGetTextMissingKey(t *testing.T) {
 	png := minimalPNG()
-	modified, err := pngSetText(png, "other", "value")
+	modified, err := withText(png, "other", "value")
 	if err != nil {
-		t.Fatalf("pngSetText: %v", err)
+		t.Fatalf("withText: %v", err)
 	}
 
 	_, err = pngGetText(modified, "agentpix")
@@ -182,13 +156,13 @@ func TestPngGetTextMissingKey(t *testing.T) {
 func TestPngMultipleTextChunks(t *testing.T) {
 	png := minimalPNG()
 	// Insert two different text chunks
-	step1, err := pngSetText(png, "first", "one")
+	step1, err := withText(png, "first", "one")
 	if err != nil {
-		t.Fatalf("first pngSetText: %v", err)
+		t.Fatalf("first withText: %v", err)
 	}
-	step2, err := pngSetText(step1, "second", "two")
+	step2, err := withText(step1, "second", "two")
 	if err != nil {
-		t.Fatalf("second pngSetText: %v", err)
+		t.Fatalf("second withText: %v", err)
 	}
 
 	val1, err := pngGetText(step2, "first")
@@ -207,3 +181,133 @@ func TestPngMultipleTextChunks(t *testing.T) {
 		t.Errorf("second = %q, want %q", val2, "two")
 	}
 }
+
+func TestPngChunksRoundTrip(t *testing.T) {
+	data, err := withText(minimalPNG(), "key", "value")
+	if err != nil {
+		t.Fatal(err)
+	}
+	chunks, err := pngChunks(data)
+	if err != nil {
+		t.Fatalf("pngChunks: %v", err)
+	}
+	var types []string
+	for _, c := range chunks {
+		types = append(types, c.Type)
+	}
+	if got := strings.Join(types, ","); got != "IHDR,tEXt,IDAT,IEND" {
+		t.Errorf("chunk types = %s", got)
+	}
+	if !bytes.Equal(pngAssemble(chunks), data) {
+		t.Error("pngAssemble did not reproduce the original bytes")
+	}
+
+	// Truncated input is rejected rather than silently shortened.
+	if _, err := pngChunks(data[:len(data)-6]); err == nil {
+		t.Error("expected error for truncated PNG")
+	}
+}
+
+func TestPngRemoveText(t *testing.T) {
+	data := minimalPNG()
+	for _, kv := range [][2]string{{"agentpix", "{}"}, {"Comment", "hi"}, {"agentpix", "{}"}} {
+		var err error
+		if data, err = withText(data, kv[0], kv[1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		key     string
+		removed int
+		keep    string
+	}{
+		{"by key", "agentpix", 2, "Comment"},
+		{"all", "", 3, ""},
+		{"absent", "parameters", 0, "agentpix"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, removed, err := pngRemoveText(data, tt.key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if removed != tt.removed {
+				t.Errorf("removed = %d, want %d", removed, tt.removed)
+			}
+			if tt.key != "" {
+				if _, err := pngGetText(out, tt.key); err == nil {
+					t.Errorf("%q still present", tt.key)
+				}
+			}
+			if tt.keep != "" {
+				if _, err := pngGetText(out, tt.keep); err != nil {
+					t.Errorf("%q was removed: %v", tt.keep, err)
+				}
+			}
+			if _, err := png.DecodeConfig(bytes.NewReader(out)); err != nil {
+				t.Errorf("result is not a valid PNG: %v", err)
+			}
+		})
+	}
+}
+
+func TestPngTextChunkKinds(t *testing.T) {
+	value := `{"prompts":[{"text":"ein Hund mit Mütze 🐶"}]}`
+
+	var zbuf bytes.Buffer
+	zw := zlib.NewWriter(&zbuf)
+	zw.Write([]byte(value))
+	zw.Close()
+	ztxt := append([]byte("agentpix\x00\x00"), zbuf.Bytes()...)
+
+	tests := []struct {
+		name  string
+		build func() ([]byte, error)
+		kind  string
+	}{
+		{"legacy tEXt", func() ([]byte, error) { return withText(minimalPNG(), "agentpix", value) }, "tEXt"},
+		{"iTXt", func() ([]byte, error) { return pngSetITXt(minimalPNG(), "agentpix", value, false) }, "iTXt"},
+		{"compressed iTXt", func() ([]byte, error) { return pngSetITXt(minimalPNG(), "agentpix", value, true) }, "iTXt"},
+		{"zTXt", func() ([]byte, error) {
+			chunks, _ := pngChunks(minimalPNG())
+			chunks = append(chunks[:1], append([]pngChunk{{Type: "zTXt", Data: ztxt}}, chunks[1:]...)...)
+			return pngAssemble(chunks), nil
+		}, "zTXt"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := tt.build()
+			if err != nil {
+				t.Fatal(err)
+			}
+			chunks, err := pngChunks(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if chunks[1].Type != tt.kind {
+				t.Errorf("chunk after IHDR is %s, want %s", chunks[1].Type, tt.kind)
+			}
+			got, err := pngGetText(data, "agentpix")
+			if err != nil {
+				t.Fatalf("pngGetText: %v", err)
+			}
+			if got != value {
+				t.Errorf("got %q, want %q", got, value)
+			}
+			if _, err := png.DecodeConfig(bytes.NewReader(data)); err != nil {
+				t.Errorf("result is not a valid PNG: %v", err)
+			}
+		})
+	}
+}
+
+func TestPngGetTextCorruptCompressed(t *testing.T) {
+	chunks, _ := pngChunks(minimalPNG())
+	bad := pngChunk{Type: "iTXt", Data: []byte("agentpix\x00\x01\x00\x00\x00not zlib")}
+	data := pngAssemble(append(chunks[:1], append([]pngChunk{bad}, chunks[1:]...)...))
+	if _, err := pngGetText(data, "agentpix"); err == nil || !strings.Contains(err.Error(), "decompress") {
+		t.Errorf("err = %v, want decompression error", err)
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"google.golang.org/genai"
+)
+
+// imageTokenEstimate is a rough, flat figure for the input tokens one image
+// in history costs on every continuation (Gemini 3 image models at default
+// media resolution). Actual counts vary with model and image size, and
+// usage is recorded per call rather than per image, so savings are only ever
+// reported as an estimate.
+const imageTokenEstimate = 1120
+
+// prunedImagePlaceholder replaces a turn's content when all its parts were
+// images, since the API rejects turns without parts.
+const prunedImagePlaceholder = "[image removed from history]"
+
+type pruneStats struct {
+	Removed    int // image parts dropped
+	KeptSigned int // older image parts kept because they carry thought signatures
+}
+
+// Tokens roughly estimates the input tokens saved per continuation.
+func (s pruneStats) Tokens() int32 {
+	return int32(s.Removed * imageTokenEstimate)
+}
+
+// pruneImages drops image parts from all but the last keep exchanges of
+// history, counted like --turn: an exchange starts at a user prompt. Parts
+// carrying a thought signature are kept: cleanHistoryForResume keeps only
+// signed parts of signed model turns, and the API rejects those turns
+// without them. History is modified in place.
+func pruneImages(history []*genai.Content, keep int) pruneStats {
+	var stats pruneStats
+	start := len(history)
+	for exchanges := 0; start > 0 && exchanges < keep; {
+		start--
+		if c := history[start]; c != nil && c.Role == "user" {
+			exchanges++
+		}
+	}
+	for i := 0; i < start; i++ {
+		c := history[i]
+		if c == nil {
+			continue
+		}
+		var parts []*genai.Part
+		for _, p := range c.Parts {
+			if !isImagePart(p) {
+				parts = append(parts, p)
+				continue
+			}
+			if p.ThoughtSignature != nil {
+				stats.KeptSigned++
+				parts = append(parts, p)
+				continue
+			}
+			stats.Removed++
+		}
+		if len(parts) == 0 {
+			parts = []*genai.Part{{Text: prunedImagePlaceholder}}
+		}
+		c.Parts = parts
+	}
+	return stats
+}
+
+// prunedCopy returns a copy of history with images pruned as by pruneImages,
+// for sending to the API. The contents are copied so history itself keeps
+// every image; parts are shared.
+func prunedCopy(history []*genai.Content, keep int) ([]*genai.Content, pruneStats) {
+	out := make([]*genai.Content, len(history))
+	for i, c := range history {
+		if c != nil {
+			cc := *c
+			out[i] = &cc
+		}
+	}
+	return out, pruneImages(out, keep)
+}
+
+// unprunedHistory returns the history to save after a request sent with the
+// pruned copy sent: the full history followed by the turns the chat added
+// after sent. chatHistory is returned as is when it does not extend sent.
+func unprunedHistory(full, sent, chatHistory []*genai.Content) []*genai.Content {
+	if len(chatHistory) < len(sent) {
+		return chatHistory
+	}
+	out := append([]*genai.Content{}, full...)
+	return append(out, chatHistory[len(sent):]...)
+}
+
+// describePrune summarizes what pruning removed and the roughly estimated
+// savings for the given model.
+func describePrune(stats pruneStats, model string) string {
+	desc := fmt.Sprintf("pruned %d images from history", stats.Removed)
+	if stats.Removed > 0 {
+		desc += fmt.Sprintf(", a rough estimate of ~%s fewer input tokens", formatTokenCount(stats.Tokens()))
+		if def, ok := modelDefs[model]; ok {
+			saved := float64(stats.Tokens()) * currentPrices(def).InputPerMTok / 1_000_000
+			desc += fmt.Sprintf(" (~$%s)", formatCost(saved))
+		}
+		desc += fmt.Sprintf(" per continuation at a flat %s tokens per image", formatTokenCount(imageTokenEstimate))
+	}
+	if stats.KeptSigned > 0 {
+		desc += fmt.Sprintf("; kept %d thought-signed images the model requires", stats.KeptSigned)
+	}
+	return desc
+}
+
+// runSessionPrune drops older images from a session file, or the session
+// embedded in a PNG, in place.
+func runSessionPrune(args []string) error {
+	fs := flag.NewFlagSet("agentpix session prune", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+
+	keep := fs.Int("keep-images", 0, "keep images in the last N exchanges")
+
+	const usage = "usage: agentpix session prune --keep-images N <session-file|image.png>"
+
+	if err := fs.Parse(args); err != nil {
+		return fmt.Errorf("%s", usage)
+	}
+	if fs.NArg() != 1 || *keep < 1 {
+		return fmt.Errorf("%s", usage)
+	}
+	path := fs.Arg(0)
+
+	sess, before, err := readSession(path)
+	if err != nil {
+		return err
+	}
+	stats := pruneImages(sess.History, *keep)
+	if stats.Removed == 0 {
+		fmt.Fprintf(os.Stderr, "%s: nothing to prune\n", path)
+		return nil
+	}
+	if err := rewriteSession(path, *sess); err != nil {
+		return err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("failed to read %q: %v", path, err)
+	}
+	model := sess.Model
+	if pinned, ok := modelAliases[model]; ok {
+		model = pinned
+	}
+	fmt.Fprintf(os.Stderr, "%s (%s -> %s)\n", describePrune(stats, model), formatSize(before), formatSize(info.Size()))
+	return nil
+}
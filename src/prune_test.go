@@ -0,0 +1,158 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"google.golang.org/genai"
+)
+
+// pruneTestHistory has three exchanges. Input images are unsigned; the model
+// images of the first two exchanges are signed as Flash 3.1 returns them.
+func pruneTestHistory() []*genai.Content {
+	img := func(data string) *genai.Blob { return testBlob("image/png", []byte(data)) }
+	return testHistory(
+		testTurn{prompt: "a cat", input: img("ref"), output: img("cat1"), signature: "sig"},
+		testTurn{input: img("ref2"), reply: "blue", output: img("cat2")},
+		testTurn{prompt: "add a hat", input: img("ref3"), output: img("cat3"), signature: "sig"},
+	)
+}
+
+func TestPruneImages(t *testing.T) {
+	tests := []struct {
+		name       string
+		keep       int
+		wantImages int // image parts left in history
+		stats      pruneStats
+	}{
+		{name: "keep last exchange", keep: 1, wantImages: 3, stats: pruneStats{Removed: 3, KeptSigned: 1}},
+		{name: "keep two exchanges", keep: 2, wantImages: 5, stats: pruneStats{Removed: 1, KeptSigned: 1}},
+		{name: "keep everything", keep: 3, wantImages: 6},
+		{name: "keep more than history", keep: 10, wantImages: 6},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			history := pruneTestHistory()
+			stats := pruneImages(history, tt.keep)
+			if stats != tt.stats {
+				t.Errorf("stats = %+v, want %+v", stats, tt.stats)
+			}
+			var images int
+			for _, c := range history {
+				if len(c.Parts) == 0 {
+					t.Errorf("%s turn left without parts", c.Role)
+				}
+				images += countImageParts(c.Parts)
+			}
+			if images != tt.wantImages {
+				t.Errorf("images left = %d, want %d", images, tt.wantImages)
+			}
+		})
+	}
+
+	t.Run("placeholder and signatures", func(t *testing.T) {
+		history := pruneTestHistory()
+		pruneImages(history, 1)
+		if history[2].Parts[0].Text != prunedImagePlaceholder {
+			t.Errorf("image-only turn = %+v, want placeholder", history[2].Parts)
+		}
+		if history[1].Parts[0].InlineData == nil {
+			t.Error("signed model image was dropped")
+		}
+		if cleaned := cleanHistoryForResume(history); len(cleaned[1].Parts) != 1 {
+			t.Error("signed turn lost parts after cleaning")
+		}
+	})
+}
+
+func TestPrunedCopy(t *testing.T) {
+	history := pruneTestHistory()
+	sent, stats := prunedCopy(history, 1)
+	if stats.Removed != 3 {
+		t.Errorf("removed = %d, want 3", stats.Removed)
+	}
+	count := func(h []*genai.Content) int {
+		var n int
+		for _, c := range h {
+			n += countImageParts(c.Parts)
+		}
+		return n
+	}
+	if count(sent) != 3 || count(history) != 6 {
+		t.Errorf("images sent = %d, kept = %d; want 3 and 6", count(sent), count(history))
+	}
+
+	// The saved history is the full one plus the new exchange.
+	reply := testHistory(testTurn{prompt: "now a scarf", reply: "done", output: testBlob("image/png", []byte("cat4"))})
+	chat := append(append([]*genai.Content{}, sent...), reply...)
+	saved := unprunedHistory(history, sent, chat)
+	if len(saved) != len(history)+2 || count(saved) != 7 || saved[len(saved)-1] != reply[1] {
+		t.Errorf("saved %d contents with %d images, want %d with 7", len(saved), count(saved), len(history)+2)
+	}
+}
+
+func TestDescribePrune(t *testing.T) {
+	got := describePrune(pruneStats{Removed: 2, KeptSigned: 1}, testProName)
+	for _, want := range []string{"pruned 2 images", "rough estimate of ~2,240 fewer input tokens", "(~$0.0045)", "flat 1,120 tokens per image", "kept 1 thought-signed"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("describePrune = %q, missing %q", got, want)
+		}
+	}
+	if got := describePrune(pruneStats{}, testProName); got != "pruned 0 images from history" {
+		t.Errorf("describePrune(empty) = %q", got)
+	}
+}
+
+func TestRunSessionPrune(t *testing.T) {
+	dir := t.TempDir()
+	path := writeSessionFile(t, dir, "cat.session.json", sessionData{Model: "flash", History: pruneTestHistory()})
+
+	if err := runSessionPrune([]string{"--keep-images", "1", path}); err != nil {
+		t.Fatal(err)
+	}
+	sess, _, err := readSession(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var images int
+	for _, c := range sess.History {
+		images += countImageParts(c.Parts)
+	}
+	if images != 3 {
+		t.Errorf("images after prune = %d, want 3", images)
+	}
+
+	// A second run has nothing left to drop.
+	if err := runSessionPrune([]string{"--keep-images", "1", path}); err != nil {
+		t.Errorf("second prune: %v", err)
+	}
+	for _, args := range [][]string{{path}, {"--keep-images", "0", path}} {
+		if err := runSessionPrune(args); err == nil || !strings.Contains(err.Error(), "usage") {
+			t.Errorf("runSessionPrune(%v) = %v, want usage", args, err)
+		}
+	}
+
+	// An embedded session is pruned inside its PNG, keeping the parent link.
+	img := writeEmbeddedSessionPNG(t, dir, "cat.png", sessionData{
+		Version: sessionVersion, Model: "flash", History: pruneTestHistory(),
+		Parent: &sessionParent{Path: "base.session.json", SHA256: "abc", Turn: 1},
+	})
+	if err := runSessionPrune([]string{"--keep-images", "1", img}); err != nil {
+		t.Fatal(err)
+	}
+	checkStillPNG(t, img)
+	sess, _, err = readSession(img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	images = 0
+	for _, c := range sess.History {
+		images += countImageParts(c.Parts)
+	}
+	if images != 3 {
+		t.Errorf("embedded images after prune = %d, want 3", images)
+	}
+	if sess.Parent == nil || sess.Parent.Path != "base.session.json" {
+		t.Errorf("parent = %+v, want path relative to the PNG", sess.Parent)
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"google.golang.org/genai"
+)
+
+// redactions are the categories session export can strip, in display order.
+var redactions = []string{"prompts", "inputs", "signatures"}
+
+const redactedText = "[redacted]"
+
+// maxStandInSide bounds stand-in images. Dimensions come from the input's
+// header, which a corrupt or crafted session can set to anything.
+const maxStandInSide = 4096
+
+const exportUsage = "usage: agentpix session export --redact prompts,inputs,signatures -o <output.session.json> [-f] <session-file>"
+
+// runSessionExport writes a sanitized copy of a session for sharing. The copy
+// records what was redacted, and loadSession refuses to continue it.
+func runSessionExport(args []string) error {
+	fs := flag.NewFlagSet("agentpix session export", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+
+	redact := fs.String("redact", "", "comma-separated: prompts, inputs, signatures")
+	output := fs.String("o", "", "output session file")
+	force := fs.Bool("f", false, "overwrite the output file if it exists")
+
+	if err := fs.Parse(args); err != nil {
+		return fmt.Errorf("%s", exportUsage)
+	}
+	if fs.NArg() != 1 || *redact == "" || *output == "" {
+		return fmt.Errorf("%s", exportUsage)
+	}
+	path := fs.Arg(0)
+
+	what, err := parseRedactions(*redact)
+	if err != nil {
+		return err
+	}
+	if !isSessionFile(*output) {
+		return fmt.Errorf("output file must end in %s or %s", sessionSuffix, compressedSessionSuffix)
+	}
+	if _, err := os.Stat(*output); err == nil && !*force {
+		return fmt.Errorf("output file %q already exists (use -f to overwrite)", *output)
+	}
+
+	sess, _, err := readSessionImages(path)
+	if err != nil {
+		return err
+	}
+	if err := redactHistory(sess.History, what); err != nil {
+		return err
+	}
+	for _, r := range redactions {
+		if what[r] && !slices.Contains(sess.Redacted, r) {
+			sess.Redacted = append(sess.Redacted, r)
+		}
+	}
+	// The export is self-contained so it can be shared as one file, and
+	// keeps only the parent's file name rather than a local path.
+	sess.BlobDir = ""
+	if sess.Parent != nil {
+		sess.Parent.Path = filepath.Base(sess.Parent.Path)
+	}
+	if err := writeSession(*output, *sess); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "exported %s (redacted: %s)\n", *output, strings.Join(sess.Redacted, ", "))
+	return nil
+}
+
+func parseRedactions(spec string) (map[string]bool, error) {
+	what := make(map[string]bool)
+	for _, r := range strings.Split(spec, ",") {
+		r = strings.TrimSpace(r)
+		if !slices.Contains(redactions, r) {
+			return nil, fmt.Errorf("unknown redaction %q: use %s", r, strings.Join(redactions, ", "))
+		}
+		what[r] = true
+	}
+	return what, nil
+}
+
+// redactHistory sanitizes history in place. "prompts" replaces every text
+// part, including model replies and thoughts, with a placeholder. "inputs"
+// replaces user-supplied images with solid gray PNGs of the same dimensions.
+// "signatures" removes thought signatures.
+func redactHistory(history []*genai.Content, what map[string]bool) error {
+	for _, c := range history {
+		if c == nil {
+			continue
+		}
+		for _, p := range c.Parts {
+			if p == nil {
+				continue
+			}
+			if what["prompts"] && p.Text != "" {
+				p.Text = redactedText
+			}
+			if what["inputs"] && c.Role == "user" && p.InlineData != nil {
+				standIn, err := standInImage(p.InlineData.Data)
+				if err != nil {
+					return err
+				}
+				p.InlineData = &genai.Blob{MIMEType: "image/png", Data: standIn}
+			}
+			if what["signatures"] {
+				p.ThoughtSignature = nil
+			}
+		}
+	}
+	return nil
+}
+
+// standInImage returns a solid gray PNG with the dimensions of the given
+// image, so the redacted session still shows input sizes and aspect ratios.
+// Images larger than maxStandInSide are scaled down, keeping the ratio.
+func standInImage(data []byte) ([]byte, error) {
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil || cfg.Width <= 0 || cfg.Height <= 0 {
+		// Formats without a decoder here (WebP, HEIC) get a 1x1 stand-in.
+		cfg.Width, cfg.Height = 1, 1
+	}
+	if longest := max(cfg.Width, cfg.Height); longest > maxStandInSide {
+		cfg.Width = max(1, cfg.Width*maxStandInSide/longest)
+		cfg.Height = max(1, cfg.Height*maxStandInSide/longest)
+	}
+	img := image.NewGray(image.Rect(0, 0, cfg.Width, cfg.Height))
+	for i := range img.Pix {
+		img.Pix[i] = 0x80
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, fmt.Errorf("failed to encode stand-in image: %v", err)
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func redactTestSession(t *testing.T, dir string) string {
+	t.Helper()
+	var in bytes.Buffer
+	png.Encode(&in, testImage(30, 20))
+	return writeSessionFile(t, dir, "cat.session.json", sessionData{Model: testFlashName, History: testHistory(testTurn{
+		prompt: "our client's secret product", input: testBlob("image/png", in.Bytes()),
+		thought: "thinking about the product", reply: "here is the secret product",
+		output: testBlob("image/png", minimalPNG()), signature: "sig",
+	})})
+}
+
+func TestRunSessionExport(t *testing.T) {
+	dir := t.TempDir()
+	src := redactTestSession(t, dir)
+	out := filepath.Join(dir, "shared.session.json")
+
+	if err := runSessionExport([]string{"--redact", "prompts,inputs,signatures", "-o", out, src}); err != nil {
+		t.Fatalf("export: %v", err)
+	}
+	raw, _ := os.ReadFile(out)
+	if bytes.Contains(raw, []byte("secret")) || bytes.Contains(raw, []byte("c2ln")) { // "sig" in base64
+		t.Errorf("export leaks redacted content:\n%s", raw)
+	}
+
+	sess, _, err := readSession(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(sess.Redacted, ",") != "prompts,inputs,signatures" {
+		t.Errorf("Redacted = %v", sess.Redacted)
+	}
+	input := sess.History[0].Parts[1].InlineData
+	cfg, err := png.DecodeConfig(bytes.NewReader(input.Data))
+	if err != nil || cfg.Width != 30 || cfg.Height != 20 {
+		t.Errorf("stand-in = %dx%d (%v), want 30x20", cfg.Width, cfg.Height, err)
+	}
+	img, _ := png.Decode(bytes.NewReader(input.Data))
+	if g, ok := img.(*image.Gray); !ok || g.Pix[0] != 0x80 || g.Pix[len(g.Pix)-1] != 0x80 {
+		t.Error("stand-in is not solid gray")
+	}
+	// Output images are kept for reproduction.
+	if !bytes.Equal(sess.History[1].Parts[2].InlineData.Data, minimalPNG()) {
+		t.Error("output image was changed")
+	}
+
+	_, err = loadSession(out, testFlashName)
+	if err == nil || !strings.Contains(err.Error(), "redacted export (prompts, inputs, signatures)") {
+		t.Errorf("loadSession err = %v", err)
+	}
+	err = runSessionMigrate([]string{"-s", out, "-m", "pro", "-o", filepath.Join(dir, "m.png")})
+	if err == nil || !strings.Contains(err.Error(), "redacted export") {
+		t.Errorf("migrate err = %v", err)
+	}
+}
+
+func TestStandInImage(t *testing.T) {
+	header := func(w, h uint32) []byte {
+		ihdr := binary.BigEndian.AppendUint32(binary.BigEndian.AppendUint32(nil, w), h)
+		ihdr = append(ihdr, 8, 0, 0, 0, 0)
+		return pngAssemble([]pngChunk{{Type: "IHDR", Data: ihdr}, {Type: "IEND"}})
+	}
+	tests := []struct {
+		name string
+		data []byte
+		w, h int
+	}{
+		{name: "same size", data: header(30, 20), w: 30, h: 20},
+		{name: "huge header is scaled down", data: header(60000, 30000), w: maxStandInSide, h: maxStandInSide / 2},
+		{name: "undecodable", data: []byte("RIFF....WEBP"), w: 1, h: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := standInImage(tt.data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			cfg, err := png.DecodeConfig(bytes.NewReader(out))
+			if err != nil || cfg.Width != tt.w || cfg.Height != tt.h {
+				t.Errorf("stand-in = %dx%d (%v), want %dx%d", cfg.Width, cfg.Height, err, tt.w, tt.h)
+			}
+		})
+	}
+}
+
+func TestRunSessionExportParentPath(t *testing.T) {
+	dir := t.TempDir()
+	src := writeSessionFile(t, dir, "b.session.json", sessionData{
+		Model:   testFlashName,
+		History: testHistory(testTurn{prompt: "a cat", reply: "done"}),
+		Parent:  &sessionParent{Path: "/home/alice/clients/acme/a.session.json", SHA256: "abc", Turn: 1},
+	})
+	out := filepath.Join(dir, "shared.session.json")
+	if err := runSessionExport([]string{"--redact", "signatures", "-o", out, src}); err != nil {
+		t.Fatal(err)
+	}
+	sess, _, err := readSession(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sess.Parent == nil || sess.Parent.Path != "a.session.json" || sess.Parent.SHA256 != "abc" || sess.Parent.Turn != 1 {
+		t.Errorf("Parent = %+v, want file name only", sess.Parent)
+	}
+}
+
+func TestRunSessionExportPartial(t *testing.T) {
+	dir := t.TempDir()
+	src := redactTestSession(t, dir)
+	out := filepath.Join(dir, "shared.session.json")
+
+	if err := runSessionExport([]string{"--redact", "signatures", "-o", out, src}); err != nil {
+		t.Fatal(err)
+	}
+	sess, _, err := readSession(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sess.History[0].Parts[0].Text != "our client's secret product" {
+		t.Error("prompt redacted without being requested")
+	}
+	if sess.History[1].Parts[2].ThoughtSignature != nil {
+		t.Error("signature kept")
+	}
+
+	tests := []struct {
+		name    string
+		args    []string
+		wantErr string
+	}{
+		{name: "exists", args: []string{"--redact", "prompts", "-o", out, src}, wantErr: "already exists"},
+		{name: "unknown category", args: []string{"--redact", "prompts,faces", "-o", out, "-f", src}, wantErr: `unknown redaction "faces"`},
+		{name: "not a session name", args: []string{"--redact", "prompts", "-o", filepath.Join(dir, "x.json"), src}, wantErr: "must end in"},
+		{name: "missing redact", args: []string{"-o", out, src}, wantErr: "usage"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := runSessionExport(tt.args)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("err = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
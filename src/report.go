@@ -0,0 +1,437 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"html/template"
+	"image"
+	"image/jpeg"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/image/draw"
+	"google.golang.org/genai"
+)
+
+const reportUsage = "usage: agentpix report [-r] [--link <dir>] [-f] -o <report.html> <session-file|image.png|directory>"
+
+// thumbnailSize is the longest side, in pixels, of input image thumbnails.
+const thumbnailSize = 256
+
+type reportImage struct {
+	Src   template.URL // data URI or path relative to the report; empty if not viewable
+	Label string
+}
+
+type reportTurn struct {
+	Number  int
+	Prompts []string
+	Replies []string
+	Inputs  []reportImage
+	Outputs []reportImage
+	Usage   string
+}
+
+type reportSession struct {
+	Name     string
+	Settings []string
+	Cost     string
+	Turns    []reportTurn
+}
+
+type reportPage struct {
+	Title     string
+	Generated string
+	Sessions  []reportSession
+}
+
+func runReport(args []string) error {
+	fs := flag.NewFlagSet("agentpix report", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+
+	output := fs.String("o", "", "output HTML file")
+	recursive := fs.Bool("r", false, "scan directories recursively")
+	link := fs.String("link", "", "write output images to this directory and link them instead of embedding")
+	force := fs.Bool("f", false, "overwrite the report and linked images if they exist")
+
+	if err := fs.Parse(args); err != nil {
+		return fmt.Errorf("%s", reportUsage)
+	}
+	if fs.NArg() != 1 || *output == "" {
+		return fmt.Errorf("%s", reportUsage)
+	}
+	target := fs.Arg(0)
+
+	if _, err := os.Stat(*output); err == nil && !*force {
+		return fmt.Errorf("output file %q already exists (use -f to overwrite)", *output)
+	}
+	if *link != "" {
+		if info, err := os.Stat(*link); err != nil || !info.IsDir() {
+			return fmt.Errorf("link directory %q does not exist", *link)
+		}
+	}
+
+	info, err := os.Stat(target)
+	if err != nil {
+		return fmt.Errorf("cannot access %q: %v", target, err)
+	}
+	paths := []string{target}
+	if info.IsDir() {
+		paths, err = listFiles(target, *recursive, func(name string) bool {
+			return isSessionFile(name) || isPNGPath(name)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	// Generated images are covered by their sessions when those are in the
+	// scan too; images whose session was cleaned get a section of their own.
+	sessions := make(map[string]bool)
+	for _, p := range paths {
+		if isSessionFile(p) {
+			sessions[p] = true
+		}
+	}
+	covered := func(png string) bool {
+		return sessions[sessionPath(png, false)] || sessions[sessionPath(png, true)]
+	}
+
+	rb := &reportBuilder{reportPath: *output, linkDir: *link, force: *force}
+	page := reportPage{Title: filepath.Base(target), Generated: time.Now().UTC().Format(time.RFC3339)}
+	for _, p := range paths {
+		var rs *reportSession
+		var err error
+		switch {
+		case !isPNGPath(p):
+			rs, err = rb.session(p)
+		case hasEmbeddedSession(p):
+			if covered(p) {
+				continue
+			}
+			rs, err = rb.session(p)
+		default:
+			var meta *imageMetadata
+			meta, err = readImageMetadata(p)
+			if err != nil && info.IsDir() {
+				// Not an agentpix image.
+				continue
+			}
+			if err == nil {
+				if meta.ImportedFrom == "" && covered(p) {
+					continue
+				}
+				rs, err = rb.image(p, meta)
+			}
+		}
+		if err != nil {
+			if !info.IsDir() {
+				return err
+			}
+			fmt.Fprintf(os.Stderr, "skip %s: %v\n", p, err)
+			continue
+		}
+		page.Sessions = append(page.Sessions, *rs)
+	}
+	if len(page.Sessions) == 0 {
+		return fmt.Errorf("no session files or agentpix images found in %q", target)
+	}
+
+	var buf bytes.Buffer
+	if err := reportTemplate.Execute(&buf, page); err != nil {
+		return fmt.Errorf("failed to render report: %v", err)
+	}
+	if err := os.WriteFile(*output, buf.Bytes(), outputPerm); err != nil {
+		return fmt.Errorf("failed to write %q: %v", *output, err)
+	}
+	fmt.Fprintf(os.Stderr, "saved %s (%d sessions, %s)\n", *output, len(page.Sessions), formatSize(int64(buf.Len())))
+	return nil
+}
+
+// reportBuilder turns sessions into report sections. Output images are
+// embedded as data URIs unless linkDir is set, in which case they are written
+// there and referenced relative to the report.
+type reportBuilder struct {
+	reportPath string
+	linkDir    string
+	force      bool
+}
+
+func (rb *reportBuilder) session(path string) (*reportSession, error) {
+	sess, _, err := readSessionImages(path)
+	if err != nil {
+		return nil, err
+	}
+
+	rs := &reportSession{Name: path}
+	model := sess.Model
+	if model == "" {
+		model = "legacy"
+	}
+	rs.Settings = append(rs.Settings, "model "+model)
+	if sess.Ratio != "" {
+		rs.Settings = append(rs.Settings, "ratio "+sess.Ratio)
+	}
+	if sess.Size != "" {
+		rs.Settings = append(rs.Settings, "size "+sess.Size)
+	}
+	if sess.Thinking != "" {
+		rs.Settings = append(rs.Settings, "thinking "+sess.Thinking)
+	}
+	if sess.Parent != nil {
+		rs.Settings = append(rs.Settings, fmt.Sprintf("continued from %s after turn %d", sess.Parent.Path, sess.Parent.Turn))
+	}
+	if sess.MigratedFrom != "" {
+		rs.Settings = append(rs.Settings, "migrated from "+sess.MigratedFrom)
+	}
+	rs.Cost = reportCost(analyzeSession(path))
+
+	// Usage entries belong to model turns, aligned from the end as in
+	// session show.
+	usage := sess.UsageHistory
+	usageOffset := countModelTurns(sess.History) - len(usage)
+	var modelIndex int
+
+	base := strings.TrimSuffix(strings.TrimSuffix(filepath.Base(path), ".gz"), sessionSuffix)
+	for _, c := range sess.History {
+		if c == nil {
+			continue
+		}
+		if c.Role == "user" || len(rs.Turns) == 0 {
+			rs.Turns = append(rs.Turns, reportTurn{Number: len(rs.Turns) + 1})
+		}
+		turn := &rs.Turns[len(rs.Turns)-1]
+		for _, p := range c.Parts {
+			switch {
+			case p == nil || p.Thought:
+			case p.InlineData != nil && len(p.InlineData.Data) > 0:
+				if c.Role == "user" {
+					turn.Inputs = append(turn.Inputs, thumbnailImage(p.InlineData))
+					continue
+				}
+				name := fmt.Sprintf("%s-turn%d-%d.png", base, turn.Number, len(turn.Outputs)+1)
+				img, err := rb.outputImage(p.InlineData, name)
+				if err != nil {
+					return nil, err
+				}
+				turn.Outputs = append(turn.Outputs, img)
+			case p.Text != "" && c.Role == "user":
+				turn.Prompts = append(turn.Prompts, p.Text)
+			case p.Text != "":
+				turn.Replies = append(turn.Replies, p.Text)
+			}
+		}
+		if c.Role == "model" {
+			if k := modelIndex - usageOffset; k >= 0 && k < len(usage) {
+				turn.Usage = describeTurnUsage(usage[k], sess.Size)
+			}
+			modelIndex++
+		}
+	}
+	return rs, nil
+}
+
+// hasEmbeddedSession reports whether a PNG carries a session written with
+// --embed-session.
+func hasEmbeddedSession(path string) bool {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return false
+	}
+	_, found, err := pngFindChunk(data, sessionChunkType)
+	return err == nil && found
+}
+
+// image renders a PNG from its metadata record: an image converted by meta
+// import, or a generated image whose session is gone. Turns are rebuilt from
+// the recorded prompts, and the image itself is the output of the last one;
+// earlier outputs and input images are not in the record.
+func (rb *reportBuilder) image(path string, meta *imageMetadata) (*reportSession, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %q: %v", path, err)
+	}
+	rs := &reportSession{Name: path}
+	if meta.ImportedFrom != "" {
+		rs.Settings = []string{"imported from " + foreignToolNames[meta.ImportedFrom], "model " + meta.Model}
+		rs.Cost = "none (not generated through the API)"
+	} else {
+		rs.Settings = []string{"model " + meta.Model}
+		rs.Cost = reportCost(analyzeImage(path))
+	}
+	if meta.Ratio != "" {
+		rs.Settings = append(rs.Settings, "ratio "+meta.Ratio)
+	}
+	if meta.Size != "" {
+		rs.Settings = append(rs.Settings, "size "+meta.Size)
+	}
+	if meta.Thinking != "" {
+		rs.Settings = append(rs.Settings, "thinking "+meta.Thinking)
+	}
+	if meta.Width > 0 && meta.Height > 0 {
+		rs.Settings = append(rs.Settings, fmt.Sprintf("%dx%d pixels", meta.Width, meta.Height))
+	}
+	if meta.Negative != "" {
+		rs.Settings = append(rs.Settings, "negative prompt: "+meta.Negative)
+	}
+	if meta.Session != "" {
+		rs.Settings = append(rs.Settings, "session "+meta.Session)
+	}
+	if meta.MigratedFrom != "" {
+		rs.Settings = append(rs.Settings, "migrated from "+meta.MigratedFrom)
+	}
+
+	// Usage entries belong to model turns, aligned from the end as in
+	// session show.
+	var modelTurns int
+	for _, p := range meta.Prompts {
+		if p.Role == "model" {
+			modelTurns++
+		}
+	}
+	usageOffset := modelTurns - len(meta.Usage)
+	var modelIndex int
+	for _, p := range meta.Prompts {
+		if p.Role == "user" || len(rs.Turns) == 0 {
+			rs.Turns = append(rs.Turns, reportTurn{Number: len(rs.Turns) + 1})
+		}
+		turn := &rs.Turns[len(rs.Turns)-1]
+		if p.Role == "user" {
+			turn.Prompts = append(turn.Prompts, p.Text)
+			continue
+		}
+		turn.Replies = append(turn.Replies, p.Text)
+		if k := modelIndex - usageOffset; k >= 0 && k < len(meta.Usage) {
+			turn.Usage = describeTurnUsage(meta.Usage[k], meta.Size)
+		}
+		modelIndex++
+	}
+	if len(rs.Turns) == 0 {
+		rs.Turns = []reportTurn{{Number: 1}}
+	}
+	turn := &rs.Turns[len(rs.Turns)-1]
+	name := fmt.Sprintf("%s-turn%d-1.png", strings.TrimSuffix(filepath.Base(path), filepath.Ext(path)), turn.Number)
+	img, err := rb.outputImage(&genai.Blob{MIMEType: "image/png", Data: data}, name)
+	if err != nil {
+		return nil, err
+	}
+	turn.Outputs = append(turn.Outputs, img)
+	return rs, nil
+}
+
+// reportCost summarizes a cost estimate in one line.
+func reportCost(cb *costBreakdown, err error) string {
+	if err != nil {
+		return "unavailable"
+	}
+	if _, known := modelDefs[cb.Model]; !known {
+		return "unknown (unrecognized model)"
+	}
+	note := ""
+	if cb.Usage == nil {
+		note = ", images only (no token usage recorded)"
+	}
+	return fmt.Sprintf("~$%s for %d images%s", formatCost(cb.Total), cb.OutputImages, note)
+}
+
+func (rb *reportBuilder) outputImage(blob *genai.Blob, name string) (reportImage, error) {
+	img := reportImage{Label: describeBlob(blob)}
+	if rb.linkDir == "" {
+		img.Src = dataURI(blob.MIMEType, blob.Data)
+		return img, nil
+	}
+	path := filepath.Join(rb.linkDir, name)
+	if err := extractBlob(blob, path, rb.force); err != nil {
+		return img, err
+	}
+	rel := path
+	if absReport, err := filepath.Abs(filepath.Dir(rb.reportPath)); err == nil {
+		if absPath, err := filepath.Abs(path); err == nil {
+			if r, err := filepath.Rel(absReport, absPath); err == nil {
+				rel = r
+			}
+		}
+	}
+	img.Src = template.URL(filepath.ToSlash(rel))
+	return img, nil
+}
+
+// thumbnailImage embeds a scaled-down copy of an input image. Formats that
+// cannot be decoded here (WebP, HEIC) are listed without a preview.
+func thumbnailImage(blob *genai.Blob) reportImage {
+	img := reportImage{Label: describeBlob(blob)}
+	src, _, err := image.Decode(bytes.NewReader(blob.Data))
+	if err != nil {
+		return img
+	}
+	b := src.Bounds()
+	if b.Dx() > thumbnailSize || b.Dy() > thumbnailSize {
+		w, h := thumbnailSize, b.Dy()*thumbnailSize/b.Dx()
+		if b.Dy() > b.Dx() {
+			w, h = b.Dx()*thumbnailSize/b.Dy(), thumbnailSize
+		}
+		dst := image.NewNRGBA(image.Rect(0, 0, max(w, 1), max(h, 1)))
+		draw.CatmullRom.Scale(dst, dst.Bounds(), src, b, draw.Over, nil)
+		src = dst
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, src, &jpeg.Options{Quality: 80}); err != nil {
+		return img
+	}
+	img.Src = dataURI("image/jpeg", buf.Bytes())
+	return img
+}
+
+func dataURI(mime string, data []byte) template.URL {
+	return template.URL("data:" + mime + ";base64," + base64.StdEncoding.EncodeToString(data))
+}
+
+var reportTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>agentpix report: {{.Title}}</title>
+<style>
+body { font-family: system-ui, sans-serif; margin: 2rem auto; max-width: 960px; padding: 0 1rem; color: #222; }
+h1 { font-size: 1.4rem; }
+h2 { font-size: 1.1rem; margin-top: 2.5rem; border-bottom: 1px solid #ccc; padding-bottom: .3rem; word-break: break-all; }
+.meta { color: #666; font-size: .9rem; }
+.turn { margin: 1.2rem 0; padding: .8rem 1rem; background: #f7f7f7; border-radius: 6px; }
+.turn h3 { font-size: .95rem; margin: 0 0 .5rem; }
+.prompt, .reply { white-space: pre-wrap; margin: .4rem 0; }
+.prompt::before { content: "prompt: "; font-weight: 600; }
+.reply::before { content: "reply: "; font-weight: 600; }
+figure { display: inline-block; margin: .4rem .6rem .4rem 0; vertical-align: top; }
+figure.input img { max-width: 128px; max-height: 128px; }
+figure.output img { max-width: 100%; }
+figcaption { font-size: .8rem; color: #666; }
+</style>
+</head>
+<body>
+<h1>agentpix report: {{.Title}}</h1>
+<p class="meta">generated {{.Generated}}</p>
+{{range .Sessions}}
+<section>
+<h2>{{.Name}}</h2>
+<p class="meta">{{range $i, $s := .Settings}}{{if $i}} · {{end}}{{$s}}{{end}}</p>
+<p class="meta">estimated cost: {{.Cost}}</p>
+{{range .Turns}}
+<div class="turn">
+<h3>Turn {{.Number}}</h3>
+{{range .Prompts}}<p class="prompt">{{.}}</p>{{end}}
+{{range .Inputs}}<figure class="input">{{if .Src}}<img src="{{.Src}}" alt="input image">{{end}}<figcaption>input: {{.Label}}</figcaption></figure>{{end}}
+{{range .Replies}}<p class="reply">{{.}}</p>{{end}}
+{{range .Outputs}}<figure class="output"><img src="{{.Src}}" alt="output image"><figcaption>output: {{.Label}}</figcaption></figure>{{end}}
+{{if .Usage}}<p class="meta">{{.Usage}}</p>{{end}}
+</div>
+{{end}}
+</section>
+{{end}}
+</body>
+</html>
+`))
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"bytes"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"google.golang.org/genai"
+)
+
+func writeReportSession(t *testing.T, dir string) string {
+	t.Helper()
+	var in, out bytes.Buffer
+	jpeg.Encode(&in, testImage(600, 300), nil)
+	png.Encode(&out, testImage(4, 4))
+	return writeSessionFile(t, dir, "cat.session.json", sessionData{
+		Model: testFlashName, Ratio: "16:9", Size: "2K",
+		History: testHistory(testTurn{
+			prompt: "a cat <b>bold</b>", input: testBlob("image/jpeg", in.Bytes()),
+			thought: "secret reasoning", reply: "here is your cat", output: testBlob("image/png", out.Bytes()),
+		}),
+		UsageHistory: []usageData{{PromptTokens: 1200, CandidateTokens: 1300, Images: 1, Timestamp: "2026-03-01T10:00:00Z"}},
+	})
+}
+
+func TestRunReport(t *testing.T) {
+	dir := t.TempDir()
+	writeReportSession(t, dir)
+	report := filepath.Join(dir, "report.html")
+
+	if err := runReport([]string{"-o", report, dir}); err != nil {
+		t.Fatalf("runReport: %v", err)
+	}
+	raw, err := os.ReadFile(report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	html := string(raw)
+	for _, want := range []string{
+		"Turn 1",
+		"a cat &lt;b&gt;bold&lt;/b&gt;",
+		"here is your cat",
+		`src="data:image/jpeg;base64,`,
+		`src="data:image/png;base64,`,
+		"model " + testFlashName,
+		"ratio 16:9",
+		"estimated cost: ~$",
+		"1,200 input / 1,300 output tokens",
+	} {
+		if !strings.Contains(html, want) {
+			t.Errorf("report missing %q", want)
+		}
+	}
+	for _, unwanted := range []string{"secret reasoning", "<script", "<link", `src="http`} {
+		if strings.Contains(html, unwanted) {
+			t.Errorf("report contains %q", unwanted)
+		}
+	}
+
+	if err := runReport([]string{"-o", report, dir}); err == nil || !strings.Contains(err.Error(), "already exists") {
+		t.Errorf("existing report err = %v", err)
+	}
+}
+
+func TestRunReportLinked(t *testing.T) {
+	dir := t.TempDir()
+	sess := writeReportSession(t, dir)
+	images := filepath.Join(dir, "images")
+	os.Mkdir(images, 0755)
+	report := filepath.Join(dir, "report.html")
+
+	if err := runReport([]string{"--link", images, "-o", report, sess}); err != nil {
+		t.Fatalf("runReport: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(images, "cat-turn1-1.png")); err != nil {
+		t.Fatalf("linked image not written: %v", err)
+	}
+	raw, _ := os.ReadFile(report)
+	if !strings.Contains(string(raw), `src="images/cat-turn1-1.png"`) {
+		t.Errorf("report does not link the output image:\n%s", raw)
+	}
+	if strings.Contains(string(raw), "data:image/png") {
+		t.Error("linked report still embeds the output image")
+	}
+}
+
+func TestThumbnailImage(t *testing.T) {
+	var buf bytes.Buffer
+	png.Encode(&buf, testImage(300, 600))
+	img := thumbnailImage(&genai.Blob{MIMEType: "image/png", Data: buf.Bytes()})
+	data := strings.TrimPrefix(string(img.Src), "data:image/jpeg;base64,")
+	if data == string(img.Src) {
+		t.Fatalf("Src = %.40q, want JPEG data URI", img.Src)
+	}
+	if img.Label != "300x600 png ("+formatSize(int64(buf.Len()))+")" {
+		t.Errorf("Label = %q", img.Label)
+	}
+
+	webp := thumbnailImage(&genai.Blob{MIMEType: "image/webp", Data: []byte("not decodable")})
+	if webp.Src != "" || !strings.Contains(webp.Label, "image/webp") {
+		t.Errorf("undecodable image = %+v", webp)
+	}
+}
+
+func TestRunReportImported(t *testing.T) {
+	dir := t.TempDir()
+	writeReportSession(t, dir)
+	fox := writeForeignPNG(t, dir, "fox.png", map[string]string{"parameters": testA1111Parameters})
+	if err := runMetaImport([]string{fox}); err != nil {
+		t.Fatal(err)
+	}
+	// A generated image is covered by its session and not listed again.
+	writeMetaPNG(t, dir, "cat.png", testEditMetadata())
+	report := filepath.Join(dir, "report.html")
+
+	if err := runReport([]string{"-o", report, dir}); err != nil {
+		t.Fatalf("runReport: %v", err)
+	}
+	raw, err := os.ReadFile(report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	html := string(raw)
+	for _, want := range []string{
+		"here is your cat",
+		fox,
+		"imported from Automatic1111",
+		"model juggernautXL_v9",
+		"negative prompt: blurry, lowres",
+		"red fox in snow",
+		"estimated cost: none",
+	} {
+		if !strings.Contains(html, want) {
+			t.Errorf("report missing %q", want)
+		}
+	}
+	if strings.Contains(html, filepath.Join(dir, "cat.png")) {
+		t.Error("report lists a generated image as its own section")
+	}
+
+	// A single imported image can be reported on its own.
+	if err := runReport([]string{"-f", "-o", report, fox}); err != nil {
+		t.Errorf("runReport(image): %v", err)
+	}
+}
+
+func TestRunReportOrphanImages(t *testing.T) {
+	dir := t.TempDir()
+	// The session of dog.png was cleaned; its embedded copy stands in.
+	writeEmbeddedSessionPNG(t, dir, "dog.png", sessionData{
+		Version: sessionVersion, Model: testFlashName,
+		History: testHistory(testTurn{prompt: "a dog on a sofa", reply: "here is your dog", output: testBlob("image/png", minimalPNG())}),
+	})
+	// fox.png has only its metadata record left.
+	meta := testEditMetadata()
+	meta.Prompts = []promptEntry{{Role: "user", Text: "a fox"}, {Role: "model", Text: "here is your fox"}, {Role: "user", Text: "in the snow"}}
+	meta.Usage = []usageData{{PromptTokens: 900, CandidateTokens: 1300, Images: 1}}
+	writeMetaPNG(t, dir, "fox.png", meta)
+	// Plain PNGs are not agentpix images.
+	os.WriteFile(filepath.Join(dir, "plain.png"), minimalPNG(), 0644)
+	report := filepath.Join(dir, "report.html")
+
+	if err := runReport([]string{"-o", report, dir}); err != nil {
+		t.Fatalf("runReport: %v", err)
+	}
+	raw, err := os.ReadFile(report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	html := string(raw)
+	for _, want := range []string{
+		filepath.Join(dir, "dog.png"),
+		"a dog on a sofa",
+		"here is your dog",
+		filepath.Join(dir, "fox.png"),
+		"Turn 2",
+		"in the snow",
+		"here is your fox",
+		"session a.session.json",
+		"estimated cost: ~$",
+		"900 input / 1,300 output tokens",
+	} {
+		if !strings.Contains(html, want) {
+			t.Errorf("report missing %q", want)
+		}
+	}
+	if strings.Contains(html, filepath.Join(dir, "plain.png")) {
+		t.Error("report lists a PNG without agentpix metadata")
+	}
+
+	// Once the session is back in the directory, it covers the image.
+	writeSessionFile(t, dir, "dog.session.json", sessionData{
+		Version: sessionVersion, Model: testFlashName,
+		History: testHistory(testTurn{prompt: "a dog on a sofa", reply: "here is your dog", output: testBlob("image/png", minimalPNG())}),
+	})
+	if err := runReport([]string{"-f", "-o", report, dir}); err != nil {
+		t.Fatalf("runReport: %v", err)
+	}
+	raw, _ = os.ReadFile(report)
+	if strings.Contains(string(raw), filepath.Join(dir, "dog.png")) {
+		t.Error("report lists an image whose session is in the directory")
+	}
+}
+
+func TestRunReportErrors(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name    string
+		args    []string
+		wantErr string
+	}{
+		{name: "missing output", args: []string{dir}, wantErr: "usage:"},
+		{name: "empty directory", args: []string{"-o", filepath.Join(dir, "r.html"), dir}, wantErr: "no session files"},
+		{name: "missing link directory", args: []string{"--link", filepath.Join(dir, "nope"), "-o", filepath.Join(dir, "r.html"), dir}, wantErr: "does not exist"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := runReport(tt.args)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("err = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
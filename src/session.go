@@ -1,34 +1,166 @@
 package main
 
 import (
+	"bytes"
+	"compress/gzip"
 	"encoding/json"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"google.golang.org/genai"
 )
 
 const sessionSuffix = ".session.json"
+const compressedSessionSuffix = sessionSuffix + ".gz"
 
+// sessionVersion is the session file format written by this build. Files
+// without a version field are version 1.
+const sessionVersion = 2
+
+// sessionUpgrades[v] upgrades a session from version v to v+1 in memory.
+// Every format change adds an entry and bumps sessionVersion.
+var sessionUpgrades = map[int]func(*sessionData){
+	// Version 2 records usage per turn; version 1 held one usage block for
+	// the last call only, which becomes a one-entry usage history.
+	1: func(sess *sessionData) {
+		if sess.Usage != nil && len(sess.UsageHistory) == 0 {
+			sess.UsageHistory = []usageData{*sess.Usage}
+		}
+		sess.Usage = nil
+	},
+}
+
+// usageData records token counts for one API call, or a sum across calls.
+// Images, Size and Timestamp are set on per-turn entries only.
 type usageData struct {
-	PromptTokens    int32 `json:"prompt_tokens"`
-	CandidateTokens int32 `json:"candidate_tokens"`
-	TotalTokens     int32 `json:"total_tokens"`
+	PromptTokens    int32  `json:"prompt_tokens"`
+	CandidateTokens int32  `json:"candidate_tokens"`
+	ThoughtsTokens  int32  `json:"thoughts_tokens,omitempty"`
+	CachedTokens    int32  `json:"cached_tokens,omitempty"`
+	TotalTokens     int32  `json:"total_tokens"`
+	Images          int    `json:"images,omitempty"`
+	Size            string `json:"size,omitempty"`
+	Timestamp       string `json:"timestamp,omitempty"`
+	Model           string `json:"model,omitempty"` // set when the turn used another model than the session's (session migrate)
 }
 
 type sessionData struct {
-	Model    string           `json:"model"`
-	Ratio    string           `json:"ratio,omitempty"`
-	Size     string           `json:"size,omitempty"`
-	Thinking string           `json:"thinking,omitempty"`
-	History  []*genai.Content `json:"history"`
-	Usage    *usageData       `json:"usage,omitempty"`
+	Version      int              `json:"version"`
+	Model        string           `json:"model"`
+	Ratio        string           `json:"ratio,omitempty"`
+	Size         string           `json:"size,omitempty"`
+	Thinking     string           `json:"thinking,omitempty"`
+	History      []*genai.Content `json:"history"`
+	UsageHistory []usageData      `json:"usage_history,omitempty"`
+	Usage        *usageData       `json:"usage,omitempty"`         // version 1: last call only
+	BlobDir      string           `json:"blob_dir,omitempty"`      // external-blob format: where images live
+	Parent       *sessionParent   `json:"parent,omitempty"`        // session this one was continued from
+	MigratedFrom string           `json:"migrated_from,omitempty"` // source model when created by session migrate
+	Redacted     []string         `json:"redacted,omitempty"`      // categories removed by session export
+
+	upgradedFrom int // format version on disk when older than sessionVersion
+}
+
+// sessionParent identifies the session a continuation branched from. Path is
+// relative to the session file; SHA256 is the hash of the parent file as it
+// was read, so later changes to the parent can be detected. Turn is the
+// number of exchanges in the parent history that the branch continued after,
+// which is less than the parent's length when it was rewound with --turn.
+type sessionParent struct {
+	Path   string `json:"path"`
+	SHA256 string `json:"sha256"`
+	Turn   int    `json:"turn"`
+}
+
+// newUsageEntry builds the per-turn usage record for one API call.
+func newUsageEntry(md *genai.GenerateContentResponseUsageMetadata, images int, size string, now time.Time) usageData {
+	return usageData{
+		PromptTokens:    md.PromptTokenCount,
+		CandidateTokens: md.CandidatesTokenCount,
+		ThoughtsTokens:  md.ThoughtsTokenCount,
+		CachedTokens:    md.CachedContentTokenCount,
+		TotalTokens:     md.TotalTokenCount,
+		Images:          images,
+		Size:            size,
+		Timestamp:       now.UTC().Format(time.RFC3339),
+	}
+}
+
+// sessionUsage returns the per-turn usage records of a session. Legacy
+// sessions hold a single usage block covering only the last API call; it is
+// returned as a one-entry history so callers handle both formats alike.
+func sessionUsage(sess *sessionData) []usageData {
+	if len(sess.UsageHistory) > 0 {
+		return sess.UsageHistory
+	}
+	if sess.Usage != nil {
+		return []usageData{*sess.Usage}
+	}
+	return nil
+}
+
+// sumUsage totals token counts across usage entries. Returns nil when there
+// are no entries so callers can distinguish "no data" from zero usage.
+func sumUsage(entries []usageData) *usageData {
+	if len(entries) == 0 {
+		return nil
+	}
+	var sum usageData
+	for _, u := range entries {
+		sum.PromptTokens += u.PromptTokens
+		sum.CandidateTokens += u.CandidateTokens
+		sum.ThoughtsTokens += u.ThoughtsTokens
+		sum.CachedTokens += u.CachedTokens
+		sum.TotalTokens += u.TotalTokens
+		sum.Images += u.Images
+	}
+	return &sum
+}
+
+// countImageParts returns the number of parts carrying an image, inline or as
+// a reference to an external blob.
+func countImageParts(parts []*genai.Part) int {
+	var n int
+	for _, p := range parts {
+		if isImagePart(p) {
+			n++
+		}
+	}
+	return n
+}
+
+// isImagePart reports whether p carries inline image data or refers to an
+// image in the session's blob directory.
+func isImagePart(p *genai.Part) bool {
+	if p == nil {
+		return false
+	}
+	if p.InlineData != nil {
+		return len(p.InlineData.Data) > 0
+	}
+	return p.FileData != nil && strings.HasPrefix(p.FileData.FileURI, blobURIPrefix)
+}
+
+// countModelTurns returns the number of model contents in history.
+func countModelTurns(history []*genai.Content) int {
+	var n int
+	for _, c := range history {
+		if c != nil && c.Role == "model" {
+			n++
+		}
+	}
+	return n
 }
 
 // readSession parses a session file and returns the session data and file size.
-// It validates that history is present but does not check model names.
+// It validates that history is present but does not check model names. Images
+// of external-blob sessions are left as references, so sessions whose blob
+// directory is gone can still be priced, listed, and cleaned; commands that
+// need the image bytes use readSessionImages.
 func readSession(path string) (*sessionData, int64, error) {
 	info, err := os.Stat(path)
 	if err != nil {
@@ -38,6 +170,30 @@ func readSession(path string) (*sessionData, int64, error) {
 	if err != nil {
 		return nil, 0, fmt.Errorf("failed to read %q: %v", path, err)
 	}
+	// PNGs generated with --embed-session carry the session in a private
+	// chunk, stored gzip-compressed like a .session.json.gz file.
+	if pngHasSignature(raw) {
+		embedded, found, err := pngFindChunk(raw, sessionChunkType)
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to read %q: %v", path, err)
+		}
+		if !found {
+			return nil, 0, fmt.Errorf("%q has no embedded session (generate it with --embed-session)", path)
+		}
+		raw = embedded
+	}
+	// Compressed sessions are recognized by the gzip magic number rather than
+	// the file name, so renamed files still load.
+	if len(raw) >= 2 && raw[0] == 0x1f && raw[1] == 0x8b {
+		zr, err := gzip.NewReader(bytes.NewReader(raw))
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to decompress %q: %v", path, err)
+		}
+		raw, err = io.ReadAll(zr)
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to decompress %q: %v", path, err)
+		}
+	}
 	var sess sessionData
 	if err := json.Unmarshal(raw, &sess); err != nil {
 		return nil, 0, fmt.Errorf("failed to parse %q: %v", path, err)
@@ -45,28 +201,203 @@ func readSession(path string) (*sessionData, int64, error) {
 	if sess.History == nil {
 		return nil, 0, fmt.Errorf("%q is not an agentpix session", path)
 	}
+	if err := upgradeSession(&sess); err != nil {
+		return nil, 0, fmt.Errorf("cannot load %q: %v", path, err)
+	}
 	return &sess, info.Size(), nil
 }
 
-// listSessionFiles returns paths to all .session.json files in a directory (non-recursive).
-func listSessionFiles(dir string) ([]string, error) {
-	entries, err := os.ReadDir(dir)
+// readSessionImages is readSession with the images of an external-blob
+// session read back from its blob directory and verified.
+func readSessionImages(path string) (*sessionData, int64, error) {
+	sess, size, err := readSession(path)
 	if err != nil {
-		return nil, fmt.Errorf("cannot read directory: %v", err)
+		return nil, 0, err
+	}
+	if err := loadSessionImages(path, sess); err != nil {
+		return nil, 0, err
+	}
+	return sess, size, nil
+}
+
+// loadSessionImages replaces the blob references in a session read from path
+// with the image data they name.
+func loadSessionImages(path string, sess *sessionData) error {
+	if sess.BlobDir == "" {
+		return nil
+	}
+	if err := rehydrateHistory(sess.History, resolveSessionRelative(path, sess.BlobDir)); err != nil {
+		return fmt.Errorf("failed to load %q: %v", path, err)
+	}
+	return nil
+}
+
+// upgradeSession brings a session read from disk to the current format.
+func upgradeSession(sess *sessionData) error {
+	if sess.Version == 0 {
+		sess.Version = 1
+	}
+	if sess.Version > sessionVersion {
+		return fmt.Errorf("session format version %d is newer than this build supports (%d); update agentpix", sess.Version, sessionVersion)
+	}
+	if sess.Version < sessionVersion {
+		sess.upgradedFrom = sess.Version
+	}
+	for sess.Version < sessionVersion {
+		sessionUpgrades[sess.Version](sess)
+		sess.Version++
+	}
+	return nil
+}
+
+// writeSession serializes a session to path in the current format,
+// gzip-compressed when path ends in .session.json.gz. When BlobDir is set,
+// images are written to that directory (relative to the session file) and the
+// JSON holds references to them instead of inline data.
+func writeSession(path string, sess sessionData) error {
+	sess.Version = sessionVersion
+	if sess.BlobDir != "" {
+		dir := resolveSessionRelative(path, sess.BlobDir)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("failed to create blob directory: %v", err)
+		}
+		history, err := externalizeHistory(sess.History, dir)
+		if err != nil {
+			return err
+		}
+		sess.History = history
+	}
+	data, err := json.Marshal(sess)
+	if err != nil {
+		return fmt.Errorf("failed to serialize session: %v", err)
+	}
+	if strings.HasSuffix(path, compressedSessionSuffix) {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		zw.Write(data)
+		if err := zw.Close(); err != nil {
+			return fmt.Errorf("failed to compress session: %v", err)
+		}
+		data = buf.Bytes()
+	}
+	if err := os.WriteFile(path, data, outputPerm); err != nil {
+		return fmt.Errorf("failed to write session: %v", err)
+	}
+	return nil
+}
+
+// sessionChunkType is the private, ancillary PNG chunk that holds an
+// embedded session.
+const sessionChunkType = "agSs"
+
+// embedSession stores sess, gzip-compressed, in a private chunk of the PNG
+// that will be written to pngPath. Images are kept inline so the PNG is all
+// that is needed to continue, and the parent path is made relative to the PNG.
+func embedSession(data []byte, pngPath string, sess sessionData) ([]byte, error) {
+	sess.Version = sessionVersion
+	sess.BlobDir = ""
+	if sess.Parent != nil {
+		parent := *sess.Parent
+		parent.Path = sessionRelative(pngPath, parent.Path)
+		sess.Parent = &parent
+	}
+	raw, err := json.Marshal(sess)
+	if err != nil {
+		return nil, fmt.Errorf("failed to serialize session: %v", err)
+	}
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write(raw)
+	if err := zw.Close(); err != nil {
+		return nil, fmt.Errorf("failed to compress session: %v", err)
+	}
+	return pngInsertChunk(data, pngChunk{Type: sessionChunkType, Data: buf.Bytes()})
+}
+
+// rewriteSession writes sess back to the file it was read from. For a PNG
+// generated with --embed-session the embedded session is replaced and the
+// image kept; writeSession would overwrite the image with session JSON.
+func rewriteSession(path string, sess sessionData) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read %q: %v", path, err)
+	}
+	if !pngHasSignature(data) {
+		return writeSession(path, sess)
+	}
+	data, _, err = pngRemoveChunks(data, sessionChunkType)
+	if err != nil {
+		return fmt.Errorf("failed to read %q: %v", path, err)
+	}
+	// The stored parent path is relative to the PNG; embedSession expects
+	// one that resolves from the working directory.
+	if sess.Parent != nil {
+		parent := *sess.Parent
+		parent.Path = resolveSessionRelative(path, parent.Path)
+		sess.Parent = &parent
+	}
+	data, err = embedSession(data, path, sess)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, data)
+}
+
+// listSessionFiles returns paths to all .session.json and .session.json.gz files in a directory (non-recursive).
+func listSessionFiles(dir string) ([]string, error) {
+	return listFiles(dir, false, isSessionFile)
+}
+
+func isSessionFile(name string) bool {
+	return strings.HasSuffix(name, sessionSuffix) || strings.HasSuffix(name, compressedSessionSuffix)
+}
+
+// listFiles returns paths to files in dir whose names satisfy match, descending
+// into subdirectories when recursive is set. Unreadable subdirectories are
+// reported and skipped.
+func listFiles(dir string, recursive bool, match func(name string) bool) ([]string, error) {
+	if !recursive {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read directory: %v", err)
+		}
+		var paths []string
+		for _, d := range entries {
+			if d.IsDir() || !match(d.Name()) {
+				continue
+			}
+			paths = append(paths, filepath.Join(dir, d.Name()))
+		}
+		return paths, nil
 	}
+
 	var paths []string
-	for _, d := range entries {
-		if d.IsDir() || !strings.HasSuffix(d.Name(), sessionSuffix) {
-			continue
+	err := filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			if path == dir {
+				return err
+			}
+			fmt.Fprintf(os.Stderr, "skip %s: %v\n", path, err)
+			return nil
+		}
+		if !d.IsDir() && match(d.Name()) {
+			paths = append(paths, path)
 		}
-		paths = append(paths, filepath.Join(dir, d.Name()))
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("cannot read directory: %v", err)
 	}
 	return paths, nil
 }
 
-// sessionPath converts an output image path to the corresponding session file path.
-func sessionPath(outputPath string) string {
+// sessionPath converts an output image path to the corresponding session file
+// path, with the .gz suffix when the session is compressed.
+func sessionPath(outputPath string, compressed bool) string {
 	ext := filepath.Ext(outputPath)
+	if compressed {
+		return strings.TrimSuffix(outputPath, ext) + compressedSessionSuffix
+	}
 	return strings.TrimSuffix(outputPath, ext) + sessionSuffix
 }
 
@@ -104,20 +435,60 @@ func cleanHistoryForResume(history []*genai.Content) []*genai.Content {
 	return history
 }
 
+// rewindHistory truncates history to its first n user/model exchanges. The
+// kept history must end in a model turn so the next prompt follows a reply.
+func rewindHistory(history []*genai.Content, n int) ([]*genai.Content, error) {
+	var exchanges int
+	for i, c := range history {
+		if c == nil || c.Role != "user" {
+			continue
+		}
+		if exchanges == n {
+			history = history[:i]
+			break
+		}
+		exchanges++
+	}
+	if exchanges < n {
+		return nil, fmt.Errorf("--turn %d is past the end of the session (%d turns)", n, exchanges)
+	}
+	if last := history[len(history)-1]; last == nil || last.Role != "model" {
+		return nil, fmt.Errorf("turn %d of the session has no model reply to continue from", n)
+	}
+	return history, nil
+}
+
+// rewindUsage keeps the usage entries of the first n model turns. Entries are
+// aligned from the end of the history, since sessions that predate usage
+// tracking have fewer entries than turns.
+func rewindUsage(usage []usageData, modelTurns, n int) []usageData {
+	keep := n - (modelTurns - len(usage))
+	if keep <= 0 {
+		return nil
+	}
+	if keep > len(usage) {
+		keep = len(usage)
+	}
+	return usage[:keep]
+}
+
 // loadSession reads a session file for continuation, validating that its model
 // matches the requested model. Returns the session data with cleaned history.
 func loadSession(path, model string) (*sessionData, error) {
-	sess, _, err := readSession(path)
+	sess, _, err := readSessionImages(path)
 	if err != nil {
 		return nil, err
 	}
+	if len(sess.Redacted) > 0 {
+		return nil, fmt.Errorf("%q is a redacted export (%s) and cannot be continued", path, strings.Join(sess.Redacted, ", "))
+	}
 	if sess.Model != "" && sess.Model != model {
 		// Legacy sessions stored bare aliases ("flash", "pro"); allow if same family
 		if target, isAlias := modelAliases[sess.Model]; isAlias && modelDefs[target].Family == modelDefs[model].Family {
 			sess.History = cleanHistoryForResume(sess.History)
 			return sess, nil
 		}
-		return nil, fmt.Errorf("session was created with %q but -m is %q; pass -m %s to continue this session", sess.Model, model, sess.Model)
+		return nil, fmt.Errorf("session was created with %q but -m is %q; pass -m %s to continue this session, or switch models with agentpix session migrate", sess.Model, model, sess.Model)
 	}
 	sess.History = cleanHistoryForResume(sess.History)
 	return sess, nil
@@ -0,0 +1,369 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"image"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"google.golang.org/genai"
+)
+
+const sessionUsageText = `usage: agentpix session <command> [args]
+
+commands:
+  show [-x <dir>] [-f] <session-file>            list each turn; -x extracts embedded images as numbered PNGs
+  convert (--external <dir> | --inline) <file>   move session images to external files or back inline
+  migrate -s <file> -m <model> -o <out.png>      start a session for another model from an existing one
+  upgrade [-r] <file|directory>                  rewrite session files in the current format (keeps .bak)
+  prune --keep-images N <file>                   drop images from all but the last N exchanges
+  export --redact <list> -o <out> <file>         write a sanitized copy (prompts, inputs, signatures)`
+
+func runSession(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("%s", sessionUsageText)
+	}
+	switch args[0] {
+	case "show":
+		return runSessionShow(args[1:])
+	case "convert":
+		return runSessionConvert(args[1:])
+	case "migrate":
+		return runSessionMigrate(args[1:])
+	case "upgrade":
+		return runSessionUpgrade(args[1:])
+	case "prune":
+		return runSessionPrune(args[1:])
+	case "export":
+		return runSessionExport(args[1:])
+	}
+	return fmt.Errorf("unknown session command %q\n%s", args[0], sessionUsageText)
+}
+
+func runSessionShow(args []string) error {
+	fs := flag.NewFlagSet("agentpix session show", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+
+	extract := fs.String("x", "", "directory to extract embedded images into")
+	force := fs.Bool("f", false, "overwrite existing extracted images")
+
+	const usage = "usage: agentpix session show [-x <dir>] [-f] <session-file>"
+
+	if err := fs.Parse(args); err != nil {
+		return fmt.Errorf("%s", usage)
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("%s", usage)
+	}
+	path := fs.Arg(0)
+
+	if *extract != "" {
+		if info, err := os.Stat(*extract); err != nil || !info.IsDir() {
+			return fmt.Errorf("extract directory %q does not exist", *extract)
+		}
+	}
+
+	sess, size, err := readSession(path)
+	if err != nil {
+		return err
+	}
+	// Only -x needs every image; otherwise a session whose blob directory is
+	// gone is still listed, with the missing images shown by reference.
+	if err := loadSessionImages(path, sess); err != nil {
+		if *extract != "" {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "note: %v; images not found are listed by name\n", err)
+	}
+
+	fmt.Printf("session:  %s (%s)\n", filepath.Base(path), formatSize(size))
+	model := sess.Model
+	if model == "" {
+		model = "legacy"
+	}
+	fmt.Printf("model:    %s\n", model)
+	if sess.Ratio != "" {
+		fmt.Printf("ratio:    %s\n", sess.Ratio)
+	}
+	if sess.Size != "" {
+		fmt.Printf("size:     %s\n", sess.Size)
+	}
+	if sess.Thinking != "" {
+		fmt.Printf("thinking: %s\n", sess.Thinking)
+	}
+	if sess.Parent != nil {
+		fmt.Printf("parent:   %s (after turn %d)\n", sess.Parent.Path, sess.Parent.Turn)
+	}
+	if sess.upgradedFrom != 0 {
+		fmt.Printf("format:   version %d (agentpix session upgrade rewrites it as version %d)\n", sess.upgradedFrom, sessionVersion)
+	}
+	if sess.MigratedFrom != "" {
+		fmt.Printf("migrated: from %s\n", sess.MigratedFrom)
+	}
+	if len(sess.Redacted) > 0 {
+		fmt.Printf("redacted: %s\n", strings.Join(sess.Redacted, ", "))
+	}
+	fmt.Printf("turns:    %d\n", (len(sess.History)+1)/2)
+
+	// Usage entries belong to model turns in order. Sessions that predate
+	// usage tracking have fewer entries than turns, so align from the end.
+	modelTurns := countModelTurns(sess.History)
+	turnUsage := sess.UsageHistory
+	usageOffset := modelTurns - len(turnUsage)
+
+	var extracted, modelIndex int
+	for i, c := range sess.History {
+		if c == nil {
+			continue
+		}
+		turn := i/2 + 1
+		fmt.Printf("\n[%d] %s\n", turn, c.Role)
+
+		var texts, refs []string
+		var images []*genai.Blob
+		var thoughts, signed int
+		for _, p := range c.Parts {
+			if p == nil {
+				continue
+			}
+			if p.ThoughtSignature != nil {
+				signed++
+			}
+			switch {
+			case p.InlineData != nil && len(p.InlineData.Data) > 0:
+				images = append(images, p.InlineData)
+			case isImagePart(p):
+				refs = append(refs, strings.TrimPrefix(p.FileData.FileURI, blobURIPrefix))
+			case p.Thought:
+				thoughts++
+			case p.Text != "":
+				texts = append(texts, p.Text)
+			}
+		}
+
+		label := "text"
+		if c.Role == "user" {
+			label = "prompt"
+		}
+		for _, t := range texts {
+			fmt.Printf("    %s: %s\n", label, t)
+		}
+		if thoughts > 0 {
+			fmt.Printf("    thoughts: %d parts\n", thoughts)
+		}
+
+		imgLabel := "output"
+		if c.Role == "user" {
+			imgLabel = "input"
+			if n := len(images) + len(refs); n > 0 {
+				fmt.Printf("    inputs: %d image(s)\n", n)
+			}
+		}
+		for _, name := range refs {
+			fmt.Printf("    %s: %s (not loaded)\n", imgLabel, name)
+		}
+		for _, blob := range images {
+			fmt.Printf("    %s: %s\n", imgLabel, describeBlob(blob))
+			if *extract != "" {
+				extracted++
+				name := fmt.Sprintf("%03d-turn%d-%s.png", extracted, turn, c.Role)
+				if err := extractBlob(blob, filepath.Join(*extract, name), *force); err != nil {
+					return err
+				}
+			}
+		}
+
+		if c.Role == "model" {
+			if signed > 0 {
+				fmt.Printf("    thought signature: yes (%d parts)\n", signed)
+			} else {
+				fmt.Printf("    thought signature: no\n")
+			}
+			if k := modelIndex - usageOffset; k >= 0 && k < len(turnUsage) {
+				fmt.Printf("    settings: %s\n", describeTurnUsage(turnUsage[k], sess.Size))
+			}
+			modelIndex++
+		}
+	}
+
+	if *extract != "" {
+		fmt.Fprintf(os.Stderr, "\nextracted %d images to %s\n", extracted, *extract)
+	}
+	return nil
+}
+
+// describeBlob summarizes an inline image as "WxH format (size)".
+func describeBlob(blob *genai.Blob) string {
+	desc := blob.MIMEType
+	if cfg, format, err := image.DecodeConfig(bytes.NewReader(blob.Data)); err == nil {
+		desc = fmt.Sprintf("%dx%d %s", cfg.Width, cfg.Height, format)
+	}
+	return fmt.Sprintf("%s (%s)", desc, formatSize(int64(len(blob.Data))))
+}
+
+func describeTurnUsage(u usageData, sessionSize string) string {
+	var fields []string
+	size := u.Size
+	if size == "" {
+		size = sessionSize
+	}
+	if size != "" {
+		fields = append(fields, "size "+size)
+	}
+	if u.Timestamp != "" {
+		fields = append(fields, u.Timestamp)
+	}
+	tokens := fmt.Sprintf("%s input / %s output tokens", formatTokenCount(u.PromptTokens), formatTokenCount(u.CandidateTokens))
+	if u.ThoughtsTokens > 0 {
+		tokens += fmt.Sprintf(" / %s thinking", formatTokenCount(u.ThoughtsTokens))
+	}
+	fields = append(fields, tokens)
+	return strings.Join(fields, ", ")
+}
+
+// extractBlob writes an inline image to path as PNG, transcoding other formats.
+func extractBlob(blob *genai.Blob, path string, force bool) error {
+	if _, err := os.Stat(path); err == nil && !force {
+		return fmt.Errorf("output file %q already exists (use -f to overwrite)", path)
+	}
+	data, err := ensurePNG(blob.Data)
+	if err != nil {
+		return fmt.Errorf("cannot extract %s image to %q: %v", blob.MIMEType, path, err)
+	}
+	if err := os.WriteFile(path, data, outputPerm); err != nil {
+		return fmt.Errorf("failed to write %q: %v", path, err)
+	}
+	return nil
+}
+
+// runSessionConvert rewrites a session file in place, switching between inline
+// image data and the external-blob format. Blob files are left on disk when
+// converting back to inline, since other sessions may share them.
+func runSessionConvert(args []string) error {
+	fs := flag.NewFlagSet("agentpix session convert", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+
+	external := fs.String("external", "", "blob directory to move images into")
+	inline := fs.Bool("inline", false, "embed images back into the session JSON")
+
+	const usage = "usage: agentpix session convert (--external <dir> | --inline) <session-file>"
+
+	if err := fs.Parse(args); err != nil {
+		return fmt.Errorf("%s", usage)
+	}
+	if fs.NArg() != 1 || (*external == "") == !*inline {
+		return fmt.Errorf("%s", usage)
+	}
+	path := fs.Arg(0)
+
+	sess, before, err := readSessionImages(path)
+	if err != nil {
+		return err
+	}
+
+	if *inline {
+		if sess.BlobDir == "" {
+			return fmt.Errorf("%q already stores images inline", path)
+		}
+		sess.BlobDir = ""
+	} else {
+		if isPNGFile(path) {
+			return fmt.Errorf("%q holds an embedded session, which always stores images inline", path)
+		}
+		sess.BlobDir = sessionRelative(path, *external)
+	}
+
+	if err := rewriteSession(path, *sess); err != nil {
+		return err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("failed to read %q: %v", path, err)
+	}
+	fmt.Fprintf(os.Stderr, "converted %s (%s -> %s)\n", path, formatSize(before), formatSize(info.Size()))
+	return nil
+}
+
+// runSessionUpgrade rewrites session files in the current format. The original
+// file is kept next to it with a .bak suffix, which clean and cost ignore.
+func runSessionUpgrade(args []string) error {
+	fs := flag.NewFlagSet("agentpix session upgrade", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+
+	recursive := fs.Bool("r", false, "scan directories recursively")
+
+	const usage = "usage: agentpix session upgrade [-r] <session-file|directory>"
+
+	if err := fs.Parse(args); err != nil {
+		return fmt.Errorf("%s", usage)
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("%s", usage)
+	}
+	target := fs.Arg(0)
+
+	info, err := os.Stat(target)
+	if err != nil {
+		return fmt.Errorf("cannot access %q: %v", target, err)
+	}
+	if !info.IsDir() {
+		upgraded, err := upgradeSessionFile(target)
+		if err != nil {
+			return err
+		}
+		if !upgraded {
+			fmt.Fprintf(os.Stderr, "%s is already version %d\n", target, sessionVersion)
+		}
+		return nil
+	}
+
+	paths, err := listFiles(target, *recursive, isSessionFile)
+	if err != nil {
+		return err
+	}
+	var count int
+	for _, p := range paths {
+		upgraded, err := upgradeSessionFile(p)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "skip %s: %v\n", p, err)
+			continue
+		}
+		if upgraded {
+			count++
+		}
+	}
+	fmt.Fprintf(os.Stderr, "upgraded %d of %d session files\n", count, len(paths))
+	return nil
+}
+
+// upgradeSessionFile rewrites one session file if it is in an older format.
+func upgradeSessionFile(path string) (bool, error) {
+	sess, _, err := readSession(path)
+	if err != nil {
+		return false, err
+	}
+	if sess.upgradedFrom == 0 {
+		return false, nil
+	}
+
+	backup := path + ".bak"
+	if _, err := os.Stat(backup); err == nil {
+		return false, fmt.Errorf("backup %q already exists", backup)
+	}
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return false, fmt.Errorf("failed to read %q: %v", path, err)
+	}
+	if err := os.WriteFile(backup, raw, outputPerm); err != nil {
+		return false, fmt.Errorf("failed to write backup: %v", err)
+	}
+	if err := rewriteSession(path, *sess); err != nil {
+		return false, err
+	}
+	fmt.Fprintf(os.Stderr, "upgraded %s (version %d -> %d, backup %s)\n", path, sess.upgradedFrom, sessionVersion, filepath.Base(backup))
+	return true, nil
+}
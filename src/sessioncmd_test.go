@@ -0,0 +1,324 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"google.golang.org/genai"
+)
+
+func TestRunSessionShow(t *testing.T) {
+	pngData := func(w, h int) []byte {
+		var buf bytes.Buffer
+		png.Encode(&buf, testImage(w, h))
+		return buf.Bytes()
+	}
+	sess := sessionData{
+		Model: "flash-3.1",
+		Ratio: "1:1",
+		Size:  "2K",
+		History: []*genai.Content{
+			{Role: "user", Parts: []*genai.Part{
+				{Text: "make it blue"},
+				{InlineData: &genai.Blob{MIMEType: "image/png", Data: pngData(4, 3)}},
+			}},
+			{Role: "model", Parts: []*genai.Part{
+				{Text: "Here you go"},
+				{InlineData: &genai.Blob{MIMEType: "image/png", Data: pngData(8, 6)}, ThoughtSignature: []byte("sig")},
+			}},
+		},
+		UsageHistory: []usageData{{PromptTokens: 100, CandidateTokens: 50, Images: 1, Timestamp: "2026-03-01T10:00:00Z"}},
+	}
+
+	t.Run("lists turns", func(t *testing.T) {
+		p := writeSessionFile(t, t.TempDir(), "a.session.json", sess)
+		if err := runSessionShow([]string{p}); err != nil {
+			t.Fatalf("runSessionShow: %v", err)
+		}
+	})
+
+	t.Run("extracts numbered PNGs", func(t *testing.T) {
+		dir := t.TempDir()
+		p := writeSessionFile(t, dir, "a.session.json", sess)
+		out := filepath.Join(dir, "images")
+		os.Mkdir(out, 0755)
+		if err := runSessionShow([]string{"-x", out, p}); err != nil {
+			t.Fatalf("runSessionShow: %v", err)
+		}
+		want := []string{"001-turn1-user.png", "002-turn1-model.png"}
+		for i, name := range want {
+			img := readPNG(t, filepath.Join(out, name))
+			if b := img.Bounds(); (i == 0 && b.Dx() != 4) || (i == 1 && b.Dx() != 8) {
+				t.Errorf("%s width = %d", name, b.Dx())
+			}
+		}
+
+		err := runSessionShow([]string{"-x", out, p})
+		if err == nil || !strings.Contains(err.Error(), "already exists") {
+			t.Fatalf("second extract err = %v, want already exists", err)
+		}
+		if err := runSessionShow([]string{"-x", out, "-f", p}); err != nil {
+			t.Fatalf("extract with -f: %v", err)
+		}
+	})
+
+	t.Run("missing blob directory", func(t *testing.T) {
+		dir := t.TempDir()
+		ext := sess
+		ext.BlobDir = "blobs"
+		p := filepath.Join(dir, "a.session.json")
+		if err := writeSession(p, ext); err != nil {
+			t.Fatal(err)
+		}
+		os.RemoveAll(filepath.Join(dir, "blobs"))
+		if err := runSessionShow([]string{p}); err != nil {
+			t.Errorf("show without -x: %v", err)
+		}
+		out := filepath.Join(dir, "images")
+		os.Mkdir(out, 0755)
+		if err := runSessionShow([]string{"-x", out, p}); err == nil || !strings.Contains(err.Error(), "missing session image") {
+			t.Errorf("show -x err = %v, want missing session image", err)
+		}
+	})
+
+	t.Run("missing extract dir", func(t *testing.T) {
+		p := writeSessionFile(t, t.TempDir(), "a.session.json", sess)
+		err := runSessionShow([]string{"-x", "/nonexistent_dir_xyz", p})
+		if err == nil || !strings.Contains(err.Error(), "does not exist") {
+			t.Fatalf("err = %v", err)
+		}
+	})
+
+	t.Run("usage errors", func(t *testing.T) {
+		if err := runSession(nil); err == nil || !strings.Contains(err.Error(), "usage") {
+			t.Errorf("runSession(nil) = %v", err)
+		}
+		if err := runSession([]string{"bogus"}); err == nil || !strings.Contains(err.Error(), "unknown session command") {
+			t.Errorf("runSession(bogus) = %v", err)
+		}
+		if err := runSessionShow(nil); err == nil || !strings.Contains(err.Error(), "usage") {
+			t.Errorf("runSessionShow(nil) = %v", err)
+		}
+	})
+}
+
+func TestDescribeBlob(t *testing.T) {
+	var buf bytes.Buffer
+	png.Encode(&buf, testImage(5, 7))
+	got := describeBlob(&genai.Blob{MIMEType: "image/png", Data: buf.Bytes()})
+	if !strings.HasPrefix(got, "5x7 png (") {
+		t.Errorf("describeBlob = %q", got)
+	}
+	got = describeBlob(&genai.Blob{MIMEType: "image/webp", Data: []byte("opaque")})
+	if got != "image/webp (6 B)" {
+		t.Errorf("describeBlob undecodable = %q", got)
+	}
+}
+
+// writeEmbeddedSessionPNG writes a PNG carrying sess in its session chunk,
+// as --embed-session does, but without bumping the version.
+func writeEmbeddedSessionPNG(t *testing.T, dir, name string, sess sessionData) string {
+	t.Helper()
+	raw, err := json.Marshal(sess)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write(raw)
+	zw.Close()
+	data, err := pngInsertChunk(minimalPNG(), pngChunk{Type: sessionChunkType, Data: buf.Bytes()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := filepath.Join(dir, name)
+	if err := os.WriteFile(p, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+// checkStillPNG fails the test unless path is still a PNG with the pixels of
+// minimalPNG.
+func checkStillPNG(t *testing.T, path string) {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := png.DecodeConfig(bytes.NewReader(data)); err != nil {
+		t.Fatalf("%s is no longer a PNG: %v (starts %.20q)", filepath.Base(path), err, data)
+	}
+	if want, _, _ := pngFindChunk(minimalPNG(), "IDAT"); !bytes.Equal(idatBytes(t, path), want) {
+		t.Errorf("%s image data changed", filepath.Base(path))
+	}
+}
+
+func TestRunSessionConvert(t *testing.T) {
+	dir := t.TempDir()
+	path := writeSessionFile(t, dir, "a.session.json", sessionData{Model: testFlashName, History: blobTestHistory()})
+
+	if err := runSessionConvert([]string{"--external", filepath.Join(dir, "blobs"), path}); err != nil {
+		t.Fatalf("convert to external: %v", err)
+	}
+	sess, _, err := readSession(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sess.BlobDir != "blobs" {
+		t.Errorf("BlobDir = %q, want %q", sess.BlobDir, "blobs")
+	}
+
+	if err := runSessionConvert([]string{"--inline", path}); err != nil {
+		t.Fatalf("convert to inline: %v", err)
+	}
+	sess, _, err = readSession(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sess.BlobDir != "" || string(sess.History[1].Parts[1].InlineData.Data) != "output-image" {
+		t.Errorf("inline session = %+v", sess)
+	}
+
+	err = runSessionConvert([]string{"--inline", path})
+	if err == nil || !strings.Contains(err.Error(), "already stores images inline") {
+		t.Errorf("second inline err = %v", err)
+	}
+	for _, args := range [][]string{{path}, {"--inline", "--external", "x", path}} {
+		if err := runSessionConvert(args); err == nil || !strings.Contains(err.Error(), "usage") {
+			t.Errorf("runSessionConvert(%v) = %v, want usage", args, err)
+		}
+	}
+
+	// Embedded sessions stay inline inside their PNG.
+	img := writeEmbeddedSessionPNG(t, dir, "cat.png", sessionData{Version: sessionVersion, Model: testFlashName, History: blobTestHistory()})
+	err = runSessionConvert([]string{"--external", filepath.Join(dir, "blobs"), img})
+	if err == nil || !strings.Contains(err.Error(), "always stores images inline") {
+		t.Errorf("convert embedded err = %v", err)
+	}
+	err = runSessionConvert([]string{"--inline", img})
+	if err == nil || !strings.Contains(err.Error(), "already stores images inline") {
+		t.Errorf("convert embedded --inline err = %v", err)
+	}
+	checkStillPNG(t, img)
+}
+
+func TestUpgradeSession(t *testing.T) {
+	tests := []struct {
+		name      string
+		sess      sessionData
+		wantFrom  int
+		wantUsage int
+		wantErr   string
+	}{
+		{
+			name:      "unversioned with legacy usage",
+			sess:      sessionData{Usage: &usageData{TotalTokens: 5}},
+			wantFrom:  1,
+			wantUsage: 1,
+		},
+		{
+			name:      "unversioned without usage",
+			sess:      sessionData{},
+			wantFrom:  1,
+			wantUsage: 0,
+		},
+		{
+			name:      "current",
+			sess:      sessionData{Version: sessionVersion, UsageHistory: []usageData{{}, {}}},
+			wantFrom:  0,
+			wantUsage: 2,
+		},
+		{
+			name:    "newer than supported",
+			sess:    sessionData{Version: sessionVersion + 1},
+			wantErr: "newer than this build supports",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sess := tt.sess
+			err := upgradeSession(&sess)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if sess.Version != sessionVersion || sess.upgradedFrom != tt.wantFrom {
+				t.Errorf("version = %d, upgradedFrom = %d, want %d from %d", sess.Version, sess.upgradedFrom, sessionVersion, tt.wantFrom)
+			}
+			if sess.Usage != nil || len(sess.UsageHistory) != tt.wantUsage {
+				t.Errorf("usage = %+v, history = %+v", sess.Usage, sess.UsageHistory)
+			}
+		})
+	}
+}
+
+func TestRunSessionUpgrade(t *testing.T) {
+	dir := t.TempDir()
+	history := []*genai.Content{{Role: "user", Parts: []*genai.Part{{Text: "a cat"}}}}
+	old := writeSessionFile(t, dir, "old.session.json", sessionData{Model: testFlashName, History: history, Usage: &usageData{TotalTokens: 7}})
+	current := filepath.Join(dir, "current.session.json")
+	if err := writeSession(current, sessionData{Model: testFlashName, History: history}); err != nil {
+		t.Fatal(err)
+	}
+	writeSessionFile(t, dir, "future.session.json", sessionData{Version: sessionVersion + 1, Model: testFlashName, History: history})
+	original, _ := os.ReadFile(old)
+
+	if err := runSessionUpgrade([]string{dir}); err != nil {
+		t.Fatal(err)
+	}
+
+	raw, _ := os.ReadFile(old)
+	if !bytes.Contains(raw, []byte(`"version":2`)) || bytes.Contains(raw, []byte(`"usage":`)) {
+		t.Errorf("upgraded file = %s", raw)
+	}
+	if backup, err := os.ReadFile(old + ".bak"); err != nil || !bytes.Equal(backup, original) {
+		t.Errorf("backup missing or changed: %v", err)
+	}
+	for _, name := range []string{"current.session.json.bak", "future.session.json.bak"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err == nil {
+			t.Errorf("unexpected backup %s", name)
+		}
+	}
+
+	// Upgrading again is a no-op; a stale backup blocks rewriting a file.
+	if err := runSessionUpgrade([]string{old}); err != nil {
+		t.Errorf("second upgrade: %v", err)
+	}
+	stale := writeSessionFile(t, dir, "stale.session.json", sessionData{Model: testFlashName, History: history})
+	os.WriteFile(stale+".bak", []byte("{}"), 0644)
+	if err := runSessionUpgrade([]string{stale}); err == nil || !strings.Contains(err.Error(), "already exists") {
+		t.Errorf("stale backup err = %v", err)
+	}
+	if err := runSessionUpgrade(nil); err == nil || !strings.Contains(err.Error(), "usage") {
+		t.Errorf("no args err = %v", err)
+	}
+
+	// A PNG with an old embedded session is upgraded inside the image.
+	img := writeEmbeddedSessionPNG(t, dir, "cat.png", sessionData{Model: testFlashName, History: history, Usage: &usageData{TotalTokens: 7}})
+	if err := runSessionUpgrade([]string{img}); err != nil {
+		t.Fatal(err)
+	}
+	checkStillPNG(t, img)
+	sess, _, err := readSession(img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sess.upgradedFrom != 0 || sess.Usage != nil || len(sess.UsageHistory) != 1 {
+		t.Errorf("embedded session not upgraded: %+v", sess)
+	}
+	if !isPNGFile(img + ".bak") {
+		t.Error("backup of the PNG is missing or not a PNG")
+	}
+}
@@ -76,6 +76,7 @@ func runTransform(args []string) error {
 
 	// Extract existing metadata to re-embed after transform.
 	existingMeta, _ := pngGetText(inputData, metadataKey)
+	existingXMP, _ := pngGetText(inputData, xmpKey)
 
 	var result image.Image
 	switch opArgs[0] {
@@ -123,7 +124,12 @@ func runTransform(args []string) error {
 
 	outData := buf.Bytes()
 	if existingMeta != "" {
-		if tagged, err := pngSetText(outData, metadataKey, existingMeta); err == nil {
+		if tagged, err := setMetadataText(outData, existingMeta); err == nil {
+			outData = tagged
+		}
+	}
+	if existingXMP != "" {
+		if tagged, err := pngSetITXt(outData, xmpKey, existingXMP, false); err == nil {
 			outData = tagged
 		}
 	}
@@ -222,9 +222,9 @@ func TestRunTransformPreservesMetadata(t *testing.T) {
 	// Create a PNG with embedded agentpix metadata.
 	var buf bytes.Buffer
 	png.Encode(&buf, testImage(4, 4))
-	tagged, err := pngSetText(buf.Bytes(), metadataKey, `{"model":"flash"}`)
+	tagged, err := withText(buf.Bytes(), metadataKey, `{"model":"flash"}`)
 	if err != nil {
-		t.Fatalf("pngSetText: %v", err)
+		t.Fatalf("withText: %v", err)
 	}
 	os.WriteFile(input, tagged, 0644)
 
@@ -245,6 +245,30 @@ func TestRunTransformPreservesMetadata(t *testing.T) {
 	}
 }
 
+func TestRunTransformPreservesXMP(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "input.png")
+	output := filepath.Join(dir, "output.png")
+
+	var buf bytes.Buffer
+	png.Encode(&buf, testImage(4, 4))
+	meta := imageMetadata{Version: metadataVersion, Model: "flash-3.1", ModelID: "gemini-3.1-flash-image-preview", Prompts: []promptEntry{{Role: "user", Text: "a cat"}}}
+	os.WriteFile(input, embedMetadata(buf.Bytes(), meta), 0644)
+
+	if err := runTransform([]string{"-i", input, "-o", output, "rotate", "90"}); err != nil {
+		t.Fatalf("runTransform: %v", err)
+	}
+
+	outData, _ := os.ReadFile(output)
+	val, err := pngGetText(outData, xmpKey)
+	if err != nil {
+		t.Fatalf("XMP lost after transform: %v", err)
+	}
+	if val != buildXMP(meta) {
+		t.Errorf("XMP changed by transform:\n%s", val)
+	}
+}
+
 func TestRunTransformOverwriteProtection(t *testing.T) {
 	dir := t.TempDir()
 	input := filepath.Join(dir, "input.png")
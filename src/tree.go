@@ -0,0 +1,349 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const treeUsage = "usage: agentpix tree [-r] [--format text|dot] <directory>"
+
+// newSessionParent records the session a continuation is loaded from. The
+// path is kept as given; callers make it relative to the new session file.
+func newSessionParent(path string, sess *sessionData) (*sessionParent, error) {
+	sum, err := fileSHA256(path)
+	if err != nil {
+		return nil, err
+	}
+	return &sessionParent{Path: path, SHA256: sum, Turn: (len(sess.History) + 1) / 2}, nil
+}
+
+func fileSHA256(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read %q: %v", path, err)
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// treeNode is a session, or an image without a session of its own, in the
+// branch graph. Parents that were not part of the scan appear as placeholder
+// nodes so their branches still group together.
+type treeNode struct {
+	key         string // absolute path of the session or image
+	name        string // path relative to the scanned directory
+	model       string
+	turns       int
+	images      []string
+	session     bool
+	placeholder bool
+	missing     bool   // placeholder for a parent that no longer exists
+	parent      string // key of the parent node
+	parentSHA   string // parent hash recorded at branch time
+	turn        int    // parent turn the branch continued after; 0 if unknown
+	changed     bool   // parent file no longer matches the recorded hash
+	children    []*treeNode
+}
+
+// state describes the node itself, without its relation to the parent.
+func (n *treeNode) state() string {
+	switch {
+	case n.missing:
+		return "missing"
+	case n.placeholder:
+		return "outside directory"
+	case !n.session:
+		return "no session"
+	case n.turns == 1:
+		return n.model + ", 1 turn"
+	}
+	return fmt.Sprintf("%s, %d turns", n.model, n.turns)
+}
+
+func (n *treeNode) summary() string {
+	fields := []string{n.state()}
+	if n.turn > 0 {
+		fields = append(fields, fmt.Sprintf("from turn %d", n.turn))
+	}
+	if n.changed {
+		fields = append(fields, "parent changed since branching")
+	}
+	return strings.Join(fields, ", ")
+}
+
+func runTree(args []string) error {
+	fs := flag.NewFlagSet("agentpix tree", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+
+	recursive := fs.Bool("r", false, "scan directories recursively")
+	format := fs.String("format", "text", "output format: text or dot")
+
+	if err := fs.Parse(args); err != nil {
+		return fmt.Errorf("%s", treeUsage)
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("%s", treeUsage)
+	}
+	dir := fs.Arg(0)
+	if *format != "text" && *format != "dot" {
+		return fmt.Errorf("invalid --format %q: use text or dot", *format)
+	}
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		return fmt.Errorf("%q is not a directory", dir)
+	}
+
+	roots, err := buildTree(dir, *recursive)
+	if err != nil {
+		return err
+	}
+	if len(roots) == 0 {
+		fmt.Fprintf(os.Stderr, "no sessions or generated images found in %s\n", dir)
+		return nil
+	}
+	if *format == "dot" {
+		writeTreeDOT(os.Stdout, roots)
+	} else {
+		writeTreeText(os.Stdout, roots)
+	}
+	return nil
+}
+
+// buildTree links the sessions and generated images in dir into a forest.
+// Parents come from the session's recorded parent reference or, for sessions
+// written before lineage was tracked, from the source session named in the
+// metadata of the session's image.
+func buildTree(dir string, recursive bool) ([]*treeNode, error) {
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, fmt.Errorf("cannot resolve %q: %v", dir, err)
+	}
+	paths, err := listFiles(absDir, recursive, func(name string) bool {
+		return isSessionFile(name) || isPNGPath(name)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	nodes := make(map[string]*treeNode)
+	name := func(p string) string {
+		if rel, err := filepath.Rel(absDir, p); err == nil {
+			return rel
+		}
+		return p
+	}
+
+	for _, p := range paths {
+		if !isSessionFile(p) {
+			continue
+		}
+		sess, _, err := readSession(p)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "skip %s: %v\n", p, err)
+			continue
+		}
+		n := &treeNode{key: p, name: name(p), model: sess.Model, turns: (len(sess.History) + 1) / 2, session: true}
+		if n.model == "" {
+			n.model = "legacy"
+		}
+		if sess.Parent != nil {
+			n.parent = filepath.Clean(resolveSessionRelative(p, sess.Parent.Path))
+			n.turn = sess.Parent.Turn
+			n.parentSHA = sess.Parent.SHA256
+		}
+		nodes[p] = n
+	}
+
+	owners := make(map[string]string) // image path -> key of its session's node
+	for _, p := range paths {
+		if !isPNGPath(p) {
+			continue
+		}
+		owner := nodes[sessionPath(p, false)]
+		if owner == nil {
+			owner = nodes[sessionPath(p, true)]
+		}
+		meta, err := readImageMetadata(p)
+		if err != nil && !errors.Is(err, errNoMetadata) {
+			fmt.Fprintf(os.Stderr, "skip %s: %v\n", p, err)
+			continue
+		}
+		if owner != nil {
+			owner.images = append(owner.images, filepath.Base(p))
+			owners[p] = owner.key
+			if owner.parent == "" && meta != nil && meta.Session != "" {
+				owner.parent = filepath.Join(filepath.Dir(p), meta.Session)
+				owner.turn = meta.Rewind
+			}
+			continue
+		}
+		if meta == nil || meta.ImportedFrom != "" {
+			continue // not generated by agentpix
+		}
+		n := &treeNode{key: p, name: name(p), turn: meta.Rewind}
+		if meta.Session != "" {
+			n.parent = filepath.Join(filepath.Dir(p), meta.Session)
+		}
+		nodes[p] = n
+	}
+
+	hashes := make(map[string]string)
+	for _, n := range nodes {
+		if n.parentSHA == "" || !fileExists(n.parent) {
+			continue
+		}
+		h, ok := hashes[n.parent]
+		if !ok {
+			h, _ = fileSHA256(n.parent)
+			hashes[n.parent] = h
+		}
+		n.changed = h != n.parentSHA
+	}
+
+	// A session continued from a PNG with an embedded session (-s image.png)
+	// records the image as its parent; attach it to the session that owns
+	// the image.
+	for _, n := range nodes {
+		if key, ok := owners[n.parent]; ok {
+			n.parent = key
+		}
+	}
+
+	var keys []string
+	for k := range nodes {
+		keys = append(keys, k)
+	}
+	for _, k := range keys {
+		n := nodes[k]
+		if n.parent == n.key {
+			// The session overwrote its own source with -f.
+			n.parent, n.turn, n.changed = "", 0, false
+		}
+		if n.parent == "" {
+			continue
+		}
+		if _, ok := nodes[n.parent]; !ok {
+			nodes[n.parent] = &treeNode{key: n.parent, name: name(n.parent), session: true, placeholder: true, missing: !fileExists(n.parent)}
+		}
+	}
+
+	var roots []*treeNode
+	for _, n := range nodes {
+		if parent, ok := nodes[n.parent]; ok && !createsCycle(nodes, n) {
+			parent.children = append(parent.children, n)
+		} else {
+			roots = append(roots, n)
+		}
+	}
+	for _, n := range nodes {
+		sortTreeNodes(n.children)
+		sort.Strings(n.images)
+	}
+	sortTreeNodes(roots)
+	return roots, nil
+}
+
+// createsCycle reports whether following parents from n leads back to n.
+func createsCycle(nodes map[string]*treeNode, n *treeNode) bool {
+	seen := map[*treeNode]bool{n: true}
+	for p := nodes[n.parent]; p != nil; p = nodes[p.parent] {
+		if seen[p] {
+			return p == n
+		}
+		seen[p] = true
+	}
+	return false
+}
+
+func sortTreeNodes(nodes []*treeNode) {
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].name < nodes[j].name })
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+func writeTreeText(w io.Writer, roots []*treeNode) {
+	var walk func(n *treeNode, prefix string, last, root bool)
+	walk = func(n *treeNode, prefix string, last, root bool) {
+		branch, next := "", ""
+		if !root {
+			branch, next = "├── ", "│   "
+			if last {
+				branch, next = "└── ", "    "
+			}
+		}
+		line := fmt.Sprintf("%s%s%s  (%s)", prefix, branch, n.name, n.summary())
+		if len(n.images) > 0 {
+			line += "  " + strings.Join(n.images, ", ")
+		}
+		fmt.Fprintln(w, line)
+		for i, c := range n.children {
+			walk(c, prefix+next, i == len(n.children)-1, false)
+		}
+	}
+	for _, r := range roots {
+		walk(r, "", true, true)
+	}
+}
+
+func writeTreeDOT(w io.Writer, roots []*treeNode) {
+	ids := make(map[*treeNode]string)
+	var order []*treeNode
+	var collect func(n *treeNode)
+	collect = func(n *treeNode) {
+		ids[n] = fmt.Sprintf("n%d", len(order))
+		order = append(order, n)
+		for _, c := range n.children {
+			collect(c)
+		}
+	}
+	for _, r := range roots {
+		collect(r)
+	}
+
+	fmt.Fprintln(w, "digraph agentpix {")
+	fmt.Fprintln(w, "  rankdir=LR;")
+	fmt.Fprintln(w, "  node [shape=box];")
+	for _, n := range order {
+		label := append([]string{n.name, n.state()}, n.images...)
+		attrs := fmt.Sprintf("label=%s", dotQuote(strings.Join(label, "\n")))
+		if n.placeholder {
+			attrs += ", style=dashed"
+		} else if !n.session {
+			attrs += ", shape=note"
+		}
+		fmt.Fprintf(w, "  %s [%s];\n", ids[n], attrs)
+	}
+	for _, n := range order {
+		for _, c := range n.children {
+			var attrs []string
+			if c.turn > 0 {
+				attrs = append(attrs, "label="+dotQuote(fmt.Sprintf("turn %d", c.turn)))
+			}
+			if c.changed {
+				attrs = append(attrs, "style=dashed")
+			}
+			if len(attrs) > 0 {
+				fmt.Fprintf(w, "  %s -> %s [%s];\n", ids[n], ids[c], strings.Join(attrs, ", "))
+			} else {
+				fmt.Fprintf(w, "  %s -> %s;\n", ids[n], ids[c])
+			}
+		}
+	}
+	fmt.Fprintln(w, "}")
+}
+
+// dotQuote quotes s as a Graphviz string, with newlines as line breaks.
+func dotQuote(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	return `"` + r.Replace(s) + `"`
+}
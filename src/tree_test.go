@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"google.golang.org/genai"
+)
+
+func TestNewSessionParent(t *testing.T) {
+	dir := t.TempDir()
+	sess := sessionData{Model: testFlashName, History: []*genai.Content{
+		{Role: "user", Parts: []*genai.Part{{Text: "a cat"}}},
+		{Role: "model", Parts: []*genai.Part{{Text: "here"}}},
+		{Role: "user", Parts: []*genai.Part{{Text: "make it blue"}}},
+		{Role: "model", Parts: []*genai.Part{{Text: "done"}}},
+	}}
+	p := writeSessionFile(t, dir, "cat.session.json", sess)
+
+	parent, err := newSessionParent(p, &sess)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parent.Turn != 2 {
+		t.Errorf("Turn = %d, want 2", parent.Turn)
+	}
+	want, _ := fileSHA256(p)
+	if parent.SHA256 != want || len(want) != 64 {
+		t.Errorf("SHA256 = %q, want %q", parent.SHA256, want)
+	}
+
+	if _, err := newSessionParent(filepath.Join(dir, "missing.session.json"), &sess); err == nil {
+		t.Error("expected error for missing session file")
+	}
+}
+
+// writeTreeFixture builds a directory with a root session, a child branched
+// from it, a grandchild whose parent changed after branching, an image that
+// lost its session, a session with a missing parent, and an unrelated PNG.
+func writeTreeFixture(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	one := []*genai.Content{
+		{Role: "user", Parts: []*genai.Part{{Text: "a cat"}}},
+		{Role: "model", Parts: []*genai.Part{{Text: "here"}}},
+	}
+	two := append(one,
+		&genai.Content{Role: "user", Parts: []*genai.Part{{Text: "make it blue"}}},
+		&genai.Content{Role: "model", Parts: []*genai.Part{{Text: "done"}}},
+	)
+	meta := imageMetadata{Version: 1, Model: testFlashName, Timestamp: "2026-03-01T10:00:00Z"}
+
+	root := writeSessionFile(t, dir, "cat.session.json", sessionData{Model: testFlashName, History: one})
+	writeMetaPNG(t, dir, "cat.png", meta)
+	rootSum, _ := fileSHA256(root)
+
+	writeSessionFile(t, dir, "blue.session.json", sessionData{Model: testFlashName, History: two,
+		Parent: &sessionParent{Path: "cat.session.json", SHA256: rootSum, Turn: 1}})
+	writeMetaPNG(t, dir, "blue.png", meta)
+
+	writeSessionFile(t, dir, "navy.session.json", sessionData{Model: testFlashName, History: two,
+		Parent: &sessionParent{Path: "blue.session.json", SHA256: strings.Repeat("0", 64), Turn: 2}})
+
+	// Legacy branch: no session of its own, parent known from metadata only.
+	legacy := meta
+	legacy.Session = "cat.session.json"
+	writeMetaPNG(t, dir, "red.png", legacy)
+
+	writeSessionFile(t, dir, "lost.session.json", sessionData{Model: testProName, History: one,
+		Parent: &sessionParent{Path: "gone.session.json", SHA256: rootSum, Turn: 3}})
+
+	os.WriteFile(filepath.Join(dir, "photo.png"), minimalPNG(), 0644)
+	return dir
+}
+
+func TestBuildTree(t *testing.T) {
+	dir := writeTreeFixture(t)
+	roots, err := buildTree(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	writeTreeText(&buf, roots)
+	want := "cat.session.json  (" + testFlashName + ", 1 turn)  cat.png\n" +
+		"├── blue.session.json  (" + testFlashName + ", 2 turns, from turn 1)  blue.png\n" +
+		"│   └── navy.session.json  (" + testFlashName + ", 2 turns, from turn 2, parent changed since branching)\n" +
+		"└── red.png  (no session)\n" +
+		"gone.session.json  (missing)\n" +
+		"└── lost.session.json  (" + testProName + ", 1 turn, from turn 3)\n"
+	if buf.String() != want {
+		t.Errorf("text tree:\n%s\nwant:\n%s", buf.String(), want)
+	}
+
+	buf.Reset()
+	writeTreeDOT(&buf, roots)
+	dot := buf.String()
+	for _, s := range []string{
+		"digraph agentpix {",
+		`n0 [label="cat.session.json\n` + testFlashName + `, 1 turn\ncat.png"];`,
+		`n0 -> n1 [label="turn 1"];`,
+		`n1 -> n2 [label="turn 2", style=dashed];`,
+		`n3 [label="red.png\nno session", shape=note];`,
+		`n4 [label="gone.session.json\nmissing", style=dashed];`,
+	} {
+		if !strings.Contains(dot, s) {
+			t.Errorf("DOT output missing %q:\n%s", s, dot)
+		}
+	}
+}
+
+func TestBuildTreeSelfParent(t *testing.T) {
+	dir := t.TempDir()
+	// A session written with -f over its own source points at itself.
+	writeSessionFile(t, dir, "cat.session.json", sessionData{Model: testFlashName, History: []*genai.Content{},
+		Parent: &sessionParent{Path: "cat.session.json", SHA256: "stale", Turn: 1}})
+	roots, err := buildTree(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(roots) != 1 || roots[0].summary() != testFlashName+", 0 turns" {
+		t.Errorf("roots = %+v", roots)
+	}
+}
+
+func TestRunTree(t *testing.T) {
+	dir := writeTreeFixture(t)
+	tests := []struct {
+		name    string
+		args    []string
+		wantErr string
+	}{
+		{name: "text", args: []string{dir}},
+		{name: "dot", args: []string{"--format", "dot", dir}},
+		{name: "recursive", args: []string{"-r", dir}},
+		{name: "bad format", args: []string{"--format", "svg", dir}, wantErr: "invalid --format"},
+		{name: "missing argument", args: nil, wantErr: "usage:"},
+		{name: "not a directory", args: []string{filepath.Join(dir, "cat.png")}, wantErr: "not a directory"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := runTree(tt.args)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"runtime/debug"
+	"strings"
+)
+
+// xmpKey is the iTXt keyword under which PNG stores an XMP packet.
+const xmpKey = "XML:com.adobe.xmp"
+
+// digitalSourceTypeAI is the IPTC digital source type for media created by a
+// generative model.
+const digitalSourceTypeAI = "http://cv.iptc.org/newscodes/digitalsourcetype/trainedAlgorithmicMedia"
+
+// version is set for release builds with -ldflags "-X main.version=...".
+var version string
+
+// buildVersion returns the agentpix version: the release version, else the
+// module version Go recorded at build time, else "dev".
+func buildVersion() string {
+	if version != "" {
+		return version
+	}
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" && info.Main.Version != "(devel)" {
+		return info.Main.Version
+	}
+	return "dev"
+}
+
+// buildXMP renders an XMP packet declaring the image AI-generated: IPTC
+// DigitalSourceType, the model as the AI system used, the user prompts as
+// dc:description and AI prompt information, and agentpix as CreatorTool.
+func buildXMP(meta imageMetadata) string {
+	var prompts []string
+	for _, p := range meta.Prompts {
+		if p.Role == "user" {
+			prompts = append(prompts, p.Text)
+		}
+	}
+	prompt := strings.Join(prompts, "\n")
+
+	var b strings.Builder
+	b.WriteString("<?xpacket begin=\"\ufeff\" id=\"W5M0MpCehiHzreSzNTczkc9d\"?>\n")
+	b.WriteString("<x:xmpmeta xmlns:x=\"adobe:ns:meta/\">\n")
+	b.WriteString(" <rdf:RDF xmlns:rdf=\"http://www.w3.org/1999/02/22-rdf-syntax-ns#\">\n")
+	b.WriteString("  <rdf:Description rdf:about=\"\"\n")
+	b.WriteString("    xmlns:dc=\"http://purl.org/dc/elements/1.1/\"\n")
+	b.WriteString("    xmlns:xmp=\"http://ns.adobe.com/xap/1.0/\"\n")
+	b.WriteString("    xmlns:Iptc4xmpExt=\"http://iptc.org/std/Iptc4xmpExt/2008-02-29/\"\n")
+	b.WriteString("    xmp:CreatorTool=\"agentpix " + xmlEscape(buildVersion()) + "\"\n")
+	if meta.Timestamp != "" {
+		b.WriteString("    xmp:CreateDate=\"" + xmlEscape(meta.Timestamp) + "\"\n")
+	}
+	b.WriteString("    Iptc4xmpExt:DigitalSourceType=\"" + digitalSourceTypeAI + "\"\n")
+	b.WriteString("    Iptc4xmpExt:AISystemUsed=\"" + xmlEscape(meta.ModelID) + "\"")
+	if prompt != "" {
+		b.WriteString("\n    Iptc4xmpExt:AIPromptInformation=\"" + xmlEscape(prompt) + "\">\n")
+		b.WriteString("   <dc:description>\n    <rdf:Alt>\n")
+		b.WriteString("     <rdf:li xml:lang=\"x-default\">" + xmlEscape(prompt) + "</rdf:li>\n")
+		b.WriteString("    </rdf:Alt>\n   </dc:description>\n")
+		b.WriteString("  </rdf:Description>\n")
+	} else {
+		b.WriteString("/>\n")
+	}
+	b.WriteString(" </rdf:RDF>\n</x:xmpmeta>\n")
+	b.WriteString("<?xpacket end=\"w\"?>")
+	return b.String()
+}
+
+func xmlEscape(s string) string {
+	var buf bytes.Buffer
+	xml.EscapeText(&buf, []byte(s))
+	return buf.String()
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/xml"
+	"strings"
+	"testing"
+)
+
+func TestBuildXMP(t *testing.T) {
+	tests := []struct {
+		name     string
+		meta     imageMetadata
+		wantDesc string
+	}{
+		{
+			name: "prompt history",
+			meta: imageMetadata{
+				ModelID:   "gemini-3.1-flash-image-preview",
+				Timestamp: "2026-02-26T12:00:00Z",
+				Prompts: []promptEntry{
+					{Role: "user", Text: "a cat & a <dog>"},
+					{Role: "model", Text: "here you go"},
+					{Role: "user", Text: `make it "blue"`},
+				},
+			},
+			wantDesc: "a cat & a <dog>\nmake it \"blue\"",
+		},
+		{
+			name: "no prompts",
+			meta: imageMetadata{ModelID: "gemini-3-pro-image-preview"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			packet := buildXMP(tt.meta)
+			if !strings.HasPrefix(packet, "<?xpacket begin=\"\ufeff\"") || !strings.HasSuffix(packet, `<?xpacket end="w"?>`) {
+				t.Errorf("missing xpacket wrapper:\n%s", packet)
+			}
+
+			var doc struct {
+				Description struct {
+					CreatorTool string `xml:"CreatorTool,attr"`
+					SourceType  string `xml:"DigitalSourceType,attr"`
+					AISystem    string `xml:"AISystemUsed,attr"`
+					Prompt      string `xml:"AIPromptInformation,attr"`
+					Desc        string `xml:"description>Alt>li"`
+				} `xml:"RDF>Description"`
+			}
+			if err := xml.Unmarshal([]byte(packet), &doc); err != nil {
+				t.Fatalf("packet is not well-formed XML: %v\n%s", err, packet)
+			}
+			d := doc.Description
+			if d.SourceType != digitalSourceTypeAI {
+				t.Errorf("DigitalSourceType = %q", d.SourceType)
+			}
+			if d.AISystem != tt.meta.ModelID {
+				t.Errorf("AISystemUsed = %q, want %q", d.AISystem, tt.meta.ModelID)
+			}
+			if d.CreatorTool != "agentpix "+buildVersion() {
+				t.Errorf("CreatorTool = %q", d.CreatorTool)
+			}
+			if d.Desc != tt.wantDesc || d.Prompt != tt.wantDesc {
+				t.Errorf("description = %q, prompt = %q, want %q", d.Desc, d.Prompt, tt.wantDesc)
+			}
+		})
+	}
+}